@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/OpenCHAMI/cloud-init/pkg/cistore"
+	"github.com/OpenCHAMI/jwtauth/v5"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/rs/zerolog/log"
+)
+
+// auditTarget identifies the entity a mutating admin request acts on
+type auditTarget func(r *http.Request) (cistore.EntityType, string)
+
+func clusterDefaultsTarget(r *http.Request) (cistore.EntityType, string) {
+	return cistore.EntityClusterDefaults, ""
+}
+
+func instanceTarget(r *http.Request) (cistore.EntityType, string) {
+	return cistore.EntityInstance, chi.URLParam(r, "id")
+}
+
+// smdCacheTarget identifies the SMD cache, which isn't in the store, so its
+// audit entries only record who refreshed it and when
+func smdCacheTarget(r *http.Request) (cistore.EntityType, string) {
+	return cistore.EntitySMDCache, ""
+}
+
+func wireGuardPeerTarget(r *http.Request) (cistore.EntityType, string) {
+	return cistore.EntityWireGuardPeer, chi.URLParam(r, "name")
+}
+
+// groupTarget identifies the group by the given URL parameter
+func groupTarget(param string) auditTarget {
+	return func(r *http.Request) (cistore.EntityType, string) {
+		return cistore.EntityGroup, chi.URLParam(r, param)
+	}
+}
+
+// groupBodyTarget identifies the group by the name in the request body, which
+// is restored for the handler
+func groupBodyTarget(r *http.Request) (cistore.EntityType, string) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return cistore.EntityGroup, ""
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	var group struct {
+		Name string `json:"name"`
+	}
+	_ = json.Unmarshal(body, &group) // Ignoring error as the handler reports invalid bodies
+	return cistore.EntityGroup, group.Name
+}
+
+// auditSnapshot returns the current value of an entity, or nil if it does
+// not exist
+func auditSnapshot(store cistore.Store, entity cistore.EntityType, name string) interface{} {
+	switch entity {
+	case cistore.EntityGroup:
+		if name == "" {
+			return nil
+		}
+		if group, err := store.GetGroupData(name); err == nil {
+			return group
+		}
+	case cistore.EntityInstance:
+		if info, err := store.GetInstanceInfo(name); err == nil {
+			return info
+		}
+	case cistore.EntityClusterDefaults:
+		if defaults, err := store.GetClusterDefaults(); err == nil {
+			return defaults
+		}
+	case cistore.EntityWireGuardPeer:
+		if peers, err := store.GetWireGuardPeers(); err == nil {
+			for _, peer := range peers {
+				if peer.Name == name {
+					return peer
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// AuditMiddleware records every request to a mutating admin route in the
+// store's audit trail, along with the caller and the change it made to the
+// entity identified by target. Requests are recorded whether or not they
+// succeed.
+func AuditMiddleware(store cistore.Store, target auditTarget) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			entity, name := target(r)
+			before := auditSnapshot(store, entity, name)
+
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r)
+
+			after := auditSnapshot(store, entity, name)
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			entry := cistore.AuditEntry{
+				Time:      time.Now().UTC(),
+				SourceIP:  getActualRequestIP(r),
+				RequestID: middleware.GetReqID(r.Context()),
+				Method:    r.Method,
+				Path:      r.URL.Path,
+				Status:    status,
+				Entity:    entity,
+				Name:      name,
+				Before:    before,
+				After:     after,
+				Diff:      cistore.AuditDiff(before, after),
+			}
+			if token, _, err := jwtauth.FromContext(r.Context()); err == nil && token != nil {
+				entry.Subject = token.Subject()
+			}
+			if err := store.AddAuditEntry(entry); err != nil {
+				log.Error().Err(err).Msgf("failed to record audit entry for %s %s", r.Method, r.URL.Path)
+			}
+		})
+	}
+}
+
+// GetAuditHandler godoc
+//
+//	@Summary		Get the audit trail of admin API changes
+//	@Description	Get the recorded mutating calls to the admin API, newest
+//	@Description	first. Each entry holds the caller's JWT subject (when
+//	@Description	authentication is enabled), source IP, and request ID, along
+//	@Description	with the entity before and after the call and the fields
+//	@Description	that changed.
+//	@Tags			admin,audit
+//	@Produce		json
+//	@Success		200		{object}	[]cistore.AuditEntry
+//	@Failure		400		{object}	nil
+//	@Failure		500		{object}	nil
+//	@Param			entity	query		string	false	"Entity type"	Enums(group, instance, cluster-defaults, wireguard-peer, smd-cache)
+//	@Param			name	query		string	false	"Group name, node ID, or WireGuard peer name"
+//	@Param			subject	query		string	false	"JWT subject of the caller"
+//	@Param			since	query		string	false	"Only entries at or after this RFC 3339 time"
+//	@Param			limit	query		int		false	"Maximum number of entries"
+//	@Router			/admin/audit [get]
+func GetAuditHandler(store cistore.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		filter := cistore.AuditFilter{
+			Entity:  cistore.EntityType(query.Get("entity")),
+			Name:    query.Get("name"),
+			Subject: query.Get("subject"),
+		}
+		if since := query.Get("since"); since != "" {
+			t, err := time.Parse(time.RFC3339, since)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid since %q: must be an RFC 3339 time", since), http.StatusBadRequest)
+				return
+			}
+			filter.Since = t
+		}
+		if limit := query.Get("limit"); limit != "" {
+			n, err := strconv.Atoi(limit)
+			if err != nil || n < 0 {
+				http.Error(w, fmt.Sprintf("invalid limit %q", limit), http.StatusBadRequest)
+				return
+			}
+			filter.Limit = n
+		}
+
+		entries, err := store.GetAuditEntries(filter)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		jsonData, err := json.Marshal(entries)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if _, err := w.Write(jsonData); err != nil {
+			log.Error().Err(err).Msg("failed to write response")
+		}
+	}
+}
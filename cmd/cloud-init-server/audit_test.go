@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/OpenCHAMI/cloud-init/internal/memstore"
+	"github.com/OpenCHAMI/cloud-init/internal/smdclient"
+	"github.com/OpenCHAMI/cloud-init/pkg/cistore"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditTrail(t *testing.T) {
+	store := memstore.NewMemStore()
+	handler := &CiHandler{sm: smdclient.NewFakeSMDClient("test", 10), store: store}
+	router := chi.NewRouter()
+	router.Use(middleware.RequestID)
+	initCiAdminRouter(router, handler, nil, nil)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.RemoteAddr = "10.0.0.5:1234"
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/admin/groups", `{"name": "compute", "description": "old"}`).Code)
+	require.Equal(t, http.StatusCreated, do(http.MethodPut, "/admin/groups/compute", `{"name": "compute", "description": "new"}`).Code)
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/admin/cluster-defaults", `{"cluster-name": "demo"}`).Code)
+	require.Equal(t, http.StatusUnprocessableEntity, do(http.MethodPut, "/admin/groups/compute", `not json`).Code)
+	require.Equal(t, http.StatusOK, do(http.MethodDelete, "/admin/groups/compute", "").Code)
+	// Reads are not audited
+	require.Equal(t, http.StatusOK, do(http.MethodGet, "/admin/groups", "").Code)
+
+	rr := do(http.MethodGet, "/admin/audit?entity=group", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var entries []cistore.AuditEntry
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &entries))
+	require.Len(t, entries, 4)
+
+	// Newest first
+	assert.Equal(t, http.MethodDelete, entries[0].Method)
+	assert.Nil(t, entries[0].After)
+	assert.Equal(t, http.StatusUnprocessableEntity, entries[1].Status)
+	assert.Empty(t, entries[1].Diff)
+
+	update := entries[2]
+	assert.Equal(t, "compute", update.Name)
+	assert.Equal(t, "10.0.0.5", update.SourceIP)
+	assert.NotEmpty(t, update.RequestID)
+	assert.Equal(t, "/admin/groups/compute", update.Path)
+	assert.Equal(t, cistore.AuditChange{Before: "old", After: "new"}, update.Diff["description"])
+
+	create := entries[3]
+	assert.Equal(t, http.MethodPost, create.Method)
+	assert.Equal(t, "compute", create.Name)
+	assert.Nil(t, create.Before)
+
+	rr = do(http.MethodGet, "/admin/audit?entity=cluster-defaults&limit=1", "")
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &entries))
+	require.Len(t, entries, 1)
+	assert.Equal(t, "demo", entries[0].Diff["cluster-name"].After)
+
+	// Refreshes of the SMD cache are recorded without a snapshot
+	require.Equal(t, http.StatusOK, do(http.MethodPost, "/admin/smd/refresh", "").Code)
+	rr = do(http.MethodGet, "/admin/audit?entity=smd-cache", "")
+	var refreshes []cistore.AuditEntry
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &refreshes))
+	require.Len(t, refreshes, 1)
+	assert.Equal(t, "/admin/smd/refresh", refreshes[0].Path)
+	assert.Nil(t, refreshes[0].Before)
+	assert.Nil(t, refreshes[0].After)
+
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, "/admin/audit?since=yesterday", "").Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, "/admin/audit?limit=-1", "").Code)
+}
@@ -9,9 +9,25 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	jwtauth "github.com/OpenCHAMI/jwtauth/v5"
 	"github.com/lestrrat-go/jwx/v2/jwk"
+	openchami_authenticator "github.com/openchami/chi-middleware/auth"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// jwksMinRefresh is the shortest interval between scheduled refreshes,
+	// however short a lifetime the keyserver's cache headers give
+	jwksMinRefresh = time.Minute
+	// jwksInitialBackoff and jwksMaxBackoff bound the delay between retries
+	// after a failed fetch
+	jwksInitialBackoff = time.Second
+	jwksMaxBackoff     = 5 * time.Minute
 )
 
 type statusCheckTransport struct {
@@ -21,6 +37,7 @@ type statusCheckTransport struct {
 func (ct *statusCheckTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 	resp, err := http.DefaultTransport.RoundTrip(req)
 	if err == nil && resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
 		return nil, fmt.Errorf("status code: %d", resp.StatusCode)
 	}
 
@@ -31,31 +48,264 @@ func newHTTPClient() *http.Client {
 	return &http.Client{Transport: &statusCheckTransport{}}
 }
 
-func fetchPublicKeyFromURL(url string) (*jwtauth.JWTAuth, error) {
-	client := newHTTPClient()
-
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	set, err := jwk.Fetch(ctx, url, jwk.WithHTTPClient(client))
+// fetchPublicKeyFromURL fetches the JWKS at url. It also returns how long the
+// keys may be cached according to the response's Cache-Control or Expires
+// headers, or zero if the response doesn't say.
+func fetchPublicKeyFromURL(ctx context.Context, client *http.Client, url string) (*jwtauth.JWTAuth, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		msg := "%w"
-
-		// if the error tree contains an EOF, it means that the response was empty,
-		// so add a more descriptive message to the error tree
-		if errors.Is(err, io.EOF) {
-			msg = "received empty response for key: %w"
-		}
+		return nil, 0, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read JWKS: %w", err)
+	}
+	if len(body) == 0 {
+		// an empty response would otherwise surface as an unhelpful parse error
+		return nil, 0, fmt.Errorf("received empty response for key: %w", io.EOF)
+	}
 
-		return nil, fmt.Errorf(msg, err)
+	set, err := jwk.Parse(body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to parse JWKS: %w", err)
 	}
 	jwks, err := json.Marshal(set)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal JWKS: %v", err)
+		return nil, 0, fmt.Errorf("failed to marshal JWKS: %v", err)
 	}
 	keyset, err := jwtauth.NewKeySet(jwks)
 	if err != nil {
-		return nil, fmt.Errorf("failed to initialize JWKS: %v", err)
+		return nil, 0, fmt.Errorf("failed to initialize JWKS: %v", err)
+	}
+
+	return keyset, cacheLifetime(resp.Header, time.Now()), nil
+}
+
+// cacheLifetime returns how long a response may be cached according to its
+// Cache-Control max-age directive or, failing that, its Expires header. It
+// returns zero if neither is present or the response must not be cached.
+func cacheLifetime(header http.Header, now time.Time) time.Duration {
+	if cc := header.Get("Cache-Control"); cc != "" {
+		for _, directive := range strings.Split(cc, ",") {
+			directive = strings.ToLower(strings.TrimSpace(directive))
+			if directive == "no-cache" || directive == "no-store" {
+				return 0
+			}
+			if age, ok := strings.CutPrefix(directive, "max-age="); ok {
+				if seconds, err := strconv.Atoi(age); err == nil && seconds > 0 {
+					return time.Duration(seconds) * time.Second
+				}
+				return 0
+			}
+		}
+	}
+	if expires := header.Get("Expires"); expires != "" {
+		t, err := http.ParseTime(expires)
+		if err != nil {
+			return 0
+		}
+		if date, err := http.ParseTime(header.Get("Date")); err == nil {
+			now = date
+		}
+		if t.After(now) {
+			return t.Sub(now)
+		}
+	}
+	return 0
+}
+
+// JWKSStatus reports the state of the key set kept by a JWKSManager
+type JWKSStatus struct {
+	URL         string    `json:"url"`
+	Current     bool      `json:"current" description:"Whether keys are loaded and either the last refresh succeeded or the cached keys have not yet expired"`
+	LastAttempt time.Time `json:"last-attempt,omitempty"`
+	LastSuccess time.Time `json:"last-success,omitempty"`
+	Expires     time.Time `json:"expires,omitempty" description:"When the cached keys expire, from the keyserver's cache headers or the refresh interval"`
+	NextRefresh time.Time `json:"next-refresh,omitempty"`
+	Error       string    `json:"error,omitempty" description:"Error from the last refresh, if it failed"`
+}
+
+// JWKSManager keeps the key set fetched from a JWKS URL current. The keys are
+// refreshed when the keyserver's cache headers say they expire, or every
+// refresh interval if it doesn't send any, and failed fetches are retried
+// with exponential backoff while the last good key set stays in use.
+type JWKSManager struct {
+	url             string
+	refreshInterval time.Duration
+	client          *http.Client
+
+	mu          sync.RWMutex
+	keyset      *jwtauth.JWTAuth
+	lastAttempt time.Time
+	lastSuccess time.Time
+	expires     time.Time
+	nextRefresh time.Time
+	lastErr     error
+	failures    int
+}
+
+// NewJWKSManager creates a JWKSManager for url. No keys are fetched until
+// Init or Run is called.
+func NewJWKSManager(url string, refreshInterval time.Duration) *JWKSManager {
+	if refreshInterval < jwksMinRefresh {
+		refreshInterval = jwksMinRefresh
+	}
+	return &JWKSManager{
+		url:             url,
+		refreshInterval: refreshInterval,
+		client:          newHTTPClient(),
+	}
+}
+
+// KeySet returns the current key set, or nil if none has been fetched yet
+func (m *JWKSManager) KeySet() *jwtauth.JWTAuth {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.keyset
+}
+
+// Status returns the state of the key set
+func (m *JWKSManager) Status() JWKSStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	status := JWKSStatus{
+		URL:         m.url,
+		Current:     m.keyset != nil && (m.lastErr == nil || time.Now().Before(m.expires)),
+		LastAttempt: m.lastAttempt,
+		LastSuccess: m.lastSuccess,
+		Expires:     m.expires,
+		NextRefresh: m.nextRefresh,
+	}
+	if m.lastErr != nil {
+		status.Error = m.lastErr.Error()
+	}
+	return status
+}
+
+// Refresh fetches the key set once and schedules the next refresh
+func (m *JWKSManager) Refresh(ctx context.Context) error {
+	keyset, lifetime, err := fetchPublicKeyFromURL(ctx, m.client, m.url)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	m.lastAttempt = now
+	m.lastErr = err
+	if err != nil {
+		m.failures++
+		m.nextRefresh = now.Add(backoff(m.failures))
+		return err
 	}
 
-	return keyset, nil
+	if lifetime == 0 {
+		lifetime = m.refreshInterval
+	}
+	m.keyset = keyset
+	m.failures = 0
+	m.lastSuccess = now
+	m.expires = now.Add(lifetime)
+	m.nextRefresh = now.Add(max(lifetime, jwksMinRefresh))
+	return nil
+}
+
+// Init fetches the key set, retrying with backoff until it succeeds or
+// timeout has passed
+func (m *JWKSManager) Init(ctx context.Context, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for {
+		err := m.Refresh(ctx)
+		if err == nil {
+			return nil
+		}
+		delay := time.Until(m.Status().NextRefresh)
+		log.Warn().Err(err).Msgf("Failed to fetch JWKS from %s, retrying in %s", m.url, delay.Round(time.Millisecond))
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("failed to fetch JWKS from %s within %s: %w", m.url, timeout, err)
+		case <-time.After(delay):
+		}
+	}
+}
+
+// Run refreshes the key set in the background until ctx is done
+func (m *JWKSManager) Run(ctx context.Context) {
+	for {
+		delay := time.Until(m.Status().NextRefresh)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		err := m.Refresh(ctx)
+		switch {
+		case err == nil:
+			log.Debug().Msgf("Refreshed JWKS from %s", m.url)
+		case !errors.Is(err, context.Canceled):
+			log.Error().Err(err).Msgf("Failed to refresh JWKS from %s; keeping the previous keys", m.url)
+		}
+	}
+}
+
+// backoff returns the delay before retrying after the given number of
+// consecutive failures
+func backoff(failures int) time.Duration {
+	delay := jwksInitialBackoff
+	for i := 1; i < failures && delay < jwksMaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, jwksMaxBackoff)
+}
+
+// keySetAuthenticator verifies request tokens against the key set returned by
+// keys at the time of each request and requires the given claims. Requests are
+// rejected with 503 Service Unavailable while no key set is available.
+func keySetAuthenticator(keys func() *jwtauth.JWTAuth, requiredClaims []string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			keyset := keys()
+			if keyset == nil {
+				http.Error(w, "signing keys are not available", http.StatusServiceUnavailable)
+				return
+			}
+			verify := jwtauth.Verifier(keyset)
+			authenticate := openchami_authenticator.AuthenticatorWithRequiredClaims(keyset, requiredClaims)
+			verify(authenticate(next)).ServeHTTP(w, r)
+		})
+	}
+}
+
+// JWKSStatusHandler godoc
+//
+//	@Summary		Get the state of the JWKS used to authenticate admin requests
+//	@Description	Report whether the key set fetched from the JWKS URL is
+//	@Description	current, along with when it was last refreshed and when it
+//	@Description	expires. Returns 503 if no keys are loaded or the last
+//	@Description	refresh failed after the cached keys expired.
+//	@Tags			auth
+//	@Produce		json
+//	@Success		200	{object}	JWKSStatus
+//	@Failure		503	{object}	JWKSStatus
+//	@Router			/jwks-status [get]
+func JWKSStatusHandler(m *JWKSManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := m.Status()
+		jsonData, err := json.Marshal(status)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if !status.Current {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if _, err := w.Write(jsonData); err != nil {
+			log.Error().Err(err).Msg("failed to write response")
+		}
+	}
 }
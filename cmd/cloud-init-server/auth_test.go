@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/OpenCHAMI/cloud-init/internal/memstore"
+	"github.com/OpenCHAMI/cloud-init/internal/smdclient"
+	"github.com/OpenCHAMI/cloud-init/pkg/cistore"
+	"github.com/OpenCHAMI/jwtauth/v5"
+	"github.com/go-chi/chi/v5"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminRouterScopes(t *testing.T) {
+	oldReadScope, oldWriteScope, oldImpersonationScope, oldImpersonationEnabled := adminReadScope, adminWriteScope, impersonationScope, impersonationEnabled
+	adminReadScope, adminWriteScope, impersonationScope = "cloud-init:read", "cloud-init:write", "cloud-init:impersonate"
+	impersonationEnabled = true
+	defer func() {
+		adminReadScope, adminWriteScope, impersonationScope, impersonationEnabled = oldReadScope, oldWriteScope, oldImpersonationScope, oldImpersonationEnabled
+	}()
+
+	keyset := jwtauth.New("HS256", []byte("secret"), nil)
+	store := memstore.NewMemStore()
+	handler := &CiHandler{sm: smdclient.NewFakeSMDClient("test", 10), store: store}
+	router := chi.NewRouter()
+	initCiAdminRouter(router, handler, nil, func() *jwtauth.JWTAuth { return keyset })
+
+	token := func(scope string) string {
+		_, tokenString, err := keyset.Encode(map[string]interface{}{
+			"sub": "alice", "iss": "test", "aud": "cloud-init", "scope": scope,
+		})
+		require.NoError(t, err)
+		return tokenString
+	}
+	do := func(method, path, body, tokenString string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if tokenString != "" {
+			req.Header.Set("Authorization", "Bearer "+tokenString)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	group := `{"name": "compute"}`
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/admin/groups", "", ""))
+	assert.Equal(t, http.StatusForbidden, do(http.MethodGet, "/admin/groups", "", token("cloud-init:write")))
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/admin/groups", "", token("cloud-init:read")))
+	assert.Equal(t, http.StatusForbidden, do(http.MethodPost, "/admin/groups", group, token("cloud-init:read")))
+	assert.Equal(t, http.StatusCreated, do(http.MethodPost, "/admin/groups", group, token("cloud-init:write")))
+	assert.Equal(t, http.StatusForbidden, do(http.MethodGet, "/admin/impersonation/x3000c0b0n1/user-data", "", token("cloud-init:read cloud-init:write")))
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/admin/impersonation/x3000c0b0n1/user-data", "", token("cloud-init:impersonate")))
+
+	// The audit trail records the token's subject
+	entries, err := store.GetAuditEntries(cistore.AuditFilter{Entity: cistore.EntityGroup})
+	require.NoError(t, err)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "alice", entries[0].Subject)
+	}
+}
+
+func TestCacheLifetime(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		name     string
+		header   http.Header
+		expected time.Duration
+	}{
+		{"No headers", http.Header{}, 0},
+		{"max-age", http.Header{"Cache-Control": {"public, max-age=600"}}, 10 * time.Minute},
+		{"no-store", http.Header{"Cache-Control": {"no-store"}}, 0},
+		{"Expires relative to Date", http.Header{
+			"Date":    {"Mon, 01 Jan 2024 12:00:00 GMT"},
+			"Expires": {"Mon, 01 Jan 2024 13:00:00 GMT"},
+		}, time.Hour},
+		{"Expired", http.Header{"Expires": {"Sun, 31 Dec 2023 00:00:00 GMT"}}, 0},
+		{"max-age wins over Expires", http.Header{
+			"Cache-Control": {"max-age=60"},
+			"Expires":       {"Mon, 01 Jan 2024 13:00:00 GMT"},
+		}, time.Minute},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, cacheLifetime(tc.header, now))
+		})
+	}
+}
+
+// newSigningKey returns an RS256 signing key with the given key ID and a JWKS
+// holding its public key
+func newSigningKey(t *testing.T, kid string) (jwk.Key, []byte) {
+	raw, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	key, err := jwk.FromRaw(raw)
+	require.NoError(t, err)
+	require.NoError(t, key.Set(jwk.KeyIDKey, kid))
+	require.NoError(t, key.Set(jwk.AlgorithmKey, jwa.RS256))
+	public, err := jwk.PublicKeyOf(key)
+	require.NoError(t, err)
+	set := jwk.NewSet()
+	require.NoError(t, set.AddKey(public))
+	jwks, err := json.Marshal(set)
+	require.NoError(t, err)
+	return key, jwks
+}
+
+func TestJWKSManager(t *testing.T) {
+	oldKey, oldJWKS := newSigningKey(t, "old")
+	newKey, newJWKS := newSigningKey(t, "new")
+
+	// The keyserver fails once, then serves the old keys, then the rotated ones
+	var mu sync.Mutex
+	responses := [][]byte{nil, oldJWKS, newJWKS}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		jwks := responses[0]
+		if len(responses) > 1 {
+			responses = responses[1:]
+		}
+		if jwks == nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=3600")
+		_, _ = w.Write(jwks)
+	}))
+	defer server.Close()
+
+	m := NewJWKSManager(server.URL, time.Hour)
+	assert.Nil(t, m.KeySet())
+	assert.False(t, m.Status().Current)
+
+	// Startup retries past the failure
+	require.NoError(t, m.Init(context.Background(), 10*time.Second))
+	status := m.Status()
+	assert.True(t, status.Current)
+	assert.Empty(t, status.Error)
+	assert.WithinDuration(t, status.LastSuccess.Add(time.Hour), status.Expires, time.Second)
+
+	router := chi.NewRouter()
+	router.With(keySetAuthenticator(m.KeySet, []string{"sub"})).Get("/", func(w http.ResponseWriter, r *http.Request) {})
+	get := func(key jwk.Key) int {
+		_, tokenString, err := jwtauth.New("RS256", key, nil).Encode(map[string]interface{}{"sub": "alice"})
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+tokenString)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	assert.Equal(t, http.StatusOK, get(oldKey))
+	assert.Equal(t, http.StatusUnauthorized, get(newKey))
+
+	// After the issuer rotates its keys, a refresh picks up the new ones
+	require.NoError(t, m.Refresh(context.Background()))
+	assert.Equal(t, http.StatusUnauthorized, get(oldKey))
+	assert.Equal(t, http.StatusOK, get(newKey))
+
+	// A failed refresh keeps the previous keys, which are still current until they expire
+	server.Close()
+	assert.Error(t, m.Refresh(context.Background()))
+	status = m.Status()
+	assert.True(t, status.Current)
+	assert.NotEmpty(t, status.Error)
+	assert.Equal(t, http.StatusOK, get(newKey))
+
+	rr := httptest.NewRecorder()
+	JWKSStatusHandler(m)(rr, httptest.NewRequest(http.MethodGet, "/jwks-status", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestKeySetAuthenticatorWithoutKeys(t *testing.T) {
+	handler := keySetAuthenticator(func() *jwtauth.JWTAuth { return nil }, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/admin/groups", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+}
+
+func TestBackoff(t *testing.T) {
+	assert.Equal(t, jwksInitialBackoff, backoff(1))
+	assert.Equal(t, 4*jwksInitialBackoff, backoff(3))
+	assert.Equal(t, jwksMaxBackoff, backoff(100))
+}
@@ -2,8 +2,10 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"reflect"
+	"strconv"
 
 	"github.com/OpenCHAMI/cloud-init/pkg/cistore"
 	"github.com/go-chi/chi/v5"
@@ -134,6 +136,10 @@ func (h CiHandler) GetGroupHandler(w http.ResponseWriter, r *http.Request) {
 //	@Description	`Location` header is set to the new group's groups endpoint,
 //	@Description	`/groups/{group}`. This operation is idempotent and replaces
 //	@Description	any existing content.
+//	@Description
+//	@Description	The previous meta-data and cloud-init config of an existing
+//	@Description	group are kept as a numbered revision, see
+//	@Description	`/admin/groups/{name}/versions`.
 //	@Tags			admin,groups
 //	@Accept			json
 //	@Success		201			{object}	nil
@@ -189,3 +195,130 @@ func (h CiHandler) RemoveGroupHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+// GetGroupVersionsHandler godoc
+//
+//	@Summary		List previous revisions of a group
+//	@Description	List the previous revisions of a group's meta-data and
+//	@Description	cloud-init config, oldest first. A revision is recorded each
+//	@Description	time the group is updated.
+//	@Tags			admin,groups
+//	@Produce		json
+//	@Success		200		{object}	[]cistore.GroupRevision
+//	@Failure		404		{object}	nil
+//	@Failure		500		{object}	nil
+//	@Param			name	path		string	true	"Group name"
+//	@Router			/admin/groups/{name}/versions [get]
+func (h CiHandler) GetGroupVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+
+	if _, err := h.store.GetGroupData(name); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	revisions, err := h.store.GetGroupVersions(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	bytes, err := json.Marshal(revisions)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, err := w.Write(bytes); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// GetGroupVersionHandler godoc
+//
+//	@Summary		Get a previous revision of a group
+//	@Description	Get the meta-data and cloud-init config of a group as it was
+//	@Description	at a previous revision.
+//	@Tags			admin,groups
+//	@Produce		json
+//	@Success		200		{object}	cistore.GroupRevision
+//	@Failure		400		{object}	nil
+//	@Failure		404		{object}	nil
+//	@Failure		500		{object}	nil
+//	@Param			name	path		string	true	"Group name"
+//	@Param			version	path		int		true	"Revision number"
+//	@Router			/admin/groups/{name}/versions/{version} [get]
+func (h CiHandler) GetGroupVersionHandler(w http.ResponseWriter, r *http.Request) {
+	revision, ok := h.groupRevision(w, r)
+	if !ok {
+		return
+	}
+
+	bytes, err := json.Marshal(revision)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, err := w.Write(bytes); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// RollbackGroupHandler godoc
+//
+//	@Summary		Roll a group back to a previous revision
+//	@Description	Restore the meta-data and cloud-init config of a group from a
+//	@Description	previous revision. The group's description and network-config
+//	@Description	are left unchanged.
+//	@Description
+//	@Description	The rollback is itself an update, so the content it replaces is
+//	@Description	kept as a new revision and the rollback can be undone.
+//	@Tags			admin,groups
+//	@Success		200		{object}	nil
+//	@Failure		400		{object}	nil
+//	@Failure		404		{object}	nil
+//	@Failure		500		{object}	nil
+//	@Header			200		{string}	Location	"/groups/{name}"
+//	@Param			name	path		string		true	"Group name"
+//	@Param			version	path		int			true	"Revision number"
+//	@Router			/admin/groups/{name}/versions/{version}/rollback [post]
+func (h CiHandler) RollbackGroupHandler(w http.ResponseWriter, r *http.Request) {
+	revision, ok := h.groupRevision(w, r)
+	if !ok {
+		return
+	}
+	name := chi.URLParam(r, "name")
+
+	data, err := h.store.GetGroupData(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	data.Data = revision.Data
+	data.File = revision.File
+
+	if err := h.store.UpdateGroupData(name, data, false); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Location", "/groups/"+name)
+	w.WriteHeader(http.StatusOK)
+}
+
+// groupRevision looks up the revision addressed by the request's name and
+// version URL parameters, writing an error response if it cannot be found.
+func (h CiHandler) groupRevision(w http.ResponseWriter, r *http.Request) (cistore.GroupRevision, bool) {
+	name := chi.URLParam(r, "name")
+	version, err := strconv.Atoi(chi.URLParam(r, "version"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid revision %q", chi.URLParam(r, "version")), http.StatusBadRequest)
+		return cistore.GroupRevision{}, false
+	}
+
+	revision, err := h.store.GetGroupVersion(name, version)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return cistore.GroupRevision{}, false
+	}
+	return revision, true
+}
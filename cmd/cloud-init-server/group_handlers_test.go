@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/OpenCHAMI/cloud-init/internal/memstore"
+	"github.com/OpenCHAMI/cloud-init/internal/smdclient"
+	"github.com/OpenCHAMI/cloud-init/pkg/cistore"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGroupVersionHandlers(t *testing.T) {
+	store := memstore.NewMemStore()
+	handler := &CiHandler{sm: smdclient.NewFakeSMDClient("test", 10), store: store}
+	router := chi.NewRouter()
+	initCiAdminRouter(router, handler, nil, nil)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := do(http.MethodPost, "/admin/groups", `{"name": "compute", "file": {"content": "#cloud-config\nhostname: good\n"}}`)
+	require.Equal(t, http.StatusCreated, rr.Code)
+	rr = do(http.MethodPut, "/admin/groups/compute", `{"name": "compute", "file": {"content": "#cloud-config\nhostname: bad\n"}}`)
+	require.Equal(t, http.StatusCreated, rr.Code)
+
+	// The original content was kept as revision 1
+	rr = do(http.MethodGet, "/admin/groups/compute/versions", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var revisions []cistore.GroupRevision
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &revisions))
+	require.Len(t, revisions, 1)
+	assert.Equal(t, "#cloud-config\nhostname: good\n", string(revisions[0].File.Content))
+
+	rr = do(http.MethodGet, "/admin/groups/compute/versions/1", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var revision cistore.GroupRevision
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &revision))
+	assert.Equal(t, 1, revision.Revision)
+
+	// Rolling back restores revision 1 and keeps the bad edit as revision 2
+	rr = do(http.MethodPost, "/admin/groups/compute/versions/1/rollback", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	group, err := store.GetGroupData("compute")
+	require.NoError(t, err)
+	assert.Equal(t, "#cloud-config\nhostname: good\n", string(group.File.Content))
+	bad, err := store.GetGroupVersion("compute", 2)
+	require.NoError(t, err)
+	assert.Equal(t, "#cloud-config\nhostname: bad\n", string(bad.File.Content))
+
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, "/admin/groups/compute/versions/latest", "").Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/admin/groups/compute/versions/5", "").Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPost, "/admin/groups/compute/versions/5/rollback", "").Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/admin/groups/unknown/versions", "").Code)
+}
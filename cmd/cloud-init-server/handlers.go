@@ -2,6 +2,8 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 
@@ -176,6 +178,12 @@ func InstanceInfoHandler(sm smdclient.SMDClientInterface, store cistore.Store) h
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
+		for group, pin := range info.GroupVersions {
+			if _, err := cistore.ParseRevisionPin(pin); err != nil {
+				http.Error(w, fmt.Sprintf("group %s: %v", group, err), http.StatusBadRequest)
+				return
+			}
+		}
 
 		err = store.SetInstanceInfo(id, info)
 		if err != nil {
@@ -221,10 +229,6 @@ func PhoneHomeHandler(wg *wgtunnel.InterfaceManager, sm smdclient.SMDClientInter
 		if err != nil {
 			log.Error().Msgf("Error getting ID from IP: %v", err)
 		}
-		peerName, err := sm.IPfromID(id)
-		if err != nil {
-			log.Error().Msgf("Error getting IP from ID: %v", err)
-		}
 		err = r.ParseForm()
 		if err != nil {
 			log.Error().Msgf("Error parsing form data: %v", err)
@@ -251,7 +255,21 @@ func PhoneHomeHandler(wg *wgtunnel.InterfaceManager, sm smdclient.SMDClientInter
 
 		if wg != nil {
 			go func() {
-				_ = wg.RemovePeer(peerName) // Explicitly ignoring the error here.  There's nothing to do with it within the goroutine.
+				// The node's peer is named after whichever of its
+				// addresses set up the tunnel, so it is found by node
+				peerName, err := wg.RemoveNodePeer(sm, id)
+				switch {
+				case errors.Is(err, wgtunnel.ErrPeerNotFound):
+					// The node may not have set up a tunnel, but if it has a
+					// WireGuard IP its tunnel is left behind
+					if wgip, _ := sm.WGIPfromID(id); wgip != "" {
+						log.Warn().Msgf("No WireGuard peer to remove for %s, which has WireGuard IP %s", id, wgip)
+					}
+				case err != nil:
+					log.Error().Err(err).Msgf("Failed to remove WireGuard peer %s of %s", peerName, id)
+				default:
+					log.Info().Msgf("Removed WireGuard peer %s of %s", peerName, id)
+				}
 			}()
 
 			w.WriteHeader(http.StatusOK)
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/OpenCHAMI/cloud-init/internal/memstore"
+	"github.com/OpenCHAMI/cloud-init/internal/smdclient"
+	"github.com/OpenCHAMI/cloud-init/pkg/wgtunnel"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// aliasedSMDClient is an SMD client that also knows nodes by extra addresses,
+// as if they had several interfaces. The IDs of nodes whose WireGuard IP is
+// unassigned are sent to unassigned.
+type aliasedSMDClient struct {
+	*smdclient.FakeSMDClient
+	aliases    map[string]string
+	unassigned chan string
+}
+
+func (c aliasedSMDClient) IDfromIP(ip string) (string, error) {
+	if id, ok := c.aliases[ip]; ok {
+		return id, nil
+	}
+	return c.FakeSMDClient.IDfromIP(ip)
+}
+
+func (c aliasedSMDClient) RemoveWGIP(id string, wgip string) error {
+	defer func() { c.unassigned <- id }()
+	return c.FakeSMDClient.RemoveWGIP(id, wgip)
+}
+
+func TestPhoneHomeHandler_MultiInterfaceNode(t *testing.T) {
+	sm := aliasedSMDClient{
+		FakeSMDClient: smdclient.NewFakeSMDClient("test", 10),
+		aliases:       map[string]string{"10.20.31.1": "x3000c0b0n1", "fd00::1": "x3000c0b0n1"},
+		unassigned:    make(chan string, 1),
+	}
+	wgIp, wgNet, _ := net.ParseCIDR("100.97.0.1/16")
+	wg, err := wgtunnel.NewInterfaceManager("wg0", wgIp, wgNet, memstore.NewMemStore(), wgtunnel.NewFakeBackend())
+	require.NoError(t, err)
+	require.NoError(t, wg.StartServer())
+
+	// The node sets up its tunnel from its second interface
+	req := httptest.NewRequest(http.MethodPost, "/wg-init", strings.NewReader(`{"public_key": "9NS6+NR0J38SZ9IlY9hBDLs6aBpNDhxHUHL8OTlNEDU="}`))
+	req.RemoteAddr = "10.20.31.1:40000"
+	rr := httptest.NewRecorder()
+	wgtunnel.AddClientHandler(wg, sm).ServeHTTP(rr, req)
+	require.Equal(t, http.StatusCreated, rr.Code)
+	peer, ok := wg.GetPeer("10.20.31.1")
+	require.True(t, ok)
+	assert.Equal(t, "x3000c0b0n1", peer.NodeID)
+
+	// and phones home over IPv6
+	req = httptest.NewRequest(http.MethodPost, "/phone-home/x3000c0b0n1", strings.NewReader("instance_id=x3000c0b0n1"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.RemoteAddr = "[fd00::1]:40000"
+	rr = httptest.NewRecorder()
+	PhoneHomeHandler(wg, sm).ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	// The peer is torn down in the background
+	select {
+	case id := <-sm.unassigned:
+		assert.Equal(t, "x3000c0b0n1", id)
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the WireGuard IP to be unassigned")
+	}
+	_, ok = wg.GetPeer("10.20.31.1")
+	assert.False(t, ok)
+	wgip, _ := sm.WGIPfromID("x3000c0b0n1")
+	assert.Empty(t, wgip)
+	assert.Equal(t, 1, wg.AllocatorStats().Allocated)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/OpenCHAMI/cloud-init/internal/smdclient"
+	"github.com/OpenCHAMI/cloud-init/pkg/cistore"
+	"github.com/OpenCHAMI/cloud-init/pkg/wgtunnel"
+	"github.com/rs/zerolog/log"
+)
+
+// HealthCheck is the result of a single readiness check
+type HealthCheck struct {
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+// ReadinessStatus is the result of all readiness checks
+type ReadinessStatus struct {
+	Ready  bool                   `json:"ready"`
+	Checks map[string]HealthCheck `json:"checks"`
+}
+
+// writeHealthResponse writes data as JSON with the given status code
+func writeHealthResponse(w http.ResponseWriter, status int, data interface{}) {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if _, err := w.Write(jsonData); err != nil {
+		log.Error().Err(err).Msg("failed to write response")
+	}
+}
+
+// HealthzHandler godoc
+//
+//	@Summary		Check that the server is alive
+//	@Description	Always returns 200 while the server is able to handle
+//	@Description	requests. Use /readyz to decide whether to send it traffic.
+//	@Tags			health
+//	@Produce		json
+//	@Success		200	{object}	map[string]string
+//	@Router			/healthz [get]
+func HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealthResponse(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// ReadyzHandler godoc
+//
+//	@Summary		Check that the server is ready to serve nodes
+//	@Description	Returns 503 until the SMD node cache has been populated,
+//	@Description	while it is older than the configured maximum age, while the
+//	@Description	storage backend is unreachable, or while the WireGuard
+//	@Description	interface (if configured) is down. The result of each check
+//	@Description	is included in the response.
+//	@Tags			health
+//	@Produce		json
+//	@Success		200	{object}	ReadinessStatus
+//	@Failure		503	{object}	ReadinessStatus
+//	@Router			/readyz [get]
+func ReadyzHandler(sm smdclient.SMDClientInterface, store cistore.Store, wg *wgtunnel.InterfaceManager, maxCacheAge time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := ReadinessStatus{
+			Ready:  true,
+			Checks: make(map[string]HealthCheck),
+		}
+		check := func(name string, err error) {
+			if err != nil {
+				status.Ready = false
+				status.Checks[name] = HealthCheck{Message: err.Error()}
+				return
+			}
+			status.Checks[name] = HealthCheck{OK: true}
+		}
+
+		check("smd-cache", checkSMDCache(sm.CacheStatus(), maxCacheAge))
+		check("store", store.Ping())
+		if wg != nil {
+			check("wireguard", wg.InterfaceUp())
+		}
+
+		code := http.StatusOK
+		if !status.Ready {
+			code = http.StatusServiceUnavailable
+		}
+		writeHealthResponse(w, code, status)
+	}
+}
+
+// checkSMDCache returns an error if the SMD node cache has never been
+// populated or is older than maxAge. A maxAge of zero disables the age check.
+func checkSMDCache(cache smdclient.CacheStatus, maxAge time.Duration) error {
+	if !cache.Populated {
+		return fmt.Errorf("SMD node cache has not been populated yet")
+	}
+	if age := time.Since(cache.LastUpdate); maxAge > 0 && age > maxAge {
+		return fmt.Errorf("SMD node cache was last updated %s ago, more than %s", age.Round(time.Second), maxAge)
+	}
+	return nil
+}
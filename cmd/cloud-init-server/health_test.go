@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/OpenCHAMI/cloud-init/internal/memstore"
+	"github.com/OpenCHAMI/cloud-init/internal/smdclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// coldSMDClient is an SMD client whose cache reports the given status
+type coldSMDClient struct {
+	*smdclient.FakeSMDClient
+	status smdclient.CacheStatus
+}
+
+func (c coldSMDClient) CacheStatus() smdclient.CacheStatus {
+	return c.status
+}
+
+func TestHealthzHandler(t *testing.T) {
+	rr := httptest.NewRecorder()
+	HealthzHandler(rr, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestReadyzHandler(t *testing.T) {
+	store := memstore.NewMemStore()
+	fake := smdclient.NewFakeSMDClient("test", 10)
+
+	testCases := []struct {
+		name           string
+		cache          smdclient.CacheStatus
+		expectedStatus int
+		expectedCheck  HealthCheck
+	}{
+		{
+			name:           "Fresh cache",
+			cache:          smdclient.CacheStatus{Populated: true, LastUpdate: time.Now()},
+			expectedStatus: http.StatusOK,
+			expectedCheck:  HealthCheck{OK: true},
+		},
+		{
+			name:           "Cache never populated",
+			cache:          smdclient.CacheStatus{},
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedCheck:  HealthCheck{Message: "SMD node cache has not been populated yet"},
+		},
+		{
+			name:           "Stale cache",
+			cache:          smdclient.CacheStatus{Populated: true, LastUpdate: time.Now().Add(-time.Hour)},
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedCheck:  HealthCheck{Message: "SMD node cache was last updated 1h0m0s ago, more than 5m0s"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sm := coldSMDClient{FakeSMDClient: fake, status: tc.cache}
+			rr := httptest.NewRecorder()
+			ReadyzHandler(sm, store, nil, 5*time.Minute)(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+
+			var status ReadinessStatus
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &status))
+			assert.Equal(t, tc.expectedStatus == http.StatusOK, status.Ready)
+			assert.Equal(t, tc.expectedCheck, status.Checks["smd-cache"])
+			assert.Equal(t, HealthCheck{OK: true}, status.Checks["store"])
+			assert.NotContains(t, status.Checks, "wireguard")
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/netip"
+
+	base "github.com/Cray-HPE/hms-base"
+	"github.com/OpenCHAMI/cloud-init/internal/smdclient"
+	"github.com/OpenCHAMI/cloud-init/pkg/cistore"
+	"github.com/rs/zerolog/log"
+)
+
+// nodeComponent combines a node's SMD component with its cached interfaces.
+// The component's IP and MAC are those of its boot interface, preferring the
+// boot interface's address in the cluster's boot subnet. Missing interface
+// information is left empty.
+func nodeComponent(id string, smdComponent base.Component, smd smdclient.SMDClientInterface, clusterDefaults cistore.ClusterDefaults, instanceInfo cistore.OpenCHAMIInstanceInfo) cistore.OpenCHAMIComponent {
+	component := cistore.OpenCHAMIComponent{Component: smdComponent}
+	interfaces, err := smd.InterfacesFromID(id)
+	if err != nil {
+		log.Debug().Err(err).Msgf("failed to get interfaces of %s", id)
+		return component
+	}
+
+	boot := smdclient.BootInterface(interfaces, clusterDefaults, instanceInfo)
+	for i, iface := range interfaces {
+		component.Interfaces = append(component.Interfaces, cistore.ComponentInterface{
+			MAC:         iface.MAC,
+			IPs:         iface.Addresses(),
+			Description: iface.Desc,
+			Boot:        i == boot,
+		})
+	}
+	if boot >= 0 {
+		component.MAC = interfaces[boot].MAC
+		component.IP = interfaces[boot].IP
+		if addr := interfaces[boot].AddressIn(smdclient.BootSubnet(clusterDefaults)); addr != "" {
+			component.IP = addr
+		}
+	}
+	return component
+}
+
+// localAddresses returns the node's IPv4 and IPv6 addresses, either of which
+// may be empty. The component's IP is preferred for its address family, and
+// the other family's address is the first one on the boot interface.
+func localAddresses(component cistore.OpenCHAMIComponent) (ipv4, ipv6 string) {
+	candidates := []string{component.IP}
+	for _, iface := range component.Interfaces {
+		if iface.Boot {
+			candidates = append(candidates, iface.IPs...)
+		}
+	}
+	for _, addr := range candidates {
+		ip, err := netip.ParseAddr(addr)
+		if err != nil {
+			continue
+		}
+		if ip.Unmap().Is4() {
+			if ipv4 == "" {
+				ipv4 = ip.Unmap().String()
+			}
+		} else if ipv6 == "" {
+			ipv6 = addr
+		}
+	}
+	return ipv4, ipv6
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	base "github.com/Cray-HPE/hms-base"
+	"github.com/OpenCHAMI/cloud-init/internal/memstore"
+	"github.com/OpenCHAMI/cloud-init/internal/smdclient"
+	"github.com/OpenCHAMI/cloud-init/pkg/cistore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// multiNICSMDClient is an SMD client whose nodes all have the given interfaces
+type multiNICSMDClient struct {
+	*smdclient.FakeSMDClient
+	interfaces []smdclient.NodeInterface
+}
+
+func (c multiNICSMDClient) InterfacesFromID(id string) ([]smdclient.NodeInterface, error) {
+	return c.interfaces, nil
+}
+
+var testInterfaces = []smdclient.NodeInterface{
+	{MAC: "00:DE:AD:BE:EF:01", IP: "172.16.0.1", IPs: []string{"172.16.0.1"}, Desc: "High-speed"},
+	{MAC: "00:DE:AD:BE:EF:02", IP: "192.168.0.1", IPs: []string{"192.168.0.1", "10.20.30.1"}, Desc: "Onboard NIC"},
+	{MAC: "00:DE:AD:BE:EF:03", IP: "10.20.30.3", IPs: []string{"10.20.30.3"}, Desc: "Management"},
+}
+
+func TestNodeComponent(t *testing.T) {
+	clusterDefaults := cistore.ClusterDefaults{ClusterName: "test", BootSubnet: "10.20.16.0/20"}
+	sm := multiNICSMDClient{smdclient.NewFakeSMDClient("test", 10), testInterfaces}
+
+	// The boot interface's address in the boot subnet is used, rather than its first
+	component := nodeComponent("x3000c0b0n1", base.Component{ID: "x3000c0b0n1"}, sm, clusterDefaults, cistore.OpenCHAMIInstanceInfo{})
+	assert.Equal(t, "00:DE:AD:BE:EF:02", component.MAC)
+	assert.Equal(t, "10.20.30.1", component.IP)
+	require.Len(t, component.Interfaces, 3)
+	assert.Equal(t, cistore.ComponentInterface{
+		MAC:         "00:DE:AD:BE:EF:02",
+		IPs:         []string{"192.168.0.1", "10.20.30.1"},
+		Description: "Onboard NIC",
+		Boot:        true,
+	}, component.Interfaces[1])
+	assert.False(t, component.Interfaces[0].Boot)
+	assert.False(t, component.Interfaces[2].Boot)
+
+	// The full interface list is included in the meta-data
+	metadata := generateMetaData(context.Background(), component, nil, cistore.OpenCHAMIInstanceInfo{}, clusterDefaults, memstore.NewMemStore())
+	assert.Equal(t, "10.20.30.1", metadata.InstanceData.V1.LocalIPv4)
+	assert.Equal(t, component.Interfaces, metadata.InstanceData.V1.VendorData.Interfaces)
+
+	// A node can override the boot interface
+	component = nodeComponent("x3000c0b0n1", base.Component{ID: "x3000c0b0n1"}, sm, clusterDefaults, cistore.OpenCHAMIInstanceInfo{BootMAC: "00:de:ad:be:ef:03"})
+	assert.Equal(t, "00:DE:AD:BE:EF:03", component.MAC)
+	assert.Equal(t, "10.20.30.3", component.IP)
+	assert.True(t, component.Interfaces[2].Boot)
+}
+
+func TestLocalAddresses(t *testing.T) {
+	tests := []struct {
+		name         string
+		component    cistore.OpenCHAMIComponent
+		expectedIPv4 string
+		expectedIPv6 string
+	}{
+		{"no addresses", cistore.OpenCHAMIComponent{}, "", ""},
+		{"IPv4 only", cistore.OpenCHAMIComponent{IP: "10.20.30.1"}, "10.20.30.1", ""},
+		{"IPv6 only", cistore.OpenCHAMIComponent{IP: "fd00::1"}, "", "fd00::1"},
+		{
+			"dual-stack boot interface",
+			cistore.OpenCHAMIComponent{IP: "10.20.30.1", Interfaces: []cistore.ComponentInterface{
+				{IPs: []string{"fd01::1"}},
+				{IPs: []string{"192.168.0.1", "fd00::1", "10.20.30.1"}, Boot: true},
+			}},
+			"10.20.30.1", "fd00::1",
+		},
+		{
+			"IPv6 boot subnet",
+			cistore.OpenCHAMIComponent{IP: "fd00::2", Interfaces: []cistore.ComponentInterface{
+				{IPs: []string{"fd00::1", "fd00::2", "10.20.30.1"}, Boot: true},
+			}},
+			"10.20.30.1", "fd00::2",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ipv4, ipv6 := localAddresses(tc.component)
+			assert.Equal(t, tc.expectedIPv4, ipv4)
+			assert.Equal(t, tc.expectedIPv6, ipv6)
+		})
+	}
+
+	// Both are included in the meta-data, and local-ipv4 is left out of the
+	// meta-data of IPv6-only nodes
+	store := memstore.NewMemStore()
+	metadata := generateMetaData(context.Background(), tests[3].component, nil, cistore.OpenCHAMIInstanceInfo{}, cistore.ClusterDefaults{}, store)
+	assert.Equal(t, "10.20.30.1", metadata.InstanceData.V1.LocalIPv4)
+	assert.Equal(t, "fd00::1", metadata.InstanceData.V1.LocalIPv6)
+	metadata = generateMetaData(context.Background(), tests[2].component, nil, cistore.OpenCHAMIInstanceInfo{}, cistore.ClusterDefaults{}, store)
+	assert.Nil(t, metadata.InstanceData.V1.LocalIPv4)
+	assert.Equal(t, "fd00::1", metadata.InstanceData.V1.LocalIPv6)
+}
@@ -7,6 +7,7 @@ package main
 //	@License.url	https://github.com/OpenCHAMI/.github/blob/main/LICENSE
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"net/http"
@@ -15,15 +16,16 @@ import (
 	"time"
 
 	"github.com/OpenCHAMI/cloud-init/internal/memstore"
+	"github.com/OpenCHAMI/cloud-init/internal/metrics"
 	openchami_middleware "github.com/OpenCHAMI/cloud-init/internal/middleware"
 	"github.com/OpenCHAMI/cloud-init/internal/quackstore"
 	"github.com/OpenCHAMI/cloud-init/internal/smdclient"
+	"github.com/OpenCHAMI/cloud-init/internal/tracing"
 	"github.com/OpenCHAMI/cloud-init/pkg/cistore"
 	"github.com/OpenCHAMI/cloud-init/pkg/wgtunnel"
 	"github.com/OpenCHAMI/jwtauth/v5"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
-	openchami_authenticator "github.com/openchami/chi-middleware/auth"
 	openchami_logger "github.com/openchami/chi-middleware/log"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
@@ -38,7 +40,19 @@ var (
 	ciEndpoint           string
 	tokenEndpoint        string
 	smdEndpoint          string
+	smdCacheMaxAge       time.Duration
+	smdRefreshInterval   time.Duration
+	smdLookupTimeout     time.Duration
+	smdNegativeCacheTTL  time.Duration
 	jwksUrl              string
+	jwksRefreshInterval  time.Duration
+	jwksStartupTimeout   time.Duration
+	otlpEndpoint         string
+	adminReadScope       string
+	adminWriteScope      string
+	impersonationScope   string
+	fakeSMScope          string
+	smdEventsScope       string
 	insecure             bool
 	accessToken          string
 	certPath             string
@@ -51,6 +65,14 @@ var (
 	impersonationEnabled bool
 	wireguardServer      string
 	wireguardOnly        bool
+	wireguardPrefixes    string
+	wireguardPeerTTL     time.Duration
+	wireguardPeerIdle    time.Duration
+	trustedProxies       string
+	nodeTokenSecret      string
+	nodeTokenTTL         time.Duration
+	nodeTokens           *NodeTokens
+	renderTemplates      bool
 	debug                bool
 	logFormat            string
 	wireGuardMiddleware  func(http.Handler) http.Handler
@@ -85,7 +107,18 @@ func setupFlags(flags *pflag.FlagSet) {
 	flags.StringVar(&ciEndpoint, "listen", getEnv("LISTEN", "0.0.0.0:27777"), "Server IP and port for cloud-init-server to listen on")
 	flags.StringVar(&tokenEndpoint, "token-url", getEnv("TOKEN_URL", "http://opaal:3333/token"), "OIDC server endpoint to fetch new tokens from (for SMD access)")
 	flags.StringVar(&smdEndpoint, "smd-url", getEnv("SMD_URL", "http://smd:27779"), "Server host and port for running SMD (do not include /hsm/v2)")
+	flags.DurationVar(&smdRefreshInterval, "smd-refresh-interval", getEnvDuration("SMD_REFRESH_INTERVAL", time.Minute), "How often to refresh the SMD node cache (0 to only refresh on demand)")
+	flags.DurationVar(&smdLookupTimeout, "smd-lookup-timeout", getEnvDuration("SMD_LOOKUP_TIMEOUT", 2*time.Second), "Timeout for querying SMD directly for a requesting IP missing from the node cache (0 to disable these queries)")
+	flags.DurationVar(&smdNegativeCacheTTL, "smd-negative-cache-ttl", getEnvDuration("SMD_NEGATIVE_CACHE_TTL", 30*time.Second), "How long to remember that SMD doesn't know a requesting IP before querying it again")
+	flags.DurationVar(&smdCacheMaxAge, "smd-cache-max-age", getEnvDuration("SMD_CACHE_MAX_AGE", 5*time.Minute), "Report not ready while the SMD node cache is older than this (0 to disable)")
 	flags.StringVar(&jwksUrl, "jwks-url", getEnv("JWKS_URL", ""), "JWT keyserver URL, required to enable secure route")
+	flags.DurationVar(&jwksRefreshInterval, "jwks-refresh-interval", getEnvDuration("JWKS_REFRESH_INTERVAL", 15*time.Minute), "How often to refresh the JWKS when the keyserver sends no cache headers")
+	flags.DurationVar(&jwksStartupTimeout, "jwks-startup-timeout", getEnvDuration("JWKS_STARTUP_TIMEOUT", 30*time.Second), "How long to retry fetching the JWKS at startup before serving without keys")
+	flags.StringVar(&adminReadScope, "admin-read-scope", getEnv("ADMIN_READ_SCOPE", "cloud-init:read"), "JWT scope required for read-only admin routes when --jwks-url is set")
+	flags.StringVar(&adminWriteScope, "admin-write-scope", getEnv("ADMIN_WRITE_SCOPE", "cloud-init:write"), "JWT scope required for mutating admin routes when --jwks-url is set")
+	flags.StringVar(&impersonationScope, "impersonation-scope", getEnv("IMPERSONATION_SCOPE", "cloud-init:impersonate"), "JWT scope required for impersonation routes when --jwks-url is set")
+	flags.StringVar(&fakeSMScope, "fake-sm-scope", getEnv("FAKE_SM_SCOPE", "cloud-init:fake-sm"), "JWT scope required for fake SMD routes when --jwks-url is set")
+	flags.StringVar(&smdEventsScope, "smd-events-scope", getEnv("SMD_EVENTS_SCOPE", "cloud-init:smd-events"), "JWT scope required to post SMD inventory events when --jwks-url is set")
 	flags.StringVar(&accessToken, "access-token", getEnv("ACCESS_TOKEN", ""), "Encoded JWT access token")
 	flags.StringVar(&clusterName, "cluster-name", getEnv("CLUSTER_NAME", ""), "Name of the cluster")
 	flags.StringVar(&region, "region", getEnv("REGION", ""), "Region of the cluster")
@@ -98,7 +131,15 @@ func setupFlags(flags *pflag.FlagSet) {
 	flags.BoolVar(&fakeSMDEnabled, "smd-simulator", parseBool(getEnv("CLOUD_INIT_SMD_SIMULATOR", "false")), "Enable fake SMD")
 	flags.StringVar(&wireguardServer, "wireguard-server", getEnv("WIREGUARD_SERVER", ""), "WireGuard server IP address and network (e.g. 100.97.0.1/16)")
 	flags.BoolVar(&wireguardOnly, "wireguard-only", parseBool(getEnv("WIREGUARD_ONLY", "false")), "Only allow access to the cloud-init functions from the WireGuard subnet")
+	flags.StringVar(&wireguardPrefixes, "wireguard-prefixes", getEnv("WIREGUARD_PREFIXES", ""), "Comma-separated extra WireGuard subnets that --wireguard-only allows access from, e.g. the IPv6 subnet of a dual-stack tunnel (e.g. fd42::/64)")
+	flags.DurationVar(&wireguardPeerTTL, "wireguard-peer-ttl", getEnvDuration("WIREGUARD_PEER_TTL", 0), "Remove WireGuard peers this long after their tunnel was set up (0 to keep them until they are idle)")
+	flags.DurationVar(&wireguardPeerIdle, "wireguard-peer-idle-timeout", getEnvDuration("WIREGUARD_PEER_IDLE_TIMEOUT", time.Hour), "Remove WireGuard peers that haven't completed a handshake for this long (0 to keep idle peers)")
+	flags.StringVar(&trustedProxies, "trusted-proxies", getEnv("TRUSTED_PROXIES", ""), "Comma-separated CIDRs or IPs of proxies whose X-Forwarded-For and Forwarded headers are trusted (e.g. 10.0.0.1,fd00::/64)")
+	flags.StringVar(&nodeTokenSecret, "node-token-secret", getEnv("NODE_TOKEN_SECRET", ""), "Secret of at least 32 bytes to sign node tokens with. If set, nodes must present a token minted for them to get their data")
+	flags.DurationVar(&nodeTokenTTL, "node-token-ttl", getEnvDuration("NODE_TOKEN_TTL", 0), "How long minted node tokens are valid for (0 for tokens that never expire)")
+	flags.BoolVar(&renderTemplates, "render-templates", parseBool(getEnv("RENDER_TEMPLATES", "false")), "Render jinja group cloud-configs on the server instead of on the node")
 	flags.BoolVar(&debug, "debug", parseBool(getEnv("DEBUG", "false")), "Enable debug logging")
+	flags.StringVar(&otlpEndpoint, "otlp-endpoint", getEnv("OTLP_ENDPOINT", ""), "OTLP/HTTP collector URL to export traces to, e.g. http://collector:4318 (tracing is disabled if unset)")
 	flags.StringVar(&logFormat, "log-format", getEnv("LOG_FORMAT", "auto"), "Log format: json, console, or auto (auto detects TTY)")
 	flags.StringVar(&storageBackend, "storage-backend", getEnv("STORAGE_BACKEND", "mem"), "Storage backend to use (mem or quack)")
 	flags.StringVar(&dbPath, "db-path", getEnv("DB_PATH", "cloud-init.db"), "Path to the database file for quackstore backend")
@@ -113,7 +154,18 @@ func bindViperToFlags() {
 	_ = viper.BindEnv("listen")
 	_ = viper.BindEnv("token_url")
 	_ = viper.BindEnv("smd_url")
+	_ = viper.BindEnv("smd_refresh_interval")
+	_ = viper.BindEnv("smd_lookup_timeout")
+	_ = viper.BindEnv("smd_negative_cache_ttl")
+	_ = viper.BindEnv("smd_cache_max_age")
 	_ = viper.BindEnv("jwks_url")
+	_ = viper.BindEnv("jwks_refresh_interval")
+	_ = viper.BindEnv("jwks_startup_timeout")
+	_ = viper.BindEnv("admin_read_scope")
+	_ = viper.BindEnv("admin_write_scope")
+	_ = viper.BindEnv("impersonation_scope")
+	_ = viper.BindEnv("fake_sm_scope")
+	_ = viper.BindEnv("smd_events_scope")
 	_ = viper.BindEnv("access_token")
 	_ = viper.BindEnv("cluster_name")
 	_ = viper.BindEnv("region")
@@ -125,7 +177,15 @@ func bindViperToFlags() {
 	_ = viper.BindEnv("impersonation")
 	_ = viper.BindEnv("wireguard_server")
 	_ = viper.BindEnv("wireguard_only")
+	_ = viper.BindEnv("wireguard_prefixes")
+	_ = viper.BindEnv("wireguard_peer_ttl")
+	_ = viper.BindEnv("wireguard_peer_idle_timeout")
+	_ = viper.BindEnv("trusted_proxies")
+	_ = viper.BindEnv("node_token_secret")
+	_ = viper.BindEnv("node_token_ttl")
+	_ = viper.BindEnv("render_templates")
 	_ = viper.BindEnv("debug")
+	_ = viper.BindEnv("otlp_endpoint")
 	_ = viper.BindEnv("log_format")
 	_ = viper.BindEnv("storage_backend")
 	_ = viper.BindEnv("db_path")
@@ -140,7 +200,18 @@ func startServer() error {
 			Str("listen", ciEndpoint).
 			Str("token-url", tokenEndpoint).
 			Str("smd-url", smdEndpoint).
+			Dur("smd-refresh-interval", smdRefreshInterval).
+			Dur("smd-lookup-timeout", smdLookupTimeout).
+			Dur("smd-negative-cache-ttl", smdNegativeCacheTTL).
+			Dur("smd-cache-max-age", smdCacheMaxAge).
 			Str("jwks-url", jwksUrl).
+			Dur("jwks-refresh-interval", jwksRefreshInterval).
+			Dur("jwks-startup-timeout", jwksStartupTimeout).
+			Str("admin-read-scope", adminReadScope).
+			Str("admin-write-scope", adminWriteScope).
+			Str("impersonation-scope", impersonationScope).
+			Str("fake-sm-scope", fakeSMScope).
+			Str("smd-events-scope", smdEventsScope).
 			Str("access-token", accessToken).
 			Str("cluster-name", clusterName).
 			Str("region", region).
@@ -152,7 +223,15 @@ func startServer() error {
 			Bool("impersonation", impersonationEnabled).
 			Str("wireguard-server", wireguardServer).
 			Bool("wireguard-only", wireguardOnly).
+			Str("wireguard-prefixes", wireguardPrefixes).
+			Dur("wireguard-peer-ttl", wireguardPeerTTL).
+			Dur("wireguard-peer-idle-timeout", wireguardPeerIdle).
+			Str("trusted-proxies", trustedProxies).
+			Bool("node-token-secret", nodeTokenSecret != "").
+			Dur("node-token-ttl", nodeTokenTTL).
+			Bool("render-templates", renderTemplates).
 			Bool("debug", debug).
+			Str("otlp-endpoint", otlpEndpoint).
 			Str("storage-backend", storageBackend).
 			Str("db-path", dbPath).
 			Str("mem-path", memPath).
@@ -191,6 +270,20 @@ func startServer() error {
 		log.Logger = zerolog.New(os.Stderr).With().Timestamp().Logger()
 	}
 
+	// Setup tracing if a collector is configured
+	if otlpEndpoint != "" {
+		shutdownTracing, err := tracing.Init(context.Background(), otlpEndpoint, Version)
+		if err != nil {
+			return fmt.Errorf("failed to initialize tracing: %w", err)
+		}
+		defer func() {
+			if err := shutdownTracing(context.Background()); err != nil {
+				log.Error().Err(err).Msg("failed to flush traces")
+			}
+		}()
+		log.Info().Msgf("Exporting traces to %s", otlpEndpoint)
+	}
+
 	// Initialize storage backend
 	var err error
 	switch storageBackend {
@@ -211,20 +304,37 @@ func startServer() error {
 	default:
 		return fmt.Errorf("unsupported storage backend: %s", storageBackend)
 	}
+	store = metrics.InstrumentStore(store)
 
-	// Setup JWKS if provided
-	var keyset *jwtauth.JWTAuth
-	secureRouteEnable := false
+	// Setup JWKS if provided. The admin API is only protected when it is. If
+	// the keys can't be fetched at startup, admin requests are rejected until
+	// a background refresh succeeds.
+	var jwks *JWKSManager
+	var keys func() *jwtauth.JWTAuth
 	if jwksUrl != "" {
-		var err error
-		keyset, err = fetchPublicKeyFromURL(jwksUrl)
-		if err != nil {
-			fmt.Printf("JWKS initialization failed: %s\n", err)
-		} else {
-			secureRouteEnable = true
+		jwks = NewJWKSManager(jwksUrl, jwksRefreshInterval)
+		if err := jwks.Init(context.Background(), jwksStartupTimeout); err != nil {
+			log.Error().Err(err).Msg("JWKS initialization failed; admin requests will be rejected until the keys can be fetched")
 		}
+		go jwks.Run(context.Background())
+		keys = jwks.KeySet
 	} else {
-		fmt.Println("No JWKS URL provided; secure route will be disabled")
+		log.Warn().Msg("No JWKS URL provided; the admin API will not require authentication")
+	}
+
+	// Forwarding headers are only honoured from trusted proxies
+	clientIPResolver, err := openchami_middleware.NewClientIPResolver(trustedProxies)
+	if err != nil {
+		return fmt.Errorf("failed to parse trusted proxies: %w", err)
+	}
+
+	// Nodes must prove their identity with a token if a secret is set
+	if nodeTokenSecret != "" {
+		nodeTokens, err = NewNodeTokens(nodeTokenSecret, nodeTokenTTL)
+		if err != nil {
+			return fmt.Errorf("failed to set up node tokens: %w", err)
+		}
+		log.Info().Msg("Node tokens are required")
 	}
 
 	// Create SMD client
@@ -233,10 +343,13 @@ func startServer() error {
 		fmt.Printf("\n\n**********\n\n\tCLOUD_INIT_SMD_SIMULATOR is set to true in your environment.\n\n\tUsing the FakeSMDClient\n\n**********\n\n\n")
 		sm = smdclient.NewFakeSMDClient(clusterName, 500)
 	} else {
-		sm, err = smdclient.NewSMDClient(clusterName, smdEndpoint, tokenEndpoint, accessToken, certPath, insecure)
+		client, err := smdclient.NewSMDClient(clusterName, smdEndpoint, tokenEndpoint, accessToken, certPath, insecure, smdRefreshInterval)
 		if err != nil {
 			return fmt.Errorf("failed to create SMD client: %w", err)
 		}
+		client.EnableCacheMissLookup(smdLookupTimeout, smdNegativeCacheTTL)
+		client.SetBootInfo(store)
+		sm = client
 	}
 
 	// Create CI handler
@@ -253,18 +366,42 @@ func startServer() error {
 		if err != nil {
 			return fmt.Errorf("failed to parse WireGuard server IP and netmask from %s. Use format '100.97.0.1/16': %w", wireguardServer, err)
 		}
-		wgInterfaceManager = wgtunnel.NewInterfaceManager("wg0", wgIp, wgNet)
+		wgBackend, err := wgtunnel.NewNetlinkBackend()
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = wgBackend.Close() // ignoring error on deferred Close
+		}()
+		wgInterfaceManager, err = wgtunnel.NewInterfaceManager("wg0", wgIp, wgNet, store, wgBackend)
+		if err != nil {
+			return fmt.Errorf("failed to create the WireGuard server: %w", err)
+		}
 		err = wgInterfaceManager.StartServer()
 		if err != nil {
 			return fmt.Errorf("failed to start the WireGuard server: %w", err)
 		}
 		log.Info().Msg("WireGuard server started successfully")
+		metrics.RegisterWireGuardPeers(func() int { return len(wgInterfaceManager.GetPeers()) })
+		if wireguardPeerTTL > 0 || wireguardPeerIdle > 0 {
+			go wgInterfaceManager.RunReaper(context.Background(), sm, wireguardPeerTTL, wireguardPeerIdle)
+		}
 	}
 
 	// Setup WireGuard middleware if enabled
 	if wireguardOnly && wireguardServer != "" {
-		log.Info().Msg("WireGuard middleware enabled")
-		wireGuardMiddleware = openchami_middleware.WireGuardMiddlewareWithInterface("wg0", wireguardServer)
+		subnets, err := wireGuardSubnets(wireguardServer, wireguardPrefixes)
+		if err != nil {
+			return fmt.Errorf("failed to parse WireGuard prefixes: %w", err)
+		}
+		if trustedProxies != "" {
+			// Requests relayed by a proxy don't arrive on wg0, so only the
+			// client IP the proxy forwards is checked
+			wireGuardMiddleware = openchami_middleware.WireGuardMiddlewareWithProxy(subnets, true)
+		} else {
+			wireGuardMiddleware = openchami_middleware.WireGuardMiddlewareWithInterface("wg0", subnets)
+		}
+		log.Info().Msgf("WireGuard middleware enabled for %s", subnets)
 	}
 
 	// Create router
@@ -273,28 +410,23 @@ func startServer() error {
 	// Add middleware
 	router.Use(
 		middleware.RequestID,
-		middleware.RealIP,
+		// Node tokens must be out of the URI before it is logged
+		StripNodeTokenQuery,
+		clientIPResolver.Middleware,
 		middleware.Logger,
 		middleware.Recoverer,
 		middleware.StripSlashes,
 		middleware.Timeout(60*time.Second),
 		openchami_logger.OpenCHAMILogger(log.Logger),
+		metrics.Middleware,
+		tracing.Middleware,
 	)
 
 	// Setup routes
 	initCiClientRouter(router, handler, wgInterfaceManager)
-	initCiAdminRouter(router, handler)
-
-	// Add secure routes if JWKS is configured
-	if secureRouteEnable && keyset != nil {
-		secureRouter := chi.NewRouter()
-		secureRouter.Use(
-			jwtauth.Verifier(keyset),
-			openchami_authenticator.AuthenticatorWithRequiredClaims(keyset, []string{"sub", "iss", "aud"}),
-		)
-
-		// Add secure routes here if needed
-		router.Mount("/secure", secureRouter)
+	initCiAdminRouter(router, handler, wgInterfaceManager, keys)
+	if jwks != nil {
+		router.Get("/jwks-status", JWKSStatusHandler(jwks))
 	}
 
 	// Start server
@@ -310,6 +442,40 @@ func getEnv(key, fallback string) string {
 	return fallback
 }
 
+// getEnvDuration reads an optional environment variable holding a duration
+// such as "15m", falling back to the default if it is unset or invalid
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	val, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		log.Warn().Msgf("Ignoring invalid duration %q in %s, using %s", val, key, fallback)
+		return fallback
+	}
+	return d
+}
+
+// wireGuardSubnets returns the comma-separated subnets that the WireGuard
+// middleware allows access from: the network of the WireGuard server and any
+// extra comma-separated prefixes, such as the IPv6 subnet of a dual-stack
+// tunnel
+func wireGuardSubnets(server, prefixes string) (string, error) {
+	subnets := []string{server}
+	if prefixes != "" {
+		subnets = append(subnets, strings.Split(prefixes, ",")...)
+	}
+	for i, subnet := range subnets {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(subnet))
+		if err != nil {
+			return "", err
+		}
+		subnets[i] = network.String()
+	}
+	return strings.Join(subnets, ","), nil
+}
+
 // parseBool is a helper to convert string "true" or "false" to bool
 func parseBool(str string) bool {
 	return strings.EqualFold(str, "true") || str == "1"
@@ -319,51 +485,125 @@ func initCiClientRouter(router chi.Router, handler *CiHandler, wgInterfaceManage
 	// Add cloud-init endpoints to router
 	router.Get("/openapi.json", DocsHandler)
 	router.Get("/version", VersionHandler)
+	router.Get("/healthz", HealthzHandler)
+	router.Get("/metrics", metrics.Handler().ServeHTTP)
+	router.Get("/readyz", ReadyzHandler(handler.sm, handler.store, wgInterfaceManager, smdCacheMaxAge))
+	// Node data routes may be restricted to WireGuard clients and to nodes
+	// presenting their node token
+	var nodeMiddlewares []func(http.Handler) http.Handler
 	if wireGuardMiddleware != nil {
-		router.With(wireGuardMiddleware).Get("/user-data", UserDataHandler)
-		router.With(wireGuardMiddleware).Get("/meta-data", MetaDataHandler(handler.sm, handler.store))
-		router.With(wireGuardMiddleware).Get("/vendor-data", VendorDataHandler(handler.sm, handler.store, baseUrl))
-		router.With(wireGuardMiddleware).Get("/{group}.yaml", GroupUserDataHandler(handler.sm, handler.store))
-	} else {
-		router.Get("/user-data", UserDataHandler)
-		router.Get("/meta-data", MetaDataHandler(handler.sm, handler.store))
-		router.Get("/vendor-data", VendorDataHandler(handler.sm, handler.store, baseUrl))
-		router.Get("/{group}.yaml", GroupUserDataHandler(handler.sm, handler.store))
+		nodeMiddlewares = append(nodeMiddlewares, wireGuardMiddleware)
+	}
+	if nodeTokens != nil {
+		nodeMiddlewares = append(nodeMiddlewares, nodeTokens.Middleware(handler.sm))
 	}
+	nodeRouter := router.With(nodeMiddlewares...)
+	nodeRouter.Get("/user-data", UserDataHandler(handler.sm, handler.store))
+	nodeRouter.Get("/meta-data", MetaDataHandler(handler.sm, handler.store))
+	nodeRouter.Get("/vendor-data", VendorDataHandler(handler.sm, handler.store, baseUrl))
+	nodeRouter.Get("/network-config", NetworkConfigHandler(handler.sm, handler.store))
+	nodeRouter.Get("/{group}.yaml", GroupUserDataHandler(handler.sm, handler.store, renderTemplates))
 	router.Post("/phone-home/{id}", PhoneHomeHandler(wgInterfaceManager, handler.sm))
 	router.Post("/wg-init", wgtunnel.AddClientHandler(wgInterfaceManager, handler.sm))
 }
 
-func initCiAdminRouter(router chi.Router, handler *CiHandler) {
+// initCiAdminRouter adds the admin API to router. If keys is not nil, every
+// admin route requires a valid JWT, verified against the key set keys returns,
+// that grants the route's scope. The WireGuard routes are only added if
+// wgInterfaceManager is not nil.
+func initCiAdminRouter(router chi.Router, handler *CiHandler, wgInterfaceManager *wgtunnel.InterfaceManager, keys func() *jwtauth.JWTAuth) {
+	requireScope := func(scope string) func(http.Handler) http.Handler {
+		if keys == nil {
+			return func(next http.Handler) http.Handler { return next }
+		}
+		return openchami_middleware.RequireScope(scope)
+	}
+	// Record every mutating call in the audit trail
+	audit := func(target auditTarget) func(http.Handler) http.Handler {
+		return AuditMiddleware(handler.store, target)
+	}
+
 	// admin API subrouter
 	router.Route("/admin/", func(r chi.Router) {
+		if keys != nil {
+			r.Use(keySetAuthenticator(keys, []string{"sub", "iss", "aud"}))
+		}
+
+		// Read-only routes
+		r.Group(func(r chi.Router) {
+			r.Use(requireScope(adminReadScope))
+
+			r.Get("/audit", GetAuditHandler(handler.store))
+			r.Get("/cluster-defaults", GetClusterDataHandler(handler.store))
+			r.Get("/groups", handler.GetGroups)
+			r.Get("/groups/{id}", handler.GetGroupHandler)
+			r.Get("/groups/{name}/versions", handler.GetGroupVersionsHandler)
+			r.Get("/groups/{name}/versions/{version}", handler.GetGroupVersionHandler)
+			r.Get("/smd/cache", SMDCacheHandler(handler.sm))
+
+			if wgInterfaceManager != nil {
+				r.Get("/wireguard/peers", GetWireGuardPeersHandler(wgInterfaceManager, handler.sm))
+				r.Get("/wireguard/peers/{name}", GetWireGuardPeerHandler(wgInterfaceManager, handler.sm))
+				r.Get("/wireguard/server", GetWireGuardServerHandler(wgInterfaceManager))
+			}
+		})
 
-		// Cluster Defaults
-		r.Get("/cluster-defaults", GetClusterDataHandler(handler.store))
-		r.Post("/cluster-defaults", SetClusterDataHandler(handler.store))
-		// r.Put("/cluster-defaults", SetClusterDataHandler(handler.store)) // Should we support PUT and POST or just one of them?
+		// Mutating routes
+		r.Group(func(r chi.Router) {
+			r.Use(requireScope(adminWriteScope))
 
-		r.Put("/instance-info/{id}", InstanceInfoHandler(handler.sm, handler.store))
+			// Cluster Defaults
+			r.With(audit(clusterDefaultsTarget)).Post("/cluster-defaults", SetClusterDataHandler(handler.store))
+			// r.Put("/cluster-defaults", SetClusterDataHandler(handler.store)) // Should we support PUT and POST or just one of them?
 
-		// groups API endpoints
-		r.Get("/groups", handler.GetGroups)
-		r.Post("/groups", handler.AddGroupHandler)
-		r.Get("/groups/{id}", handler.GetGroupHandler)
-		r.Put("/groups/{name}", handler.UpdateGroupHandler)
-		r.Delete("/groups/{id}", handler.RemoveGroupHandler)
+			r.With(audit(instanceTarget)).Put("/instance-info/{id}", InstanceInfoHandler(handler.sm, handler.store))
+
+			// groups API endpoints
+			r.With(audit(groupBodyTarget)).Post("/groups", handler.AddGroupHandler)
+			r.With(audit(groupTarget("name"))).Put("/groups/{name}", handler.UpdateGroupHandler)
+			r.With(audit(groupTarget("id"))).Delete("/groups/{id}", handler.RemoveGroupHandler)
+			r.With(audit(groupTarget("name"))).Post("/groups/{name}/versions/{version}/rollback", handler.RollbackGroupHandler)
+
+			r.With(audit(smdCacheTarget)).Post("/smd/refresh", SMDRefreshHandler(handler.sm))
+
+			if wgInterfaceManager != nil {
+				r.With(audit(wireGuardPeerTarget)).Delete("/wireguard/peers/{name}", RemoveWireGuardPeerHandler(wgInterfaceManager, handler.sm))
+			}
+
+			if nodeTokens != nil {
+				r.With(audit(instanceTarget)).Post("/node-tokens/{id}", NodeTokenHandler(nodeTokens))
+			}
+		})
 
 		if impersonationEnabled {
 			// impersonation API endpoints
-			r.Get("/impersonation/{id}/user-data", UserDataHandler)
-			r.Get("/impersonation/{id}/meta-data", MetaDataHandler(handler.sm, handler.store))
-			r.Get("/impersonation/{id}/vendor-data", VendorDataHandler(handler.sm, handler.store, baseUrl))
-			r.Get("/impersonation/{id}/{group}.yaml", GroupUserDataHandler(handler.sm, handler.store))
+			r.Group(func(r chi.Router) {
+				r.Use(requireScope(impersonationScope))
+
+				r.Get("/impersonation/{id}/user-data", UserDataHandler(handler.sm, handler.store))
+				r.Get("/impersonation/{id}/meta-data", MetaDataHandler(handler.sm, handler.store))
+				r.Get("/impersonation/{id}/vendor-data", VendorDataHandler(handler.sm, handler.store, baseUrl))
+				r.Get("/impersonation/{id}/network-config", NetworkConfigHandler(handler.sm, handler.store))
+				r.Get("/impersonation/{id}/{group}.yaml", GroupUserDataHandler(handler.sm, handler.store, renderTemplates))
+			})
+		}
+
+		if events, ok := handler.sm.(smdEventApplier); ok {
+			r.Group(func(r chi.Router) {
+				r.Use(requireScope(smdEventsScope))
+
+				r.Post("/smd/events", SMDEventsHandler(events))
+			})
 		}
 
 		if fakeSMDEnabled {
-			r.Post("/fake-sm/nodes", smdclient.AddNodeToInventoryHandler(handler.sm.(*smdclient.FakeSMDClient)))
-			r.Get("/fake-sm/nodes", smdclient.ListNodesHandler(handler.sm.(*smdclient.FakeSMDClient)))
-			r.Put("/fake-sm/nodes/{id}", smdclient.UpdateNodeHandler(handler.sm.(*smdclient.FakeSMDClient)))
+			r.Group(func(r chi.Router) {
+				r.Use(requireScope(fakeSMScope))
+
+				r.Post("/fake-sm/nodes", smdclient.AddNodeToInventoryHandler(handler.sm.(*smdclient.FakeSMDClient)))
+				r.Get("/fake-sm/nodes", smdclient.ListNodesHandler(handler.sm.(*smdclient.FakeSMDClient)))
+				r.Put("/fake-sm/nodes/{id}", smdclient.UpdateNodeHandler(handler.sm.(*smdclient.FakeSMDClient)))
+			})
 		}
 
 	})
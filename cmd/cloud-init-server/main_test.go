@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWireGuardSubnets(t *testing.T) {
+	tests := []struct {
+		name     string
+		server   string
+		prefixes string
+		expected string
+		wantErr  bool
+	}{
+		{"server only", "100.97.0.1/16", "", "100.97.0.0/16", false},
+		{"dual-stack", "100.97.0.1/16", "fd42::1/64", "100.97.0.0/16,fd42::/64", false},
+		{"several prefixes", "100.97.0.1/16", " fd42::/64, 100.98.0.0/16", "100.97.0.0/16,fd42::/64,100.98.0.0/16", false},
+		{"invalid prefix", "100.97.0.1/16", "fd42::1", "", true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			subnets, err := wireGuardSubnets(tc.server, tc.prefixes)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, subnets)
+		})
+	}
+}
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
 	"fmt"
 
+	"github.com/OpenCHAMI/cloud-init/internal/tracing"
 	"github.com/OpenCHAMI/cloud-init/pkg/cistore"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type MetaData struct {
@@ -25,6 +28,7 @@ type InstanceData struct {
 		Region           string      `json:"region,omitempty" yaml:"region,omitempty"`
 		Hostname         string      `json:"hostname,omitempty" yaml:"hostname,omitempty"`
 		LocalIPv4        interface{} `json:"local-ipv4,omitempty" yaml:"local_ipv4,omitempty"`
+		LocalIPv6        string      `json:"local-ipv6,omitempty" yaml:"local_ipv6,omitempty"`
 		CloudProvider    string      `json:"cloud-provider,omitempty" yaml:"cloud_provider,omitempty"`
 		PublicKeys       []string    `json:"public-keys,omitempty" yaml:"public_keys,omitempty"`
 		VendorData       VendorData  `json:"vendor-data,omitempty" yaml:"vendor_data,omitempty"`
@@ -32,31 +36,42 @@ type InstanceData struct {
 }
 
 type VendorData struct {
-	Version          string           `json:"version" yaml:"version"`
-	CloudInitBaseURL string           `json:"cloud-init-base-url,omitempty" yaml:"cloud_init_base_url,omitempty"`
-	Rack             string           `json:"rack,omitempty" yaml:"rack,omitempty"`
-	Nid              int64            `json:"nid,omitempty" yaml:"nid,omitempty"`
-	Role             string           `json:"role,omitempty" yaml:"role,omitempty"`
-	SubRole          string           `json:"sub-role,omitempty" yaml:"sub_role,omitempty"`
-	Cabinet          string           `json:"cabinet,omitempty" yaml:"cabinet,omitempty"`
-	Location         string           `json:"location,omitempty" yaml:"location,omitempty"`
-	ClusterName      string           `json:"cluster_name,omitempty" yaml:"cluster_name,omitempty" example:"demo" description:"Long name of entire cluster, used as a human-readable identifier and is used in the cluster's FQDN"`
-	Groups           map[string]Group `json:"groups" yaml:"groups" description:"Groups known to cloud-init and their meta-data"`
+	Version          string                       `json:"version" yaml:"version"`
+	CloudInitBaseURL string                       `json:"cloud-init-base-url,omitempty" yaml:"cloud_init_base_url,omitempty"`
+	Rack             string                       `json:"rack,omitempty" yaml:"rack,omitempty"`
+	Nid              int64                        `json:"nid,omitempty" yaml:"nid,omitempty"`
+	Role             string                       `json:"role,omitempty" yaml:"role,omitempty"`
+	SubRole          string                       `json:"sub-role,omitempty" yaml:"sub_role,omitempty"`
+	Cabinet          string                       `json:"cabinet,omitempty" yaml:"cabinet,omitempty"`
+	Location         string                       `json:"location,omitempty" yaml:"location,omitempty"`
+	ClusterName      string                       `json:"cluster_name,omitempty" yaml:"cluster_name,omitempty" example:"demo" description:"Long name of entire cluster, used as a human-readable identifier and is used in the cluster's FQDN"`
+	Groups           map[string]Group             `json:"groups" yaml:"groups" description:"Groups known to cloud-init and their meta-data"`
+	Interfaces       []cistore.ComponentInterface `json:"interfaces,omitempty" yaml:"interfaces,omitempty" description:"Network interfaces of the node known to SMD, with the one it boots from marked"`
 }
 
 type Group map[string]interface{}
 
-func generateMetaData(component cistore.OpenCHAMIComponent, groups []string, s cistore.Store) MetaData {
-	metadata := MetaData{}
-	extendedInstanceData, err := s.GetInstanceInfo(component.ID)
+// nodeSettings loads a node's instance info and the cluster defaults, which
+// both its component and its meta-data are built from. Settings that can't be
+// loaded are left empty.
+func nodeSettings(ctx context.Context, id string, s cistore.Store) (cistore.OpenCHAMIInstanceInfo, cistore.ClusterDefaults) {
+	_, span := tracing.Start(ctx, "store.GetInstanceInfo", attribute.String("node.id", id))
+	instanceInfo, err := s.GetInstanceInfo(id)
+	tracing.End(span, err)
 	if err != nil {
 		log.Err(err).Msg("Error getting instance info")
 	}
-	// Add in cluster Defaults
+	_, span = tracing.Start(ctx, "store.GetClusterDefaults")
 	clusterDefaults, err := s.GetClusterDefaults()
+	tracing.End(span, err)
 	if err != nil {
 		log.Err(err).Msg("Error getting cluster defaults")
 	}
+	return instanceInfo, clusterDefaults
+}
+
+func generateMetaData(ctx context.Context, component cistore.OpenCHAMIComponent, groups []string, extendedInstanceData cistore.OpenCHAMIInstanceInfo, clusterDefaults cistore.ClusterDefaults, s cistore.Store) MetaData {
+	metadata := MetaData{}
 
 	// Update extended information from within cloud-init
 	metadata.InstanceID = extendedInstanceData.InstanceID
@@ -78,7 +93,9 @@ func generateMetaData(component cistore.OpenCHAMIComponent, groups []string, s c
 		instanceData.V1.VendorData.Groups = make(map[string]Group)
 	}
 	for _, group := range groups {
+		_, span := tracing.Start(ctx, "store.GetGroupData", attribute.String("group", group))
 		gd, err := s.GetGroupData(group)
+		tracing.End(span, err)
 		instanceData.V1.VendorData.Groups[group] = make(map[string]interface{})
 		instanceData.V1.VendorData.Groups[group]["Description"] = "No description Found"
 		if err != nil {
@@ -93,7 +110,12 @@ func generateMetaData(component cistore.OpenCHAMIComponent, groups []string, s c
 		}
 	}
 
-	instanceData.V1.LocalIPv4 = component.IP
+	localIPv4, localIPv6 := localAddresses(component)
+	if localIPv4 != "" {
+		instanceData.V1.LocalIPv4 = localIPv4
+	}
+	instanceData.V1.LocalIPv6 = localIPv6
+	instanceData.V1.VendorData.Interfaces = component.Interfaces
 	instanceData.V1.VendorData.Version = "1.0"
 
 	// Add extended attributes
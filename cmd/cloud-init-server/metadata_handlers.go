@@ -3,30 +3,22 @@ package main
 import (
 	"fmt"
 	"net/http"
-	"strings"
 
+	"github.com/OpenCHAMI/cloud-init/internal/metrics"
+	openchami_middleware "github.com/OpenCHAMI/cloud-init/internal/middleware"
 	"github.com/OpenCHAMI/cloud-init/internal/smdclient"
+	"github.com/OpenCHAMI/cloud-init/internal/tracing"
 	"github.com/OpenCHAMI/cloud-init/pkg/cistore"
 	"github.com/go-chi/chi/v5"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
 	yaml "gopkg.in/yaml.v2"
 )
 
+// getActualRequestIP returns the IP of the node that made a request. Forwarding
+// headers are only honoured from trusted proxies.
 func getActualRequestIP(r *http.Request) string {
-	var ip string
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		// If X-Forwarded-For exists, use the first IP in the list
-		ip = strings.Split(xff, ",")[0]
-	} else {
-		// Otherwise, use the remote address
-		portIndex := strings.LastIndex(r.RemoteAddr, ":")
-		if portIndex > 0 {
-			ip = r.RemoteAddr[:portIndex]
-		} else {
-			ip = r.RemoteAddr
-		}
-	}
-	return strings.TrimSpace(ip)
+	return openchami_middleware.ClientIP(r)
 }
 
 // MetaDataHandler godoc
@@ -47,6 +39,7 @@ func getActualRequestIP(r *http.Request) string {
 //	@Router			/admin/impersonation/{id}/meta-data [get]
 func MetaDataHandler(smd smdclient.SMDClientInterface, store cistore.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
 		urlId := chi.URLParam(r, "id")
 		var id string
 		var err error
@@ -56,9 +49,12 @@ func MetaDataHandler(smd smdclient.SMDClientInterface, store cistore.Store) http
 			ip := getActualRequestIP(r)
 			log.Debug().Msgf("requesting IP is: %s", ip)
 			// Get the component information from the SMD client
+			_, span := tracing.Start(ctx, "smd.IDfromIP", attribute.String("client.address", ip))
 			id, err = smd.IDfromIP(ip)
+			tracing.End(span, err)
 			if err != nil {
 				log.Printf("did not find id from ip %s: %v", ip, err)
+				metrics.UnknownIPResponses.Inc()
 				w.WriteHeader(http.StatusUnprocessableEntity)
 				return
 			} else {
@@ -68,7 +64,9 @@ func MetaDataHandler(smd smdclient.SMDClientInterface, store cistore.Store) http
 			id = urlId
 		}
 		log.Debug().Msgf("Getting metadata for id: %s", id)
+		_, span := tracing.Start(ctx, "smd.ComponentInformationWithRetry", attribute.String("node.id", id))
 		smdComponent, err := smd.ComponentInformationWithRetry(id, 3)
+		tracing.End(span, err)
 		if err != nil {
 			if esr, ok := err.(smdclient.ErrSMDResponse); ok {
 				var msg string
@@ -88,7 +86,9 @@ func MetaDataHandler(smd smdclient.SMDClientInterface, store cistore.Store) http
 			}
 			return
 		}
+		_, span = tracing.Start(ctx, "smd.GroupMembership", attribute.String("node.id", id))
 		groups, err := smd.GroupMembership(id)
+		tracing.End(span, err)
 		if err != nil {
 			if esr, ok := err.(smdclient.ErrSMDResponse); ok {
 				switch esr.HTTPResponse.StatusCode {
@@ -101,23 +101,10 @@ func MetaDataHandler(smd smdclient.SMDClientInterface, store cistore.Store) http
 				}
 			}
 		}
-		bootIP, err := smd.IPfromID(id)
-		if err != nil {
-			// If the IP information is not available, return an empty string
-			bootIP = ""
-		}
-		bootMAC, err := smd.MACfromID(id)
-		if err != nil {
-			// If the MAC information is not available, return an empty string
-			bootMAC = ""
-		}
-		component := cistore.OpenCHAMIComponent{
-			Component: smdComponent,
-			IP:        bootIP,
-			MAC:       bootMAC,
-		}
+		instanceInfo, clusterDefaults := nodeSettings(ctx, id, store)
+		component := nodeComponent(id, smdComponent, smd, clusterDefaults, instanceInfo)
 
-		metadata := generateMetaData(component, groups, store)
+		metadata := generateMetaData(ctx, component, groups, instanceInfo, clusterDefaults, store)
 
 		w.Header().Set("Content-Type", "application/x-yaml")
 		w.WriteHeader(http.StatusOK)
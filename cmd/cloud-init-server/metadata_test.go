@@ -2,9 +2,12 @@ package main
 
 import (
 	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	base "github.com/Cray-HPE/hms-base"
+	openchami_middleware "github.com/OpenCHAMI/cloud-init/internal/middleware"
 	"github.com/OpenCHAMI/cloud-init/pkg/cistore"
 )
 
@@ -80,3 +83,37 @@ func TestGenerateHostname(t *testing.T) {
 		})
 	}
 }
+
+func TestGetActualRequestIP(t *testing.T) {
+	resolver, err := openchami_middleware.NewClientIPResolver("10.0.0.5")
+	if err != nil {
+		t.Fatalf("failed to create client IP resolver: %v", err)
+	}
+	tests := []struct {
+		remoteAddr string
+		xff        string
+		expected   string
+	}{
+		{"10.20.30.1:12345", "", "10.20.30.1"},
+		{"10.20.30.1", "", "10.20.30.1"},
+		{"[fd00::1]:12345", "", "fd00::1"},
+		{"fd00::1", "", "fd00::1"},
+		// Only trusted proxies may forward the node's IP
+		{"10.20.30.1:12345", "fd00::2", "10.20.30.1"},
+		{"10.0.0.5:12345", "10.20.30.2, fd00::2", "fd00::2"},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/meta-data", nil)
+		req.RemoteAddr = tc.remoteAddr
+		if tc.xff != "" {
+			req.Header.Set("X-Forwarded-For", tc.xff)
+		}
+		var ip string
+		resolver.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip = getActualRequestIP(r)
+		})).ServeHTTP(httptest.NewRecorder(), req)
+		if ip != tc.expected {
+			t.Errorf("getActualRequestIP(%q, %q) = %q, want %q", tc.remoteAddr, tc.xff, ip, tc.expected)
+		}
+	}
+}
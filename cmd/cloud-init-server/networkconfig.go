@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/OpenCHAMI/cloud-init/internal/smdclient"
+	"github.com/OpenCHAMI/cloud-init/pkg/cistore"
+	"github.com/rs/zerolog/log"
+)
+
+// generateNetworkConfig builds a network-config (version 2) document from the
+// interfaces known to SMD, then layers the network-config overrides of each
+// group (in membership order) and finally of the node itself on top.
+func generateNetworkConfig(id string, interfaces []smdclient.NodeInterface, groups []string, s cistore.Store) cistore.NetworkConfig {
+	clusterDefaults, err := s.GetClusterDefaults()
+	if err != nil {
+		log.Err(err).Msg("Error getting cluster defaults")
+	}
+	bootSubnet := smdclient.BootSubnet(clusterDefaults)
+
+	networkConfig := cistore.NetworkConfig{
+		Version:   2,
+		Ethernets: make(map[string]cistore.NetworkEthernet),
+	}
+	for i, iface := range interfaces {
+		if iface.MAC == "" {
+			continue
+		}
+		networkConfig.Ethernets[fmt.Sprintf("interface%d", i)] = ethernetFromInterface(iface, bootSubnet)
+	}
+
+	for _, group := range groups {
+		gd, err := s.GetGroupData(group)
+		if err != nil || gd.Network == nil {
+			continue
+		}
+		log.Debug().Msgf("Applying network-config overrides from group %s to %s", group, id)
+		networkConfig = networkConfig.Merge(*gd.Network)
+	}
+
+	instanceInfo, err := s.GetInstanceInfo(id)
+	if err != nil {
+		log.Err(err).Msg("Error getting instance info")
+	} else if instanceInfo.Network != nil {
+		log.Debug().Msgf("Applying network-config overrides from instance info to %s", id)
+		networkConfig = networkConfig.Merge(*instanceInfo.Network)
+	}
+
+	return networkConfig
+}
+
+// ethernetFromInterface converts an SMD interface into a network-config
+// entry. SMD does not record prefix lengths, so addresses are only
+// configured statically when they fall within the cluster's boot subnet.
+// Otherwise the interface falls back to DHCP.
+func ethernetFromInterface(iface smdclient.NodeInterface, bootSubnet *net.IPNet) cistore.NetworkEthernet {
+	eth := cistore.NetworkEthernet{
+		Match: &cistore.NetworkMatch{MACAddress: strings.ToLower(iface.MAC)},
+	}
+	dhcp := true
+	if bootSubnet != nil {
+		ones, _ := bootSubnet.Mask.Size()
+		for _, addr := range iface.Addresses() {
+			if ip := net.ParseIP(addr); ip != nil && bootSubnet.Contains(ip) {
+				eth.Addresses = append(eth.Addresses, fmt.Sprintf("%s/%d", ip.String(), ones))
+				dhcp = false
+			}
+		}
+	}
+	eth.DHCP4 = &dhcp
+	return eth
+}
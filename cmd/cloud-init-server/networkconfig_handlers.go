@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/OpenCHAMI/cloud-init/internal/smdclient"
+	"github.com/OpenCHAMI/cloud-init/pkg/cistore"
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// NetworkConfigHandler godoc
+//
+//	@Summary		Get network-config for requesting node
+//	@Description	Get a version 2 network-config document for requesting node
+//	@Description	based on the requesting IP. The document is generated from the
+//	@Description	interfaces known to SMD. Addresses within the cluster's
+//	@Description	`boot-subnet` are configured statically, other interfaces use
+//	@Description	DHCP. Network-config overrides of the node's groups and then
+//	@Description	of the node itself are layered on top.
+//	@Description
+//	@Description	If the impersonation API is enabled, an ID can be provided in
+//	@Description	the URL path using `/admin/impersonation`. In this case, the
+//	@Description	network-config will be retrieved for the requested ID.
+//	@Produce		application/x-yaml
+//	@Success		200	{object}	cistore.NetworkConfig
+//	@Failure		404	{object}	nil
+//	@Failure		422	{object}	nil
+//	@Failure		500	{object}	nil
+//	@Param			id	path		string	false	"Node ID"
+//	@Router			/network-config [get]
+//	@Router			/admin/impersonation/{id}/network-config [get]
+func NetworkConfigHandler(smd smdclient.SMDClientInterface, store cistore.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		urlId := chi.URLParam(r, "id")
+		var id = urlId
+		var err error
+		// If this request includes an id, it can be interrpreted as an impersonation request
+		if urlId == "" {
+			log.Debug().Msg("no id specified in request, attempting to identify based on requesting IP")
+			ip := getActualRequestIP(r)
+			log.Debug().Msgf("requesting IP is: %s", ip)
+			id, err = smd.IDfromIP(ip)
+			if err != nil {
+				log.Printf("did not find id from ip %s: %v", ip, err)
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				return
+			}
+		}
+		log.Debug().Msgf("Getting network-config for id: %s", id)
+
+		interfaces, err := smd.InterfacesFromID(id)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("node %s not found in SMD", id), http.StatusNotFound)
+			return
+		}
+		groups, err := smd.GroupMembership(id)
+		if err != nil {
+			log.Debug().Err(err).Msgf("failed to get group membership for id %s, group overrides will not be applied", id)
+			groups = []string{}
+		}
+
+		networkConfig := generateNetworkConfig(id, interfaces, groups, store)
+
+		yamlData, err := yaml.Marshal(networkConfig)
+		if err != nil {
+			http.Error(w, "Failed to encode network-config to YAML", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-yaml")
+		w.WriteHeader(http.StatusOK)
+		if _, err = w.Write(yamlData); err != nil {
+			log.Error().Err(err).Msg("failed to write response")
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/OpenCHAMI/cloud-init/internal/memstore"
+	"github.com/OpenCHAMI/cloud-init/internal/smdclient"
+	"github.com/OpenCHAMI/cloud-init/pkg/cistore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateNetworkConfig(t *testing.T) {
+	store := memstore.NewMemStore()
+	require.NoError(t, store.SetClusterDefaults(cistore.ClusterDefaults{
+		ClusterName: "cluster",
+		BootSubnet:  "10.20.16.0/20",
+	}))
+	require.NoError(t, store.AddGroupData("compute", cistore.GroupData{
+		Name: "compute",
+		Network: &cistore.NetworkConfig{
+			Ethernets: map[string]cistore.NetworkEthernet{
+				"interface0": {
+					Routes:      []cistore.NetworkRoute{{To: "0.0.0.0/0", Via: "10.20.16.1"}},
+					Nameservers: &cistore.NetworkNameservers{Addresses: []string{"10.20.16.2"}},
+				},
+			},
+		},
+	}))
+	require.NoError(t, store.SetInstanceInfo("x3000c0b0n1", cistore.OpenCHAMIInstanceInfo{
+		Network: &cistore.NetworkConfig{
+			Ethernets: map[string]cistore.NetworkEthernet{
+				"hsn": {
+					Match:     &cistore.NetworkMatch{MACAddress: "00:DE:AD:BE:EF:02"},
+					SetName:   "hsn0",
+					Addresses: []string{"172.16.0.1/16"},
+					MTU:       9000,
+				},
+			},
+		},
+	}))
+
+	interfaces := []smdclient.NodeInterface{
+		{MAC: "00:DE:AD:BE:EF:01", IP: "10.20.30.1"},
+		{MAC: "00:DE:AD:BE:EF:02", IP: "192.168.0.1"},
+		{IP: "192.168.0.2"}, // no MAC to match on, so it is skipped
+	}
+
+	nc := generateNetworkConfig("x3000c0b0n1", interfaces, []string{"compute", "unknown"}, store)
+
+	assert.Equal(t, 2, nc.Version)
+	require.Len(t, nc.Ethernets, 2)
+
+	boot := nc.Ethernets["interface0"]
+	assert.Equal(t, "00:de:ad:be:ef:01", boot.Match.MACAddress)
+	assert.Equal(t, []string{"10.20.30.1/20"}, boot.Addresses)
+	require.NotNil(t, boot.DHCP4)
+	assert.False(t, *boot.DHCP4)
+	assert.Equal(t, "10.20.16.1", boot.Routes[0].Via)
+	assert.Equal(t, []string{"10.20.16.2"}, boot.Nameservers.Addresses)
+
+	// Outside the boot subnet, so DHCP unless overridden
+	hsn := nc.Ethernets["interface1"]
+	assert.Equal(t, "hsn0", hsn.SetName)
+	assert.Equal(t, []string{"172.16.0.1/16"}, hsn.Addresses)
+	assert.Equal(t, 9000, hsn.MTU)
+	require.NotNil(t, hsn.DHCP4)
+	assert.True(t, *hsn.DHCP4)
+}
+
+func TestEthernetFromInterface_AllAddresses(t *testing.T) {
+	_, bootSubnet, err := net.ParseCIDR("10.20.16.0/20")
+	require.NoError(t, err)
+
+	// Every address in the boot subnet is configured statically
+	eth := ethernetFromInterface(smdclient.NodeInterface{
+		MAC: "00:DE:AD:BE:EF:01",
+		IP:  "192.168.0.1",
+		IPs: []string{"192.168.0.1", "10.20.30.1", "10.20.30.2"},
+	}, bootSubnet)
+	assert.Equal(t, []string{"10.20.30.1/20", "10.20.30.2/20"}, eth.Addresses)
+	require.NotNil(t, eth.DHCP4)
+	assert.False(t, *eth.DHCP4)
+}
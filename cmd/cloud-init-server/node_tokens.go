@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/OpenCHAMI/cloud-init/internal/metrics"
+	"github.com/OpenCHAMI/cloud-init/internal/smdclient"
+	jwtauth "github.com/OpenCHAMI/jwtauth/v5"
+	"github.com/go-chi/chi/v5"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// nodeTokenAudience is the audience of node tokens, so that they can't be
+	// mistaken for tokens issued for anything else
+	nodeTokenAudience = "cloud-init-node"
+	// nodeTokenMinSecretLength is the shortest accepted signing secret. RFC
+	// 7518 requires HS256 keys to be at least as long as the hash output.
+	nodeTokenMinSecretLength = 32
+)
+
+type nodeTokenContextKey struct{}
+
+// queryNodeTokenContextKey holds the node token StripNodeTokenQuery removed
+// from the query of a request
+type queryNodeTokenContextKey struct{}
+
+// NodeTokens mints and verifies per-node tokens, which nodes present along
+// with their IP to prove their identity. A node token is a JWT signed by the
+// server whose subject is the node's xname.
+type NodeTokens struct {
+	auth *jwtauth.JWTAuth
+	ttl  time.Duration
+}
+
+// NodeTokenResponse is returned when a node token is minted
+type NodeTokenResponse struct {
+	ID      string     `json:"id" example:"x3000c0s0b0n0"`
+	Token   string     `json:"token"`
+	Expires *time.Time `json:"expires,omitempty" description:"When the token expires, if it does"`
+}
+
+// NewNodeTokens creates a NodeTokens that signs tokens with secret. Tokens
+// expire after ttl, or never if it is 0.
+func NewNodeTokens(secret string, ttl time.Duration) (*NodeTokens, error) {
+	if len(secret) < nodeTokenMinSecretLength {
+		return nil, fmt.Errorf("node token secret must be at least %d bytes long", nodeTokenMinSecretLength)
+	}
+	return &NodeTokens{
+		auth: jwtauth.New("HS256", []byte(secret), nil, jwt.WithAudience(nodeTokenAudience)),
+		ttl:  ttl,
+	}, nil
+}
+
+// Mint returns a token for the node with the given ID, and when it expires if
+// it does
+func (n *NodeTokens) Mint(id string) (string, *time.Time, error) {
+	now := time.Now()
+	claims := map[string]interface{}{
+		jwt.SubjectKey:  id,
+		jwt.AudienceKey: nodeTokenAudience,
+	}
+	jwtauth.SetIssuedAt(claims, now)
+	var expires *time.Time
+	if n.ttl > 0 {
+		exp := now.Add(n.ttl).Truncate(time.Second)
+		jwtauth.SetExpiry(claims, exp)
+		expires = &exp
+	}
+	_, token, err := n.auth.Encode(claims)
+	if err != nil {
+		return "", nil, err
+	}
+	return token, expires, nil
+}
+
+// Verify checks a token's signature, audience, and expiry and returns the ID
+// of the node it was issued to
+func (n *NodeTokens) Verify(token string) (string, error) {
+	t, err := jwtauth.VerifyToken(n.auth, token)
+	if err != nil {
+		return "", err
+	}
+	if t.Subject() == "" {
+		return "", errors.New("token has no subject")
+	}
+	return t.Subject(), nil
+}
+
+// StripNodeTokenQuery removes the token query parameter from each request and
+// keeps it on the request context instead. It must run before the request
+// loggers, which log the full request URI, so that node tokens don't end up
+// in the access logs.
+func StripNodeTokenQuery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if !query.Has("token") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		token := query.Get("token")
+		query.Del("token")
+		r = r.WithContext(context.WithValue(r.Context(), queryNodeTokenContextKey{}, token))
+		u := *r.URL
+		u.RawQuery = query.Encode()
+		r.URL = &u
+		r.RequestURI = u.RequestURI()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// nodeTokenFromRequest returns the node token presented with a request, either
+// as a bearer token or in the token query parameter. The query parameter
+// allows the token to be given to cloud-init in the seed URL on the kernel
+// command line. It is only read once StripNodeTokenQuery has moved it to the
+// request context.
+func nodeTokenFromRequest(r *http.Request) string {
+	if token := jwtauth.TokenFromHeader(r); token != "" {
+		return token
+	}
+	token, _ := r.Context().Value(queryNodeTokenContextKey{}).(string)
+	return token
+}
+
+// Middleware only lets requests through if they present a node token issued
+// to the node that SMD says has the requesting IP
+func (n *NodeTokens) Middleware(smd smdclient.SMDClientInterface) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := nodeTokenFromRequest(r)
+			if token == "" {
+				metrics.NodeTokenRejections.WithLabelValues("missing").Inc()
+				http.Error(w, "node token required", http.StatusUnauthorized)
+				return
+			}
+			tokenID, err := n.Verify(token)
+			if err != nil {
+				log.Debug().Err(err).Msg("rejecting invalid node token")
+				metrics.NodeTokenRejections.WithLabelValues("invalid").Inc()
+				http.Error(w, "invalid node token", http.StatusUnauthorized)
+				return
+			}
+			ip := getActualRequestIP(r)
+			id, err := smd.IDfromIP(ip)
+			if err != nil {
+				log.Debug().Err(err).Msgf("did not find id from ip %s", ip)
+				metrics.UnknownIPResponses.Inc()
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				return
+			}
+			if tokenID != id {
+				log.Warn().Msgf("rejecting node token issued to %s presented by %s (%s)", tokenID, id, ip)
+				metrics.NodeTokenRejections.WithLabelValues("mismatch").Inc()
+				http.Error(w, "node token was not issued to the requesting node", http.StatusForbidden)
+				return
+			}
+			ctx := context.WithValue(r.Context(), nodeTokenContextKey{}, token)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// verifiedNodeToken returns the node token that the node token middleware
+// verified for a request, or an empty string if it didn't verify one
+func verifiedNodeToken(r *http.Request) string {
+	token, _ := r.Context().Value(nodeTokenContextKey{}).(string)
+	return token
+}
+
+// NodeTokenHandler godoc
+//
+//	@Summary		Mint a node token
+//	@Description	Mint a token that the node with the given ID presents to
+//	@Description	prove its identity when node tokens are enabled. The node
+//	@Description	sends it as a bearer token or in the `token` query
+//	@Description	parameter, e.g. in the seed URL on its kernel command line:
+//	@Description	`ds=nocloud-net;s=http://cloud-init:27777/cloud-init/%s?token=<token>`.
+//	@Description	Requests with the token are only served if SMD says that the
+//	@Description	requesting IP belongs to the same node.
+//	@Tags			admin,node-tokens
+//	@Produce		json
+//	@Param			id	path		string	true	"Node ID"
+//	@Success		200	{object}	NodeTokenResponse
+//	@Failure		500	{object}	nil
+//	@Router			/admin/node-tokens/{id} [post]
+func NodeTokenHandler(tokens *NodeTokens) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		token, expires, err := tokens.Mint(id)
+		if err != nil {
+			http.Error(w, "failed to mint node token: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Info().Msgf("Minted node token for %s", id)
+		jsonData, err := json.Marshal(NodeTokenResponse{ID: id, Token: token, Expires: expires})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if _, err := w.Write(jsonData); err != nil {
+			log.Error().Err(err).Msg("failed to write response")
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	stdlog "log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/OpenCHAMI/cloud-init/internal/memstore"
+	"github.com/OpenCHAMI/cloud-init/internal/smdclient"
+	"github.com/OpenCHAMI/cloud-init/pkg/cistore"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	openchami_logger "github.com/openchami/chi-middleware/log"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testNodeTokenSecret = "0123456789abcdef0123456789abcdef"
+
+func TestNodeTokens(t *testing.T) {
+	_, err := NewNodeTokens("too short", 0)
+	assert.Error(t, err)
+
+	tokens, err := NewNodeTokens(testNodeTokenSecret, 0)
+	require.NoError(t, err)
+	token, expires, err := tokens.Mint("x3000c0b0n1")
+	require.NoError(t, err)
+	assert.Nil(t, expires)
+	id, err := tokens.Verify(token)
+	require.NoError(t, err)
+	assert.Equal(t, "x3000c0b0n1", id)
+
+	// Tokens signed with another secret are rejected
+	other, err := NewNodeTokens(strings.Repeat("x", 32), 0)
+	require.NoError(t, err)
+	_, err = other.Verify(token)
+	assert.Error(t, err)
+
+	// Tokens signed with the secret for anything but a node are rejected
+	_, otherAudience, err := tokens.auth.Encode(map[string]interface{}{
+		jwt.SubjectKey:  "x3000c0b0n1",
+		jwt.AudienceKey: "cloud-init-admin",
+	})
+	require.NoError(t, err)
+	_, err = tokens.Verify(otherAudience)
+	assert.Error(t, err)
+
+	// Tokens expire after the TTL
+	expiring, err := NewNodeTokens(testNodeTokenSecret, time.Hour)
+	require.NoError(t, err)
+	token, expires, err = expiring.Mint("x3000c0b0n1")
+	require.NoError(t, err)
+	require.NotNil(t, expires)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), *expires, time.Minute)
+	_, err = expiring.Verify(token)
+	assert.NoError(t, err)
+	_, expired, err := tokens.auth.Encode(map[string]interface{}{
+		jwt.SubjectKey:    "x3000c0b0n1",
+		jwt.AudienceKey:   nodeTokenAudience,
+		jwt.ExpirationKey: time.Now().Add(-time.Hour).Unix(),
+	})
+	require.NoError(t, err)
+	_, err = tokens.Verify(expired)
+	assert.Error(t, err)
+}
+
+func TestNodeTokenMiddleware(t *testing.T) {
+	tokens, err := NewNodeTokens(testNodeTokenSecret, 0)
+	require.NoError(t, err)
+	store := memstore.NewMemStore()
+	require.NoError(t, store.SetClusterDefaults(cistore.ClusterDefaults{ClusterName: "test", BaseUrl: "http://cloud-init:27777/cloud-init"}))
+	sm := smdclient.NewFakeSMDClient("test", 10)
+	handler := StripNodeTokenQuery(tokens.Middleware(sm)(VendorDataHandler(sm, store, baseUrl)))
+
+	nodeToken, _, err := tokens.Mint("x3000c0b0n1")
+	require.NoError(t, err)
+	otherToken, _, err := tokens.Mint("x3000c0b0n2")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name           string
+		remoteAddr     string
+		header         string
+		query          string
+		expectedStatus int
+	}{
+		{"no token", "10.20.30.1:12345", "", "", http.StatusUnauthorized},
+		{"invalid token", "10.20.30.1:12345", "Bearer not-a-token", "", http.StatusUnauthorized},
+		{"token in header", "10.20.30.1:12345", "Bearer " + nodeToken, "", http.StatusOK},
+		{"token in query", "10.20.30.1:12345", "", nodeToken, http.StatusOK},
+		{"another node's token", "10.20.30.1:12345", "Bearer " + otherToken, "", http.StatusForbidden},
+		{"unknown IP", "192.168.1.10:12345", "Bearer " + nodeToken, "", http.StatusUnprocessableEntity},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			target := "/vendor-data"
+			if tc.query != "" {
+				target += "?token=" + tc.query
+			}
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			req.RemoteAddr = tc.remoteAddr
+			if tc.header != "" {
+				req.Header.Set("Authorization", tc.header)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+
+			// The token is passed on to the included group files
+			if rr.Code == http.StatusOK {
+				assert.Contains(t, rr.Body.String(), "http://cloud-init:27777/cloud-init/compute.yaml?token="+nodeToken+"\n")
+			}
+		})
+	}
+}
+
+func TestNodeTokenNotLogged(t *testing.T) {
+	tokens, err := NewNodeTokens(testNodeTokenSecret, 0)
+	require.NoError(t, err)
+	store := memstore.NewMemStore()
+	require.NoError(t, store.SetClusterDefaults(cistore.ClusterDefaults{ClusterName: "test", BaseUrl: "http://cloud-init:27777/cloud-init"}))
+	sm := smdclient.NewFakeSMDClient("test", 10)
+	nodeToken, _, err := tokens.Mint("x3000c0b0n1")
+	require.NoError(t, err)
+
+	// The request loggers in the same order as in the server
+	var logs bytes.Buffer
+	router := chi.NewRouter()
+	router.Use(
+		StripNodeTokenQuery,
+		middleware.RequestLogger(&middleware.DefaultLogFormatter{Logger: stdlog.New(&logs, "", 0), NoColor: true}),
+		openchami_logger.OpenCHAMILogger(zerolog.New(&logs)),
+	)
+	router.With(tokens.Middleware(sm)).Get("/vendor-data", VendorDataHandler(sm, store, baseUrl))
+
+	req := httptest.NewRequest(http.MethodGet, "/vendor-data?token="+nodeToken+"&other=1", nil)
+	req.RemoteAddr = "10.20.30.1:12345"
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "compute.yaml?token="+nodeToken)
+
+	assert.Contains(t, logs.String(), "/vendor-data?other=1")
+	assert.NotContains(t, logs.String(), nodeToken)
+}
+
+func TestNodeTokenHandler(t *testing.T) {
+	tokens, err := NewNodeTokens(testNodeTokenSecret, 0)
+	require.NoError(t, err)
+	router := chi.NewRouter()
+	router.Post("/admin/node-tokens/{id}", NodeTokenHandler(tokens))
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/node-tokens/x3000c0b0n1", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var response NodeTokenResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, "x3000c0b0n1", response.ID)
+	assert.Nil(t, response.Expires)
+	id, err := tokens.Verify(response.Token)
+	require.NoError(t, err)
+	assert.Equal(t, "x3000c0b0n1", id)
+}
+
+func TestNodeTokenHandler_Audited(t *testing.T) {
+	oldNodeTokens := nodeTokens
+	defer func() { nodeTokens = oldNodeTokens }()
+	var err error
+	nodeTokens, err = NewNodeTokens(testNodeTokenSecret, 0)
+	require.NoError(t, err)
+	store := memstore.NewMemStore()
+	router := chi.NewRouter()
+	initCiAdminRouter(router, &CiHandler{sm: smdclient.NewFakeSMDClient("test", 10), store: store}, nil, nil)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/admin/node-tokens/x3000c0b0n1", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	var response NodeTokenResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+
+	// Minting is recorded against the node, without the token
+	entries, err := store.GetAuditEntries(cistore.AuditFilter{Entity: cistore.EntityInstance, Name: "x3000c0b0n1"})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "/admin/node-tokens/x3000c0b0n1", entries[0].Path)
+	recorded, err := json.Marshal(entries[0])
+	require.NoError(t, err)
+	assert.NotContains(t, string(recorded), response.Token)
+}
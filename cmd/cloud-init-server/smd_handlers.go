@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/OpenCHAMI/cloud-init/internal/smdclient"
+	"github.com/rs/zerolog/log"
+)
+
+// SMDCacheHandler godoc
+//
+//	@Summary		Get the cached SMD nodes
+//	@Description	Get the nodes cloud-init has cached from SMD, sorted by
+//	@Description	xname, with their interfaces and group memberships.
+//	@Tags			admin,smd
+//	@Produce		json
+//	@Success		200	{object}	[]smdclient.NodeMapping
+//	@Failure		500	{object}	nil
+//	@Router			/admin/smd/cache [get]
+func SMDCacheHandler(sm smdclient.SMDClientInterface) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSONResponse(w, sm.CachedNodes())
+	}
+}
+
+// SMDRefreshHandler godoc
+//
+//	@Summary		Refresh the SMD cache
+//	@Description	Refresh the node cache from SMD now, rather than waiting for
+//	@Description	the next periodic refresh, and return the nodes that were
+//	@Description	added or removed and the nodes whose interface IPs changed.
+//	@Description	If SMD cannot be reached, the existing cache is kept.
+//	@Tags			admin,smd
+//	@Produce		json
+//	@Success		200	{object}	smdclient.CacheDiff
+//	@Failure		500	{object}	nil
+//	@Failure		502	{object}	nil
+//	@Router			/admin/smd/refresh [post]
+func SMDRefreshHandler(sm smdclient.SMDClientInterface) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		diff, err := sm.RefreshCache()
+		if err != nil {
+			log.Error().Err(err).Msg("failed to refresh SMD cache")
+			http.Error(w, "failed to refresh SMD cache: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+		log.Info().Msgf("SMD cache refreshed: %d nodes added, %d removed, %d with changed IPs",
+			len(diff.Added), len(diff.Removed), len(diff.IPsChanged))
+		writeJSONResponse(w, diff)
+	}
+}
+
+// writeJSONResponse writes v to w as JSON
+func writeJSONResponse(w http.ResponseWriter, v interface{}) {
+	jsonData, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(jsonData); err != nil {
+		log.Error().Err(err).Msg("failed to write response")
+	}
+}
+
+// smdEventApplier is implemented by SMD clients that can apply inventory
+// change events to their cache
+type smdEventApplier interface {
+	ApplyEvents(events []smdclient.Event) error
+}
+
+// SMDEventsHandler godoc
+//
+//	@Summary		Apply SMD inventory changes to the cache
+//	@Description	Apply changes to components, Ethernet interfaces, and group
+//	@Description	memberships in SMD to the node cache as they happen, so that
+//	@Description	it stays fresh without waiting for the next refresh. The body
+//	@Description	holds a single event or a list of events, which are applied
+//	@Description	in order. If any event is invalid, none are applied.
+//	@Tags			admin,smd
+//	@Accept			json
+//	@Param			events	body	[]smdclient.Event	true	"Inventory change events"
+//	@Success		204
+//	@Failure		400	{object}	nil
+//	@Router			/admin/smd/events [post]
+func SMDEventsHandler(applier smdEventApplier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var events []smdclient.Event
+		if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '{' {
+			var event smdclient.Event
+			err = json.Unmarshal(trimmed, &event)
+			events = append(events, event)
+		} else {
+			err = json.Unmarshal(trimmed, &events)
+		}
+		if err != nil {
+			http.Error(w, "invalid events: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := applier.ApplyEvents(events); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Debug().Msgf("Applied %d SMD events", len(events))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/OpenCHAMI/cloud-init/internal/memstore"
+	"github.com/OpenCHAMI/cloud-init/internal/smdclient"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// unreachableSMDClient is an SMD client whose refreshes always fail
+type unreachableSMDClient struct {
+	*smdclient.FakeSMDClient
+}
+
+func (c unreachableSMDClient) RefreshCache() (smdclient.CacheDiff, error) {
+	return smdclient.CacheDiff{}, errors.New("connection refused")
+}
+
+// serveAdmin sends a request to the admin API backed by sm
+func serveAdmin(sm smdclient.SMDClientInterface, method, path, body string) *httptest.ResponseRecorder {
+	router := chi.NewRouter()
+	initCiAdminRouter(router, &CiHandler{sm: sm, store: memstore.NewMemStore()}, nil, nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return rr
+}
+
+func TestSMDHandlers(t *testing.T) {
+	fake := smdclient.NewFakeSMDClient("test", 10)
+
+	rr := serveAdmin(fake, http.MethodGet, "/admin/smd/cache", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var nodes []smdclient.NodeMapping
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &nodes))
+	require.Len(t, nodes, 10)
+	assert.Equal(t, "x3000c0b0n1", nodes[0].Xname)
+	require.Len(t, nodes[0].Interfaces, 1)
+	assert.Equal(t, "10.20.30.1", nodes[0].Interfaces[0].IP)
+	assert.Contains(t, nodes[0].Groups, "compute")
+
+	rr = serveAdmin(fake, http.MethodPost, "/admin/smd/refresh", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var diff smdclient.CacheDiff
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &diff))
+	assert.Empty(t, diff.Added)
+	assert.Empty(t, diff.Removed)
+	assert.Empty(t, diff.IPsChanged)
+
+	rr = serveAdmin(unreachableSMDClient{fake}, http.MethodPost, "/admin/smd/refresh", "")
+	assert.Equal(t, http.StatusBadGateway, rr.Code)
+	assert.Contains(t, rr.Body.String(), "connection refused")
+}
+
+func TestSMDEventsHandler(t *testing.T) {
+	smd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/hsm/v2/Inventory/EthernetInterfaces/":
+			_, _ = w.Write([]byte(`[{"ComponentID": "x1000", "MACAddress": "00:11:22:33:44:55", "IPAddresses": [{"IPAddress": "192.168.1.1"}]}]`))
+		case "/hsm/v2/memberships":
+			_, _ = w.Write([]byte(`[{"id": "x1000", "groupLabels": ["compute"]}]`))
+		default:
+			t.Errorf("unexpected request for %s", r.URL.Path)
+		}
+	}))
+	defer smd.Close()
+	sm, err := smdclient.NewSMDClient("test", smd.URL, "", "", "", false, 0)
+	require.NoError(t, err)
+	defer sm.StopCacheRefresh()
+
+	post := func(body string) *httptest.ResponseRecorder {
+		return serveAdmin(sm, http.MethodPost, "/admin/smd/events", body)
+	}
+
+	// A single event
+	rr := post(`{"type": "interface", "action": "update", "interface": {"ComponentID": "x1001", "MACAddress": "66:77:88:99:AA:BB", "IPAddresses": [{"IPAddress": "192.168.1.3"}]}}`)
+	require.Equal(t, http.StatusNoContent, rr.Code, rr.Body.String())
+	id, err := sm.IDfromIP("192.168.1.3")
+	require.NoError(t, err)
+	assert.Equal(t, "x1001", id)
+
+	// A list of events
+	rr = post(`[
+		{"type": "membership", "action": "update", "id": "x1001", "groups": ["io"]},
+		{"type": "component", "action": "delete", "id": "x1000"}
+	]`)
+	require.Equal(t, http.StatusNoContent, rr.Code, rr.Body.String())
+	groups, err := sm.GroupMembership("x1001")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"io"}, groups)
+	_, err = sm.IDfromIP("192.168.1.1")
+	assert.Error(t, err)
+
+	rr = post(`{"type": "membership", "action": "update"}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	rr = post(`not json`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	// The fake SMD client is changed through its own API instead
+	rr = serveAdmin(smdclient.NewFakeSMDClient("test", 10), http.MethodPost, "/admin/smd/events", "[]")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
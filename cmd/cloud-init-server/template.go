@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/OpenCHAMI/cloud-init/internal/smdclient"
+	"github.com/OpenCHAMI/cloud-init/internal/tracing"
+	"github.com/OpenCHAMI/cloud-init/pkg/cistore"
+	"github.com/nikolalohinski/gonja/v2"
+	"github.com/nikolalohinski/gonja/v2/exec"
+	"github.com/nikolalohinski/gonja/v2/loaders"
+	"go.opentelemetry.io/otel/attribute"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// jinjaHeader matches the first line cloud-init uses to recognize a jinja
+// template, e.g. `## template: jinja`.
+var jinjaHeader = regexp.MustCompile(`(?i)^#+\s*template:\s*jinja\s*$`)
+
+// isJinjaTemplate reports whether content starts with a jinja template header.
+func isJinjaTemplate(content []byte) bool {
+	firstLine, _, _ := bytes.Cut(content, []byte("\n"))
+	return jinjaHeader.Match(bytes.TrimRight(firstLine, "\r"))
+}
+
+// renderTemplate renders a jinja cloud-config against a node's meta-data and
+// strips the template header, so the result can be consumed by cloud-init
+// clients without jinja support.
+func renderTemplate(content []byte, metadata MetaData) ([]byte, error) {
+	_, body, _ := bytes.Cut(content, []byte("\n"))
+
+	// Templates are only ever loaded from memory so that includes cannot
+	// read files from the server
+	loader, err := loaders.NewMemoryLoader(map[string]string{"/cloud-config": string(body)})
+	if err != nil {
+		return nil, err
+	}
+	cfg := gonja.DefaultConfig.Inherit()
+	cfg.KeepTrailingNewline = true
+	tpl, err := exec.NewTemplate("/cloud-config", cfg, loader, gonja.DefaultEnvironment)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template: %w", err)
+	}
+
+	ctx, err := templateContext(metadata)
+	if err != nil {
+		return nil, err
+	}
+	rendered, err := tpl.ExecuteToBytes(exec.NewContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("failed to render template: %w", err)
+	}
+	return rendered, nil
+}
+
+// templateContext exposes meta-data the way cloud-init exposes instance-data
+// to its own jinja templates: the standardized `v1` keys are available both
+// under `v1` and at the top level, and the raw meta-data under `ds.meta_data`.
+func templateContext(metadata MetaData) (map[string]interface{}, error) {
+	raw, err := yaml.Marshal(metadata)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode meta-data: %w", err)
+	}
+	var decoded map[interface{}]interface{}
+	if err := yaml.Unmarshal(raw, &decoded); err != nil {
+		return nil, fmt.Errorf("failed to decode meta-data: %w", err)
+	}
+	metaData, _ := stringKeys(decoded).(map[string]interface{})
+
+	ctx := map[string]interface{}{
+		"ds": map[string]interface{}{"meta_data": metaData},
+	}
+	if instanceData, ok := metaData["instance_data"].(map[string]interface{}); ok {
+		if v1, ok := instanceData["v1"].(map[string]interface{}); ok {
+			for k, v := range v1 {
+				ctx[k] = v
+			}
+			ctx["v1"] = v1
+		}
+	}
+	return ctx, nil
+}
+
+// stringKeys recursively converts the map[interface{}]interface{} values
+// produced by yaml.v2 into map[string]interface{} for the template engine.
+func stringKeys(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, val := range t {
+			m[fmt.Sprint(k)] = stringKeys(val)
+		}
+		return m
+	case []interface{}:
+		for i, val := range t {
+			t[i] = stringKeys(val)
+		}
+		return t
+	default:
+		return v
+	}
+}
+
+// nodeMetaData generates the meta-data of a node for template rendering. Only
+// the component lookup is fatal, as in MetaDataHandler missing group, IP, and
+// MAC information is left empty.
+func nodeMetaData(ctx context.Context, id string, smd smdclient.SMDClientInterface, store cistore.Store) (MetaData, error) {
+	_, span := tracing.Start(ctx, "smd.ComponentInformationWithRetry", attribute.String("node.id", id))
+	smdComponent, err := smd.ComponentInformationWithRetry(id, 3)
+	tracing.End(span, err)
+	if err != nil {
+		return MetaData{}, fmt.Errorf("failed to get component information for node %s: %w", id, err)
+	}
+	_, span = tracing.Start(ctx, "smd.GroupMembership", attribute.String("node.id", id))
+	groups, err := smd.GroupMembership(id)
+	tracing.End(span, err)
+	if err != nil {
+		groups = []string{}
+	}
+	instanceInfo, clusterDefaults := nodeSettings(ctx, id, store)
+	component := nodeComponent(id, smdComponent, smd, clusterDefaults, instanceInfo)
+	return generateMetaData(ctx, component, groups, instanceInfo, clusterDefaults, store), nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/OpenCHAMI/cloud-init/internal/memstore"
+	"github.com/OpenCHAMI/cloud-init/internal/smdclient"
+	"github.com/OpenCHAMI/cloud-init/pkg/cistore"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsJinjaTemplate(t *testing.T) {
+	assert.True(t, isJinjaTemplate([]byte("## template: jinja\n#cloud-config\n")))
+	assert.True(t, isJinjaTemplate([]byte("#template: Jinja\r\n#cloud-config\n")))
+	assert.False(t, isJinjaTemplate([]byte("#cloud-config\n## template: jinja\n")))
+	assert.False(t, isJinjaTemplate([]byte("#cloud-config\n")))
+}
+
+func TestGroupUserDataHandler_RenderTemplates(t *testing.T) {
+	sm := smdclient.NewFakeSMDClient("test", 10)
+	store := memstore.NewMemStore()
+	require.NoError(t, store.SetClusterDefaults(cistore.ClusterDefaults{ClusterName: "test"}))
+
+	template := "## template: jinja\n#cloud-config\nrsyslog:\n  remotes: {compute: {{ vendor_data.groups[\"compute\"].syslog_aggregator }}}\ncluster: {{ ds.meta_data[\"cluster-name\"] }}\n"
+	require.NoError(t, store.AddGroupData("compute", cistore.GroupData{
+		Name: "compute",
+		Data: map[string]interface{}{"syslog_aggregator": "192.168.0.1"},
+		File: cistore.CloudConfigFile{Content: []byte(template), Encoding: "plain"},
+	}))
+	require.NoError(t, store.AddGroupData("x3000", cistore.GroupData{
+		Name: "x3000",
+		File: cistore.CloudConfigFile{Content: []byte("## template: jinja\n#cloud-config\n{{ unclosed\n"), Encoding: "plain"},
+	}))
+
+	tests := []struct {
+		name           string
+		render         bool
+		path           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"rendering disabled", false, "/admin/impersonation/x3000c0b0n1/compute.yaml", http.StatusOK, template},
+		{"rendered template", true, "/admin/impersonation/x3000c0b0n1/compute.yaml", http.StatusOK, "#cloud-config\nrsyslog:\n  remotes: {compute: 192.168.0.1}\ncluster: test\n"},
+		{"invalid template", true, "/admin/impersonation/x3000c0b0n1/x3000.yaml", http.StatusInternalServerError, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := chi.NewRouter()
+			router.Get("/admin/impersonation/{id}/{group}.yaml", GroupUserDataHandler(sm, store, tt.render))
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			if tt.expectedBody != "" {
+				assert.Equal(t, tt.expectedBody, rr.Body.String())
+			} else {
+				assert.Contains(t, rr.Body.String(), "failed to render template for group x3000")
+			}
+		})
+	}
+}
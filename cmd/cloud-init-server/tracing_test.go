@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/OpenCHAMI/cloud-init/internal/memstore"
+	"github.com/OpenCHAMI/cloud-init/internal/smdclient"
+	"github.com/OpenCHAMI/cloud-init/internal/tracing"
+	"github.com/OpenCHAMI/cloud-init/pkg/cistore"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestMetaDataHandler_Tracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(previous)
+
+	store := memstore.NewMemStore()
+	require.NoError(t, store.AddGroupData("compute", cistore.GroupData{Name: "compute"}))
+	router := chi.NewRouter()
+	router.Use(tracing.Middleware)
+	router.Get("/meta-data", MetaDataHandler(smdclient.NewFakeSMDClient("test", 10), store))
+
+	req := httptest.NewRequest(http.MethodGet, "/meta-data", nil)
+	req.RemoteAddr = "10.20.30.1:12345"
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	spans := exporter.GetSpans()
+	names := make(map[string]int)
+	var root tracetest.SpanStub
+	for _, span := range spans {
+		names[span.Name]++
+		if !span.Parent.IsValid() {
+			root = span
+		}
+	}
+	assert.Equal(t, "GET /meta-data", root.Name)
+	for _, name := range []string{
+		"smd.IDfromIP",
+		"smd.ComponentInformationWithRetry",
+		"smd.GroupMembership",
+		"store.GetInstanceInfo",
+		"store.GetClusterDefaults",
+	} {
+		assert.Equal(t, 1, names[name], name)
+	}
+	// One span for each group the node belongs to
+	assert.GreaterOrEqual(t, names["store.GetGroupData"], 2)
+
+	// Every span belongs to the request's trace and is a child of its root span
+	for _, span := range spans {
+		assert.Equal(t, root.SpanContext.TraceID(), span.SpanContext.TraceID(), span.Name)
+		if span.Name != root.Name {
+			assert.Equal(t, root.SpanContext.SpanID(), span.Parent.SpanID(), span.Name)
+		}
+	}
+}
@@ -14,21 +14,50 @@ import (
 // UserDataHandler godoc
 //
 //	@Summary		Get user-data for requesting node
-//	@Description	Get user-data for requesting node base on the requesting IP. For
-//	@Description	OpenCHAMI, this will always be `#cloud-config`.
+//	@Description	Get user-data for requesting node base on the requesting IP.
+//	@Description	This is the `user-data` of the node's instance info if it has
+//	@Description	been set, or an empty `#cloud-config` otherwise.
 //	@Description
 //	@Description	If the impersonation API is enabled, an ID can be provided in
 //	@Description	the URL path using `/admin/impersonation`. In this case, the
 //	@Description	user-data will be retrieved for the requested ID.
 //	@Produce		plain
 //	@Success		200	{object}	string
+//	@Failure		500	{object}	nil
 //	@Param			id	path		string	false	"Node ID"
 //	@Router			/user-data [get]
 //	@Router			/admin/impersonation/{id}/user-data [get]
-func UserDataHandler(w http.ResponseWriter, r *http.Request) {
-	payload := `#cloud-config`
-	if _, err := w.Write([]byte(payload)); err != nil {
-		log.Error().Err(err).Msg("failed to write response")
+func UserDataHandler(smd smdclient.SMDClientInterface, store cistore.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		payload := []byte("#cloud-config")
+
+		id := chi.URLParam(r, "id")
+		if id == "" {
+			ip := getActualRequestIP(r)
+			var err error
+			id, err = smd.IDfromIP(ip)
+			if err != nil {
+				// Unknown nodes still get a valid (empty) user-data document
+				log.Debug().Err(err).Msgf("did not find id from ip %s, returning an empty #cloud-config", ip)
+			}
+		}
+
+		if id != "" {
+			info, err := store.GetInstanceInfo(id)
+			if err != nil {
+				log.Err(err).Msgf("Error getting instance info for id %s", id)
+			} else if info.UserData != nil && len(info.UserData.Content) > 0 {
+				payload, err = decodeCloudConfig(*info.UserData)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+			}
+		}
+
+		if _, err := w.Write(payload); err != nil {
+			log.Error().Err(err).Msg("failed to write response")
+		}
 	}
 }
 
@@ -37,6 +66,15 @@ func UserDataHandler(w http.ResponseWriter, r *http.Request) {
 //	@Summary		Get user-data for a particular group
 //	@Description	Get user-data for a particular group based on its name.
 //	@Description
+//	@Description	If the node's instance info pins the group to a revision in
+//	@Description	`group-versions`, or the group has a `pinned-version`, that
+//	@Description	revision of the cloud-config is returned instead of the
+//	@Description	current one. The node's pin takes precedence.
+//	@Description
+//	@Description	If template rendering is enabled, group files beginning with
+//	@Description	`## template: jinja` are rendered on the server against the
+//	@Description	requesting node's meta-data and returned without the header.
+//	@Description
 //	@Description	If the impersonation API is enabled, an ID can be provided in
 //	@Description	the URL path using `/admin/impersonation`. In this case, the
 //	@Description	group user-data will be retrieved for the requested ID.
@@ -48,7 +86,7 @@ func UserDataHandler(w http.ResponseWriter, r *http.Request) {
 //	@Param			group	path		string	true	"Group name"
 //	@Router			/{group}.yaml [get]
 //	@Router			/admin/impersonation/{id}/{group}.yaml [get]
-func GroupUserDataHandler(smd smdclient.SMDClientInterface, store cistore.Store) http.HandlerFunc {
+func GroupUserDataHandler(smd smdclient.SMDClientInterface, store cistore.Store, renderTemplates bool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		id, group, err := getIDAndGroup(r, smd)
 		if err != nil {
@@ -70,24 +108,78 @@ func GroupUserDataHandler(smd smdclient.SMDClientInterface, store cistore.Store)
 			return
 		}
 
+		file, err := groupFileForNode(id, group, data, store)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
 		// Make sure cloud-config content is plaintext before returning
-		if data.File.Encoding == "base64" {
-			decodedContent, err := base64.StdEncoding.DecodeString(string(data.File.Content))
+		content, err := decodeCloudConfig(file)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if renderTemplates && isJinjaTemplate(content) {
+			metadata, err := nodeMetaData(r.Context(), id, smd, store)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			content, err = renderTemplate(content, metadata)
 			if err != nil {
-				newErr := fmt.Errorf("failed to base64-decode cloud-config: %w", err)
-				http.Error(w, newErr.Error(), http.StatusInternalServerError)
+				http.Error(w, fmt.Sprintf("failed to render template for group %s: %v", group, err), http.StatusInternalServerError)
 				return
 			}
-			data.File.Content = decodedContent
-			data.File.Encoding = "plain"
 		}
 
-		if _, err = w.Write(data.File.Content); err != nil {
+		if _, err = w.Write(content); err != nil {
 			log.Error().Err(err).Msg("failed to write response")
 		}
 	}
 }
 
+// groupFileForNode returns the cloud-config of a group to serve to a node. A
+// revision pinned in the node's instance info takes precedence over one pinned
+// on the group itself; without either, the current cloud-config is served.
+func groupFileForNode(id, group string, data cistore.GroupData, store cistore.Store) (cistore.CloudConfigFile, error) {
+	pin := data.PinnedVersion
+	info, err := store.GetInstanceInfo(id)
+	if err != nil {
+		log.Err(err).Msgf("Error getting instance info for id %s, ignoring any revision pinned for the node", id)
+	} else if nodePin, ok := info.GroupVersions[group]; ok {
+		pin = nodePin
+	}
+
+	rev, err := cistore.ParseRevisionPin(pin)
+	if err != nil {
+		return cistore.CloudConfigFile{}, fmt.Errorf("group %s: %w", group, err)
+	}
+	if rev == 0 {
+		return data.File, nil
+	}
+	log.Debug().Msgf("Serving revision %d of group %s to %s", rev, group, id)
+	revision, err := store.GetGroupVersion(group, rev)
+	if err != nil {
+		return cistore.CloudConfigFile{}, fmt.Errorf("%s is pinned to a revision of group %s that cannot be served: %w", id, group, err)
+	}
+	return revision.File, nil
+}
+
+// decodeCloudConfig returns the plaintext content of a cloud-config file,
+// base64-decoding it if needed.
+func decodeCloudConfig(file cistore.CloudConfigFile) ([]byte, error) {
+	if file.Encoding != "base64" {
+		return file.Content, nil
+	}
+	decodedContent, err := base64.StdEncoding.DecodeString(string(file.Content))
+	if err != nil {
+		return nil, fmt.Errorf("failed to base64-decode cloud-config: %w", err)
+	}
+	return decodedContent, nil
+}
+
 func getIDAndGroup(r *http.Request, smd smdclient.SMDClientInterface) (string, string, error) {
 	id := chi.URLParam(r, "id")
 	group := chi.URLParam(r, "group")
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/OpenCHAMI/cloud-init/internal/memstore"
+	"github.com/OpenCHAMI/cloud-init/internal/smdclient"
+	"github.com/OpenCHAMI/cloud-init/pkg/cistore"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserDataHandler(t *testing.T) {
+	sm := smdclient.NewFakeSMDClient("test", 10)
+	store := memstore.NewMemStore()
+
+	debugUser := "#cloud-config\nusers:\n  - name: debug\n"
+	require.NoError(t, store.SetInstanceInfo("x3000c0b0n1", cistore.OpenCHAMIInstanceInfo{
+		UserData: &cistore.CloudConfigFile{Content: []byte(debugUser), Encoding: "plain"},
+	}))
+	require.NoError(t, store.SetInstanceInfo("x3000c0b0n2", cistore.OpenCHAMIInstanceInfo{
+		UserData: &cistore.CloudConfigFile{
+			Content:  []byte(base64.StdEncoding.EncodeToString([]byte(debugUser))),
+			Encoding: "base64",
+		},
+	}))
+	require.NoError(t, store.SetInstanceInfo("x3000c0b0n3", cistore.OpenCHAMIInstanceInfo{
+		UserData: &cistore.CloudConfigFile{Content: []byte("not base64!"), Encoding: "base64"},
+	}))
+
+	router := chi.NewRouter()
+	router.Get("/user-data", UserDataHandler(sm, store))
+	router.Get("/admin/impersonation/{id}/user-data", UserDataHandler(sm, store))
+
+	tests := []struct {
+		name           string
+		path           string
+		remoteAddr     string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"plain user-data by ID", "/admin/impersonation/x3000c0b0n1/user-data", "", http.StatusOK, debugUser},
+		{"base64 user-data by ID", "/admin/impersonation/x3000c0b0n2/user-data", "", http.StatusOK, debugUser},
+		{"invalid base64 user-data", "/admin/impersonation/x3000c0b0n3/user-data", "", http.StatusInternalServerError, ""},
+		{"no user-data set", "/admin/impersonation/x3000c1b0n0/user-data", "", http.StatusOK, "#cloud-config"},
+		{"user-data by requesting IP", "/user-data", "10.20.30.1:4321", http.StatusOK, debugUser},
+		{"unknown requesting IP", "/user-data", "192.168.0.1:4321", http.StatusOK, "#cloud-config"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.remoteAddr != "" {
+				req.RemoteAddr = tt.remoteAddr
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			if tt.expectedBody != "" {
+				assert.Equal(t, tt.expectedBody, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestGroupUserDataHandler_PinnedRevision(t *testing.T) {
+	sm := smdclient.NewFakeSMDClient("test", 10)
+	store := memstore.NewMemStore()
+
+	for _, content := range []string{"#cloud-config\nrevision: 1\n", "#cloud-config\nrevision: 2\n", "#cloud-config\nrevision: 3\n"} {
+		require.NoError(t, store.UpdateGroupData("compute", cistore.GroupData{
+			Name:          "compute",
+			File:          cistore.CloudConfigFile{Content: []byte(content), Encoding: "plain"},
+			PinnedVersion: "1",
+		}, true))
+	}
+	// Canary nodes follow the latest cloud-config or a specific revision
+	require.NoError(t, store.SetInstanceInfo("x3000c0b0n1", cistore.OpenCHAMIInstanceInfo{
+		GroupVersions: map[string]string{"compute": "latest"},
+	}))
+	require.NoError(t, store.SetInstanceInfo("x3000c0b0n2", cistore.OpenCHAMIInstanceInfo{
+		GroupVersions: map[string]string{"compute": "2"},
+	}))
+	require.NoError(t, store.SetInstanceInfo("x3000c0b0n3", cistore.OpenCHAMIInstanceInfo{
+		GroupVersions: map[string]string{"compute": "7"},
+	}))
+
+	router := chi.NewRouter()
+	router.Get("/admin/impersonation/{id}/{group}.yaml", GroupUserDataHandler(sm, store, false))
+
+	tests := []struct {
+		name           string
+		id             string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"group pin", "x3000c0b1n0", http.StatusOK, "#cloud-config\nrevision: 1\n"},
+		{"node follows latest", "x3000c0b0n1", http.StatusOK, "#cloud-config\nrevision: 3\n"},
+		{"node pin", "x3000c0b0n2", http.StatusOK, "#cloud-config\nrevision: 2\n"},
+		{"node pinned to missing revision", "x3000c0b0n3", http.StatusInternalServerError, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/admin/impersonation/"+tt.id+"/compute.yaml", nil)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			if tt.expectedBody != "" {
+				assert.Equal(t, tt.expectedBody, rr.Body.String())
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"net/url"
 
 	"github.com/OpenCHAMI/cloud-init/internal/smdclient"
 	"github.com/OpenCHAMI/cloud-init/pkg/cistore"
@@ -75,9 +76,15 @@ func VendorDataHandler(smd smdclient.SMDClientInterface, store cistore.Store, ba
 			baseUrl = extendedInstanceData.CloudInitBaseURL
 		}
 
+		// The node fetches the included files without headers, so the node
+		// token it presented is passed on in their URLs
+		query := ""
+		if token := verifiedNodeToken(r); token != "" {
+			query = "?token=" + url.QueryEscape(token)
+		}
 		payload := "#include\n"
 		for _, group_name := range groups {
-			payload += fmt.Sprintf("%s/%s.yaml\n", baseUrl, group_name)
+			payload += fmt.Sprintf("%s/%s.yaml%s\n", baseUrl, group_name, query)
 		}
 		if _, err = w.Write([]byte(payload)); err != nil {
 			log.Error().Err(err).Msg("failed to write response")
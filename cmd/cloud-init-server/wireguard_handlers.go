@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/OpenCHAMI/cloud-init/internal/smdclient"
+	"github.com/OpenCHAMI/cloud-init/pkg/wgtunnel"
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// WireGuardPeerStatus describes a peer of the WireGuard server
+type WireGuardPeerStatus struct {
+	Name      string    `json:"name" yaml:"name" example:"10.20.30.1" description:"IP of the node that set up the tunnel"`
+	Xname     string    `json:"xname,omitempty" yaml:"xname,omitempty" example:"x3000c0b0n1" description:"ID of the node in SMD, if it is known"`
+	PublicKey string    `json:"public-key" yaml:"public-key" example:"9NS6+NR0J38SZ9IlY9hBDLs6aBpNDhxHUHL8OTlNEDU="`
+	VPNIP     string    `json:"vpn-ip" yaml:"vpn-ip" example:"100.97.0.2" description:"WireGuard VPN IP assigned to the node"`
+	Created   time.Time `json:"created" yaml:"created"`
+	// LastHandshake is omitted if the peer hasn't completed a handshake
+	LastHandshake *time.Time `json:"last-handshake,omitempty" yaml:"last-handshake,omitempty"`
+}
+
+// WireGuardServerStatus describes the WireGuard server and how much of its
+// tunnel network is in use
+type WireGuardServerStatus struct {
+	Config    wgtunnel.ServerConfig   `json:"config" yaml:"config"`
+	Allocator wgtunnel.AllocatorStats `json:"allocator" yaml:"allocator"`
+	Peers     int                     `json:"peers" yaml:"peers" example:"11"`
+}
+
+// wireGuardPeerStatus returns the status of a peer. The node of a peer that
+// was persisted without its node ID is looked up in SMD.
+func wireGuardPeerStatus(sm smdclient.SMDClientInterface, name string, peer wgtunnel.PeerConfig) WireGuardPeerStatus {
+	status := WireGuardPeerStatus{
+		Name:      name,
+		Xname:     peer.NodeID,
+		PublicKey: peer.PublicKey,
+		VPNIP:     peer.IP.IP.String(),
+		Created:   peer.CreatedAt,
+	}
+	if status.Xname == "" {
+		if id, err := sm.IDfromIP(name); err == nil {
+			status.Xname = id
+		}
+	}
+	if !peer.LastHandshake.IsZero() {
+		lastHandshake := peer.LastHandshake
+		status.LastHandshake = &lastHandshake
+	}
+	return status
+}
+
+// refreshHandshakes updates the peers' handshakes before they are returned.
+// The peers are still returned if the interface can't be read.
+func refreshHandshakes(wg *wgtunnel.InterfaceManager) {
+	if err := wg.RefreshHandshakes(); err != nil {
+		log.Warn().Err(err).Msg("Failed to refresh WireGuard handshakes")
+	}
+}
+
+// GetWireGuardPeersHandler godoc
+//
+//	@Summary		List the WireGuard peers
+//	@Description	List the peers of the WireGuard server, sorted by name, with
+//	@Description	their VPN IP, public key, node xname, and when their tunnel
+//	@Description	was set up and last completed a handshake.
+//	@Tags			admin,wireguard
+//	@Produce		json
+//	@Success		200	{object}	[]WireGuardPeerStatus
+//	@Failure		500	{object}	nil
+//	@Router			/admin/wireguard/peers [get]
+func GetWireGuardPeersHandler(wg *wgtunnel.InterfaceManager, sm smdclient.SMDClientInterface) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		refreshHandshakes(wg)
+		peers := wg.GetPeers()
+		statuses := make([]WireGuardPeerStatus, 0, len(peers))
+		for name, peer := range peers {
+			statuses = append(statuses, wireGuardPeerStatus(sm, name, peer))
+		}
+		sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+		writeJSONResponse(w, statuses)
+	}
+}
+
+// GetWireGuardPeerHandler godoc
+//
+//	@Summary		Get a WireGuard peer
+//	@Description	Get a peer of the WireGuard server by name, which is the IP
+//	@Description	of the node that set up the tunnel.
+//	@Tags			admin,wireguard
+//	@Produce		json
+//	@Param			name	path		string	true	"Peer name"
+//	@Success		200		{object}	WireGuardPeerStatus
+//	@Failure		404		{object}	nil
+//	@Failure		500		{object}	nil
+//	@Router			/admin/wireguard/peers/{name} [get]
+func GetWireGuardPeerHandler(wg *wgtunnel.InterfaceManager, sm smdclient.SMDClientInterface) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := chi.URLParam(r, "name")
+		refreshHandshakes(wg)
+		peer, ok := wg.GetPeer(name)
+		if !ok {
+			http.Error(w, "WireGuard peer "+name+" not found", http.StatusNotFound)
+			return
+		}
+		writeJSONResponse(w, wireGuardPeerStatus(sm, name, peer))
+	}
+}
+
+// RemoveWireGuardPeerHandler godoc
+//
+//	@Summary		Remove a WireGuard peer
+//	@Description	Tear down the tunnel of a peer, as if its node had phoned
+//	@Description	home: the peer is removed from the interface, its VPN IP is
+//	@Description	released, and the IP is unassigned from its node in the SMD
+//	@Description	cache. The node has to call `/wg-init` again to reconnect.
+//	@Tags			admin,wireguard
+//	@Param			name	path	string	true	"Peer name"
+//	@Success		204
+//	@Failure		404	{object}	nil
+//	@Failure		500	{object}	nil
+//	@Router			/admin/wireguard/peers/{name} [delete]
+func RemoveWireGuardPeerHandler(wg *wgtunnel.InterfaceManager, sm smdclient.SMDClientInterface) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := chi.URLParam(r, "name")
+		if err := wg.RemovePeer(sm, name); err != nil {
+			if errors.Is(err, wgtunnel.ErrPeerNotFound) {
+				http.Error(w, "WireGuard peer "+name+" not found", http.StatusNotFound)
+				return
+			}
+			log.Error().Err(err).Msgf("failed to remove WireGuard peer %s", name)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Info().Msgf("Removed WireGuard peer %s", name)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// GetWireGuardServerHandler godoc
+//
+//	@Summary		Get the WireGuard server
+//	@Description	Get the WireGuard server's public key, IP, and listen port,
+//	@Description	the number of peers, and how many IPs of the tunnel network
+//	@Description	are allocated and still available.
+//	@Tags			admin,wireguard
+//	@Produce		json
+//	@Success		200	{object}	WireGuardServerStatus
+//	@Failure		500	{object}	nil
+//	@Router			/admin/wireguard/server [get]
+func GetWireGuardServerHandler(wg *wgtunnel.InterfaceManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		config, err := wg.GetServerConfig()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSONResponse(w, WireGuardServerStatus{
+			Config:    config,
+			Allocator: wg.AllocatorStats(),
+			Peers:     len(wg.GetPeers()),
+		})
+	}
+}
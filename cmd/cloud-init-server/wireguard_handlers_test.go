@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/OpenCHAMI/cloud-init/internal/memstore"
+	"github.com/OpenCHAMI/cloud-init/internal/smdclient"
+	"github.com/OpenCHAMI/cloud-init/pkg/cistore"
+	"github.com/OpenCHAMI/cloud-init/pkg/wgtunnel"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWireGuardHandlers(t *testing.T) {
+	store := memstore.NewMemStore()
+	sm := smdclient.NewFakeSMDClient("test", 10)
+	backend := wgtunnel.NewFakeBackend()
+	wgIp, wgNet, _ := net.ParseCIDR("100.97.0.1/16")
+	wg, err := wgtunnel.NewInterfaceManager("wg0", wgIp, wgNet, store, backend)
+	require.NoError(t, err)
+	require.NoError(t, wg.StartServer())
+
+	// Tunnels for two nodes, and one for an IP SMD doesn't know
+	keys := map[string]string{
+		"10.20.30.1": "9NS6+NR0J38SZ9IlY9hBDLs6aBpNDhxHUHL8OTlNEDU=",
+		"10.20.30.2": "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=",
+		"172.16.0.9": "TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0=",
+	}
+	for _, name := range []string{"10.20.30.1", "10.20.30.2", "172.16.0.9"} {
+		vpnIP := wg.IpForPeer(name, keys[name])
+		id, err := sm.IDfromIP(name)
+		if err == nil {
+			require.NoError(t, sm.AddWGIP(id, vpnIP))
+		}
+		require.NoError(t, wg.AddPeer(name, keys[name], vpnIP, name, id))
+	}
+	handshake := time.Date(2024, 7, 19, 12, 0, 0, 0, time.UTC)
+	backend.SetHandshake("wg0", keys["10.20.30.1"], handshake)
+
+	router := chi.NewRouter()
+	initCiAdminRouter(router, &CiHandler{sm: sm, store: store}, wg, nil)
+	do := func(method, path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(method, path, nil))
+		return rr
+	}
+
+	rr := do(http.MethodGet, "/admin/wireguard/peers")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var peers []WireGuardPeerStatus
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &peers))
+	require.Len(t, peers, 3)
+	assert.Equal(t, "10.20.30.1", peers[0].Name)
+	assert.Equal(t, "x3000c0b0n1", peers[0].Xname)
+	assert.Equal(t, keys["10.20.30.1"], peers[0].PublicKey)
+	assert.Equal(t, "100.97.0.2", peers[0].VPNIP)
+	assert.False(t, peers[0].Created.IsZero())
+	require.NotNil(t, peers[0].LastHandshake)
+	assert.True(t, peers[0].LastHandshake.Equal(handshake))
+	assert.Nil(t, peers[1].LastHandshake)
+	assert.Equal(t, "172.16.0.9", peers[2].Name)
+	assert.Empty(t, peers[2].Xname)
+
+	rr = do(http.MethodGet, "/admin/wireguard/peers/10.20.30.2")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var peer WireGuardPeerStatus
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &peer))
+	assert.Equal(t, "x3000c0b0n2", peer.Xname)
+	assert.Equal(t, "100.97.0.3", peer.VPNIP)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/admin/wireguard/peers/10.20.30.9").Code)
+
+	rr = do(http.MethodGet, "/admin/wireguard/server")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var server WireGuardServerStatus
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &server))
+	publicKey, _ := wg.PublicKey()
+	assert.Equal(t, publicKey, server.Config.PublicKey)
+	assert.Equal(t, 3, server.Peers)
+	assert.Equal(t, "100.97.0.0/16", server.Allocator.Network)
+	assert.Equal(t, uint64(65534), server.Allocator.Capacity)
+	// The server's IP and one per peer
+	assert.Equal(t, 4, server.Allocator.Allocated)
+	assert.Equal(t, uint64(65530), server.Allocator.Available)
+
+	// Removing a peer tears down its tunnel and is audited
+	assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/admin/wireguard/peers/10.20.30.2").Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodDelete, "/admin/wireguard/peers/10.20.30.2").Code)
+	_, ok := wg.GetPeer("10.20.30.2")
+	assert.False(t, ok)
+	wgip, _ := sm.WGIPfromID("x3000c0b0n2")
+	assert.Empty(t, wgip)
+	assert.Equal(t, 3, wg.AllocatorStats().Allocated)
+
+	entries, err := store.GetAuditEntries(cistore.AuditFilter{Entity: cistore.EntityWireGuardPeer})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	removed := entries[1]
+	assert.Equal(t, "10.20.30.2", removed.Name)
+	assert.Equal(t, http.StatusNoContent, removed.Status)
+	assert.NotNil(t, removed.Before)
+	assert.Nil(t, removed.After)
+}
+
+func TestWireGuardHandlers_Disabled(t *testing.T) {
+	router := chi.NewRouter()
+	initCiAdminRouter(router, &CiHandler{sm: smdclient.NewFakeSMDClient("test", 10), store: memstore.NewMemStore()}, nil, nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/admin/wireguard/server", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
@@ -26,7 +26,7 @@ func NewDuckDBStore(dsn string) (*DuckDBStore, error) {
 func (d *DuckDBStore) GetGroups() (map[string]cistore.GroupData, error) {
 	d.mu.RLock()
 	defer d.mu.RUnlock()
-	rows, err := d.db.Query("SELECT name, description, data, file, versions FROM groups")
+	rows, err := d.db.Query("SELECT name, description, data, file, versions, network_config, pinned_version FROM groups")
 	if err != nil {
 		return nil, err
 	}
@@ -37,8 +37,8 @@ func (d *DuckDBStore) GetGroups() (map[string]cistore.GroupData, error) {
 	groups := make(map[string]cistore.GroupData)
 	for rows.Next() {
 		var group cistore.GroupData
-		var data, file, versions []byte
-		if err := rows.Scan(&group.Name, &group.Description, &data, &file, &versions); err != nil {
+		var data, file, versions, networkConfig []byte
+		if err := rows.Scan(&group.Name, &group.Description, &data, &file, &versions, &networkConfig, &group.PinnedVersion); err != nil {
 			continue
 		}
 		err := json.Unmarshal(data, &group.Data)
@@ -49,6 +49,9 @@ func (d *DuckDBStore) GetGroups() (map[string]cistore.GroupData, error) {
 		if err = json.Unmarshal(versions, &group.Versions); err != nil {
 			return nil, err
 		}
+		if err = json.Unmarshal(networkConfig, &group.Network); err != nil {
+			return nil, err
+		}
 		groups[group.Name] = group
 	}
 	return groups, nil
@@ -57,10 +60,11 @@ func (d *DuckDBStore) GetGroups() (map[string]cistore.GroupData, error) {
 func (d *DuckDBStore) AddGroupData(groupName string, groupData cistore.GroupData) error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
-	data, _ := json.Marshal(groupData.Data)         // Ignoring error because Data is always serializable
-	versions, _ := json.Marshal(groupData.Versions) // Ignoring error because Versions is always serializable
-	_, err := d.db.Exec("INSERT INTO groups (name, description, data, file, versions) VALUES (?, ?, ?, ?, ?)",
-		groupName, groupData.Description, data, groupData.File.Content, versions)
+	data, _ := json.Marshal(groupData.Data)             // Ignoring error because Data is always serializable
+	versions, _ := json.Marshal(groupData.Versions)     // Ignoring error because Versions is always serializable
+	networkConfig, _ := json.Marshal(groupData.Network) // Ignoring error because Network is always serializable
+	_, err := d.db.Exec("INSERT INTO groups (name, description, data, file, versions, network_config, pinned_version) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		groupName, groupData.Description, data, groupData.File.Content, versions, networkConfig, groupData.PinnedVersion)
 	return err
 }
 
@@ -68,9 +72,9 @@ func (d *DuckDBStore) GetGroupData(groupName string) (cistore.GroupData, error)
 	d.mu.RLock()
 	defer d.mu.RUnlock()
 	var group cistore.GroupData
-	var data, file, versions []byte
-	err := d.db.QueryRow("SELECT name, description, data, file, versions FROM groups WHERE name = ?", groupName).
-		Scan(&group.Name, &group.Description, &data, &file, &versions)
+	var data, file, versions, networkConfig []byte
+	err := d.db.QueryRow("SELECT name, description, data, file, versions, network_config, pinned_version FROM groups WHERE name = ?", groupName).
+		Scan(&group.Name, &group.Description, &data, &file, &versions, &networkConfig, &group.PinnedVersion)
 	if err != nil {
 		return group, err
 	}
@@ -83,21 +87,26 @@ func (d *DuckDBStore) GetGroupData(groupName string) (cistore.GroupData, error)
 	if err != nil {
 		return group, err
 	}
+	err = json.Unmarshal(networkConfig, &group.Network)
+	if err != nil {
+		return group, err
+	}
 	return group, nil
 }
 
 func (d *DuckDBStore) UpdateGroupData(groupName string, groupData cistore.GroupData, create bool) error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
-	data, _ := json.Marshal(groupData.Data)         // Ignoring error because Data is always serializable
-	versions, _ := json.Marshal(groupData.Versions) // Ignoring error because Versions is always serializable
+	data, _ := json.Marshal(groupData.Data)             // Ignoring error because Data is always serializable
+	versions, _ := json.Marshal(groupData.Versions)     // Ignoring error because Versions is always serializable
+	networkConfig, _ := json.Marshal(groupData.Network) // Ignoring error because Network is always serializable
 	if create {
-		_, err := d.db.Exec("INSERT INTO groups (name, description, data, file, versions) VALUES (?, ?, ?, ?, ?)",
-			groupName, groupData.Description, data, groupData.File.Content, versions)
+		_, err := d.db.Exec("INSERT INTO groups (name, description, data, file, versions, network_config, pinned_version) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			groupName, groupData.Description, data, groupData.File.Content, versions, networkConfig, groupData.PinnedVersion)
 		return err
 	}
-	_, err := d.db.Exec("UPDATE groups SET description = ?, data = ?, file = ?, versions = ? WHERE name = ?",
-		groupData.Description, data, groupData.File.Content, versions, groupName)
+	_, err := d.db.Exec("UPDATE groups SET description = ?, data = ?, file = ?, versions = ?, network_config = ?, pinned_version = ? WHERE name = ?",
+		groupData.Description, data, groupData.File.Content, versions, networkConfig, groupData.PinnedVersion, groupName)
 	return err
 }
 
@@ -112,18 +121,32 @@ func (d *DuckDBStore) GetInstanceInfo(nodeName string) (cistore.OpenCHAMIInstanc
 	d.mu.RLock()
 	defer d.mu.RUnlock()
 	var instance cistore.OpenCHAMIInstanceInfo
-	err := d.db.QueryRow("SELECT id, instance_id, local_hostname, hostname, cluster_name, region, availability_zone, cloud_provider, instance_type, cloud_init_base_url, public_keys FROM instances WHERE id = ?", nodeName).
-		Scan(&instance.ID, &instance.InstanceID, &instance.LocalHostname, &instance.Hostname, &instance.ClusterName, &instance.Region, &instance.AvailabilityZone, &instance.CloudProvider, &instance.InstanceType, &instance.CloudInitBaseURL, &instance.PublicKeys)
+	var networkConfig, userData, groupVersions []byte
+	err := d.db.QueryRow("SELECT id, instance_id, local_hostname, hostname, cluster_name, region, availability_zone, cloud_provider, instance_type, cloud_init_base_url, public_keys, network_config, user_data, group_versions, boot_mac FROM instances WHERE id = ?", nodeName).
+		Scan(&instance.ID, &instance.InstanceID, &instance.LocalHostname, &instance.Hostname, &instance.ClusterName, &instance.Region, &instance.AvailabilityZone, &instance.CloudProvider, &instance.InstanceType, &instance.CloudInitBaseURL, &instance.PublicKeys, &networkConfig, &userData, &groupVersions, &instance.BootMAC)
+	if err != nil {
+		return instance, err
+	}
+	if err = json.Unmarshal(networkConfig, &instance.Network); err != nil {
+		return instance, err
+	}
+	if err = json.Unmarshal(userData, &instance.UserData); err != nil {
+		return instance, err
+	}
+	err = json.Unmarshal(groupVersions, &instance.GroupVersions)
 	return instance, err
 }
 
 func (d *DuckDBStore) SetInstanceInfo(nodeName string, instanceInfo cistore.OpenCHAMIInstanceInfo) error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
-	publicKeys, _ := json.Marshal(instanceInfo.PublicKeys) // Not checking error because PublicKeys is always serializable
-	_, err := d.db.Exec("INSERT INTO instances (id, instance_id, local_hostname, hostname, cluster_name, region, availability_zone, cloud_provider, instance_type, cloud_init_base_url, public_keys) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT(id) DO UPDATE SET instance_id = ?, local_hostname = ?, hostname = ?, cluster_name = ?, region = ?, availability_zone = ?, cloud_provider = ?, instance_type = ?, cloud_init_base_url = ?, public_keys = ?",
-		nodeName, instanceInfo.InstanceID, instanceInfo.LocalHostname, instanceInfo.Hostname, instanceInfo.ClusterName, instanceInfo.Region, instanceInfo.AvailabilityZone, instanceInfo.CloudProvider, instanceInfo.InstanceType, instanceInfo.CloudInitBaseURL, publicKeys,
-		instanceInfo.InstanceID, instanceInfo.LocalHostname, instanceInfo.Hostname, instanceInfo.ClusterName, instanceInfo.Region, instanceInfo.AvailabilityZone, instanceInfo.CloudProvider, instanceInfo.InstanceType, instanceInfo.CloudInitBaseURL, publicKeys)
+	publicKeys, _ := json.Marshal(instanceInfo.PublicKeys)       // Not checking error because PublicKeys is always serializable
+	networkConfig, _ := json.Marshal(instanceInfo.Network)       // Not checking error because Network is always serializable
+	userData, _ := json.Marshal(instanceInfo.UserData)           // Not checking error because UserData is always serializable
+	groupVersions, _ := json.Marshal(instanceInfo.GroupVersions) // Not checking error because GroupVersions is always serializable
+	_, err := d.db.Exec("INSERT INTO instances (id, instance_id, local_hostname, hostname, cluster_name, region, availability_zone, cloud_provider, instance_type, cloud_init_base_url, public_keys, network_config, user_data, group_versions, boot_mac) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT(id) DO UPDATE SET instance_id = ?, local_hostname = ?, hostname = ?, cluster_name = ?, region = ?, availability_zone = ?, cloud_provider = ?, instance_type = ?, cloud_init_base_url = ?, public_keys = ?, network_config = ?, user_data = ?, group_versions = ?, boot_mac = ?",
+		nodeName, instanceInfo.InstanceID, instanceInfo.LocalHostname, instanceInfo.Hostname, instanceInfo.ClusterName, instanceInfo.Region, instanceInfo.AvailabilityZone, instanceInfo.CloudProvider, instanceInfo.InstanceType, instanceInfo.CloudInitBaseURL, publicKeys, networkConfig, userData, groupVersions, instanceInfo.BootMAC,
+		instanceInfo.InstanceID, instanceInfo.LocalHostname, instanceInfo.Hostname, instanceInfo.ClusterName, instanceInfo.Region, instanceInfo.AvailabilityZone, instanceInfo.CloudProvider, instanceInfo.InstanceType, instanceInfo.CloudInitBaseURL, publicKeys, networkConfig, userData, groupVersions, instanceInfo.BootMAC)
 	return err
 }
 
@@ -138,8 +161,8 @@ func (d *DuckDBStore) GetClusterDefaults() (cistore.ClusterDefaults, error) {
 	d.mu.RLock()
 	defer d.mu.RUnlock()
 	var defaults cistore.ClusterDefaults
-	err := d.db.QueryRow("SELECT cloud_provider, region, availability_zone, cluster_name, public_keys, base_url, boot_subnet, wg_subnet, short_name, nid_length FROM cluster_defaults").
-		Scan(&defaults.CloudProvider, &defaults.Region, &defaults.AvailabilityZone, &defaults.ClusterName, &defaults.PublicKeys, &defaults.BaseUrl, &defaults.BootSubnet, &defaults.WGSubnet, &defaults.ShortName, &defaults.NidLength)
+	err := d.db.QueryRow("SELECT cloud_provider, region, availability_zone, cluster_name, public_keys, base_url, boot_subnet, wg_subnet, short_name, nid_length, boot_interface_description FROM cluster_defaults").
+		Scan(&defaults.CloudProvider, &defaults.Region, &defaults.AvailabilityZone, &defaults.ClusterName, &defaults.PublicKeys, &defaults.BaseUrl, &defaults.BootSubnet, &defaults.WGSubnet, &defaults.ShortName, &defaults.NidLength, &defaults.BootInterfaceDescription)
 	return defaults, err
 }
 
@@ -147,9 +170,9 @@ func (d *DuckDBStore) SetClusterDefaults(clusterDefaults cistore.ClusterDefaults
 	d.mu.Lock()
 	defer d.mu.Unlock()
 	publicKeys, _ := json.Marshal(clusterDefaults.PublicKeys) // Not checking error on Marshall because PublicKeys is always serializable
-	_, err := d.db.Exec("INSERT INTO cluster_defaults (cloud_provider, region, availability_zone, cluster_name, public_keys, base_url, boot_subnet, wg_subnet, short_name, nid_length) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT DO UPDATE SET cloud_provider = ?, region = ?, availability_zone = ?, cluster_name = ?, public_keys = ?, base_url = ?, boot_subnet = ?, wg_subnet = ?, short_name = ?, nid_length = ?",
-		clusterDefaults.CloudProvider, clusterDefaults.Region, clusterDefaults.AvailabilityZone, clusterDefaults.ClusterName, publicKeys, clusterDefaults.BaseUrl, clusterDefaults.BootSubnet, clusterDefaults.WGSubnet, clusterDefaults.ShortName, clusterDefaults.NidLength,
-		clusterDefaults.CloudProvider, clusterDefaults.Region, clusterDefaults.AvailabilityZone, clusterDefaults.ClusterName, publicKeys, clusterDefaults.BaseUrl, clusterDefaults.BootSubnet, clusterDefaults.WGSubnet, clusterDefaults.ShortName, clusterDefaults.NidLength)
+	_, err := d.db.Exec("INSERT INTO cluster_defaults (cloud_provider, region, availability_zone, cluster_name, public_keys, base_url, boot_subnet, wg_subnet, short_name, nid_length, boot_interface_description) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT DO UPDATE SET cloud_provider = ?, region = ?, availability_zone = ?, cluster_name = ?, public_keys = ?, base_url = ?, boot_subnet = ?, wg_subnet = ?, short_name = ?, nid_length = ?, boot_interface_description = ?",
+		clusterDefaults.CloudProvider, clusterDefaults.Region, clusterDefaults.AvailabilityZone, clusterDefaults.ClusterName, publicKeys, clusterDefaults.BaseUrl, clusterDefaults.BootSubnet, clusterDefaults.WGSubnet, clusterDefaults.ShortName, clusterDefaults.NidLength, clusterDefaults.BootInterfaceDescription,
+		clusterDefaults.CloudProvider, clusterDefaults.Region, clusterDefaults.AvailabilityZone, clusterDefaults.ClusterName, publicKeys, clusterDefaults.BaseUrl, clusterDefaults.BootSubnet, clusterDefaults.WGSubnet, clusterDefaults.ShortName, clusterDefaults.NidLength, clusterDefaults.BootInterfaceDescription)
 	return err
 }
 
@@ -1,10 +1,12 @@
 package memstore
 
 import (
+	"context"
 	"crypto/rand"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 
@@ -16,21 +18,34 @@ import (
 
 type MemStore struct {
 	Groups               map[string]cistore.GroupData `json:"groups,omitempty" yaml:"groups,omitempty"`
+	GroupRevisions       map[string][]cistore.GroupRevision
 	GroupsMutex          sync.RWMutex
 	Instances            map[string]cistore.OpenCHAMIInstanceInfo
 	InstancesMutex       sync.RWMutex
 	ClusterDefaults      cistore.ClusterDefaults
 	ClusterDefaultsMutex sync.RWMutex
+	AuditLog             []cistore.AuditEntry
+	AuditMutex           sync.RWMutex
+	WireGuardServer      cistore.WireGuardServer
+	WireGuardPeers       map[string]cistore.WireGuardPeer
+	WireGuardMutex       sync.RWMutex
+	notifier             cistore.Notifier
 }
 
 func NewMemStore() *MemStore {
 	return &MemStore{
 		Groups:               make(map[string]cistore.GroupData),
+		GroupRevisions:       make(map[string][]cistore.GroupRevision),
 		GroupsMutex:          sync.RWMutex{},
 		Instances:            make(map[string]cistore.OpenCHAMIInstanceInfo),
 		InstancesMutex:       sync.RWMutex{},
 		ClusterDefaults:      cistore.ClusterDefaults{},
 		ClusterDefaultsMutex: sync.RWMutex{},
+		AuditLog:             make([]cistore.AuditEntry, 0),
+		AuditMutex:           sync.RWMutex{},
+		WireGuardServer:      cistore.WireGuardServer{},
+		WireGuardPeers:       make(map[string]cistore.WireGuardPeer),
+		WireGuardMutex:       sync.RWMutex{},
 	}
 }
 
@@ -94,8 +109,9 @@ func (m *MemStore) AddGroupData(groupName string, newGroupData cistore.GroupData
 		return fmt.Errorf("group '%s' not added as it already exists", groupName)
 	} else {
 		// does not exist, so create and update
+		newGroupData.Versions = nil
 		m.Groups[groupName] = newGroupData
-
+		m.notifier.Publish(cistore.EntityGroup, groupName, cistore.OperationCreate, nil, newGroupData)
 	}
 	return nil
 }
@@ -113,20 +129,28 @@ func (m *MemStore) GetGroupData(groupName string) (cistore.GroupData, error) {
 
 }
 
-// UpdateGroupData is similar to AddGroupData but only works if the group exists
+// UpdateGroupData is similar to AddGroupData but only works if the group exists.
+// The previous meta-data and cloud-config of an existing group are kept as a
+// new revision.
 func (m *MemStore) UpdateGroupData(groupName string, groupData cistore.GroupData, create bool) error {
 	m.GroupsMutex.Lock()
 	defer m.GroupsMutex.Unlock()
-	if create {
-		m.Groups[groupName] = groupData
-		return nil
-	}
 
-	_, ok := m.Groups[groupName]
+	previous, ok := m.Groups[groupName]
+	if !ok && !create {
+		return fmt.Errorf("group (%s) not found", groupName)
+	}
+	groupData.Versions = nil
+	if ok {
+		var revision cistore.GroupRevision
+		revision, groupData.Versions = cistore.ArchiveGroupData(previous, len(m.GroupRevisions[groupName])+1)
+		m.GroupRevisions[groupName] = append(m.GroupRevisions[groupName], revision)
+	}
+	m.Groups[groupName] = groupData
 	if ok {
-		m.Groups[groupName] = groupData
+		m.notifier.Publish(cistore.EntityGroup, groupName, cistore.OperationUpdate, previous, groupData)
 	} else {
-		return fmt.Errorf("group (%s) not found", groupName)
+		m.notifier.Publish(cistore.EntityGroup, groupName, cistore.OperationCreate, nil, groupData)
 	}
 	return nil
 }
@@ -134,10 +158,36 @@ func (m *MemStore) UpdateGroupData(groupName string, groupData cistore.GroupData
 func (m *MemStore) RemoveGroupData(name string) error {
 	m.GroupsMutex.Lock()
 	defer m.GroupsMutex.Unlock()
+	previous, ok := m.Groups[name]
 	delete(m.Groups, name)
+	delete(m.GroupRevisions, name)
+	if ok {
+		m.notifier.Publish(cistore.EntityGroup, name, cistore.OperationDelete, previous, nil)
+	}
 	return nil
 }
 
+// GetGroupVersions returns the previous revisions of a group, oldest first
+func (m *MemStore) GetGroupVersions(groupName string) ([]cistore.GroupRevision, error) {
+	m.GroupsMutex.RLock()
+	defer m.GroupsMutex.RUnlock()
+	if _, ok := m.Groups[groupName]; !ok {
+		return nil, fmt.Errorf("group (%s) not found in memstore", groupName)
+	}
+	return append([]cistore.GroupRevision{}, m.GroupRevisions[groupName]...), nil
+}
+
+// GetGroupVersion returns a single previous revision of a group
+func (m *MemStore) GetGroupVersion(groupName string, revision int) (cistore.GroupRevision, error) {
+	m.GroupsMutex.RLock()
+	defer m.GroupsMutex.RUnlock()
+	revisions := m.GroupRevisions[groupName]
+	if revision < 1 || revision > len(revisions) {
+		return cistore.GroupRevision{}, fmt.Errorf("revision %d of group (%s) not found", revision, groupName)
+	}
+	return revisions[revision-1], nil
+}
+
 func (m *MemStore) GetInstanceInfo(nodeName string) (cistore.OpenCHAMIInstanceInfo, error) {
 	m.InstancesMutex.Lock()
 	defer m.InstancesMutex.Unlock()
@@ -152,16 +202,18 @@ func (m *MemStore) GetInstanceInfo(nodeName string) (cistore.OpenCHAMIInstanceIn
 func (m *MemStore) SetInstanceInfo(nodeName string, instanceInfo cistore.OpenCHAMIInstanceInfo) error {
 	m.InstancesMutex.Lock()
 	defer m.InstancesMutex.Unlock()
-	if _, ok := m.Instances[nodeName]; !ok {
+	if previous, ok := m.Instances[nodeName]; !ok {
 		// This is a creation operation
 		if instanceInfo.InstanceID == "" {
 			instanceInfo.InstanceID = generateInstanceId()
 		}
 		m.Instances[nodeName] = instanceInfo
+		m.notifier.Publish(cistore.EntityInstance, nodeName, cistore.OperationCreate, nil, instanceInfo)
 	} else {
 		// This is an update operation.  We need to keep the instance ID the same.
-		instanceInfo.InstanceID = m.Instances[nodeName].InstanceID
+		instanceInfo.InstanceID = previous.InstanceID
 		m.Instances[nodeName] = instanceInfo
+		m.notifier.Publish(cistore.EntityInstance, nodeName, cistore.OperationUpdate, previous, instanceInfo)
 	}
 	return nil
 }
@@ -169,7 +221,11 @@ func (m *MemStore) SetInstanceInfo(nodeName string, instanceInfo cistore.OpenCHA
 func (m *MemStore) DeleteInstanceInfo(nodeName string) error {
 	m.InstancesMutex.Lock()
 	defer m.InstancesMutex.Unlock()
+	previous, ok := m.Instances[nodeName]
 	delete(m.Instances, nodeName)
+	if ok {
+		m.notifier.Publish(cistore.EntityInstance, nodeName, cistore.OperationDelete, previous, nil)
+	}
 	return nil
 }
 
@@ -215,7 +271,103 @@ func (m *MemStore) SetClusterDefaults(clusterDefaults cistore.ClusterDefaults) e
 		log.Debug().Msgf("Setting Public Keys to %v", clusterDefaults.PublicKeys)
 		cd.PublicKeys = clusterDefaults.PublicKeys
 	}
+	if clusterDefaults.BootSubnet != "" {
+		log.Debug().Msgf("Setting Boot Subnet to %s", clusterDefaults.BootSubnet)
+		cd.BootSubnet = clusterDefaults.BootSubnet
+	}
+	if clusterDefaults.WGSubnet != "" {
+		log.Debug().Msgf("Setting WireGuard Subnet to %s", clusterDefaults.WGSubnet)
+		cd.WGSubnet = clusterDefaults.WGSubnet
+	}
+	if clusterDefaults.BootInterfaceDescription != "" {
+		log.Debug().Msgf("Setting Boot Interface Description to %s", clusterDefaults.BootInterfaceDescription)
+		cd.BootInterfaceDescription = clusterDefaults.BootInterfaceDescription
+	}
+	previous := m.ClusterDefaults
 	m.ClusterDefaults = cd
+	m.notifier.Publish(cistore.EntityClusterDefaults, "", cistore.OperationUpdate, previous, cd)
+	return nil
+}
+
+// AddAuditEntry appends an entry to the audit trail, which is kept in memory
+// only
+func (m *MemStore) AddAuditEntry(entry cistore.AuditEntry) error {
+	m.AuditMutex.Lock()
+	defer m.AuditMutex.Unlock()
+	entry.ID = int64(len(m.AuditLog) + 1)
+	m.AuditLog = append(m.AuditLog, entry)
+	return nil
+}
+
+// GetAuditEntries returns the audit entries selected by filter, newest first
+func (m *MemStore) GetAuditEntries(filter cistore.AuditFilter) ([]cistore.AuditEntry, error) {
+	m.AuditMutex.RLock()
+	defer m.AuditMutex.RUnlock()
+	entries := make([]cistore.AuditEntry, 0)
+	for i := len(m.AuditLog) - 1; i >= 0; i-- {
+		if filter.Limit > 0 && len(entries) >= filter.Limit {
+			break
+		}
+		if filter.Matches(m.AuditLog[i]) {
+			entries = append(entries, m.AuditLog[i])
+		}
+	}
+	return entries, nil
+}
+
+// GetWireGuardServer returns the WireGuard server state, which is empty if it
+// hasn't been set
+func (m *MemStore) GetWireGuardServer() (cistore.WireGuardServer, error) {
+	m.WireGuardMutex.RLock()
+	defer m.WireGuardMutex.RUnlock()
+	return m.WireGuardServer, nil
+}
+
+func (m *MemStore) SetWireGuardServer(server cistore.WireGuardServer) error {
+	m.WireGuardMutex.Lock()
+	defer m.WireGuardMutex.Unlock()
+	m.WireGuardServer = server
+	return nil
+}
+
+// GetWireGuardPeers returns the WireGuard peers, sorted by name
+func (m *MemStore) GetWireGuardPeers() ([]cistore.WireGuardPeer, error) {
+	m.WireGuardMutex.RLock()
+	defer m.WireGuardMutex.RUnlock()
+	peers := make([]cistore.WireGuardPeer, 0, len(m.WireGuardPeers))
+	for _, peer := range m.WireGuardPeers {
+		peers = append(peers, peer)
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].Name < peers[j].Name })
+	return peers, nil
+}
+
+// SetWireGuardPeer adds a WireGuard peer or replaces the one with the same name
+func (m *MemStore) SetWireGuardPeer(peer cistore.WireGuardPeer) error {
+	m.WireGuardMutex.Lock()
+	defer m.WireGuardMutex.Unlock()
+	m.WireGuardPeers[peer.Name] = peer
+	return nil
+}
+
+// DeleteWireGuardPeer removes a WireGuard peer. Removing a peer that doesn't
+// exist is not an error.
+func (m *MemStore) DeleteWireGuardPeer(name string) error {
+	m.WireGuardMutex.Lock()
+	defer m.WireGuardMutex.Unlock()
+	delete(m.WireGuardPeers, name)
+	return nil
+}
+
+// Subscribe returns a channel receiving every change made to the store until
+// ctx is done. Instance info created implicitly by GetInstanceInfo is not
+// reported.
+func (m *MemStore) Subscribe(ctx context.Context) <-chan cistore.ChangeEvent {
+	return m.notifier.Subscribe(ctx)
+}
+
+// Ping always succeeds, as the store is held in memory
+func (m *MemStore) Ping() error {
 	return nil
 }
 
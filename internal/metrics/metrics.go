@@ -0,0 +1,164 @@
+// Package metrics defines the Prometheus metrics exported by cloud-init-server
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "cloud_init"
+
+var (
+	// HTTP requests, labelled by route pattern rather than path so that per-node
+	// routes don't create a series per node
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests handled, by route, method, and status code.",
+	}, []string{"route", "method", "code"})
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time taken to handle HTTP requests, by route and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	// UnknownIPResponses counts requests rejected because the requesting IP
+	// doesn't belong to any node known to SMD
+	UnknownIPResponses = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "unknown_ip_responses_total",
+		Help:      "Requests rejected with 422 because the requesting IP is not known to SMD.",
+	})
+
+	// NodeTokenRejections counts node requests rejected because their node
+	// token was missing, invalid, or issued to another node
+	NodeTokenRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "node_token_rejections_total",
+		Help:      "Node requests rejected because of their node token, by reason (missing, invalid, or mismatch).",
+	}, []string{"reason"})
+
+	// SMDRequestDuration tracks requests to SMD by endpoint and outcome
+	SMDRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "smd",
+		Name:      "request_duration_seconds",
+		Help:      "Time taken by requests to SMD, by endpoint and outcome (success or error).",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"endpoint", "outcome"})
+	// SMDComponentRetries counts retried component lookups
+	SMDComponentRetries = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "smd",
+		Name:      "component_retries_total",
+		Help:      "Component lookups retried after a transient SMD error.",
+	})
+	// SMDCacheMissLookups counts direct SMD lookups of IPs missing from the
+	// cache, by result
+	SMDCacheMissLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "smd",
+		Name:      "cache_miss_lookups_total",
+		Help:      "Lookups of IPs missing from the SMD cache, by result (found, unknown, error, or negative-cached).",
+	}, []string{"result"})
+	// SMDEvents counts SMD inventory change events applied to the cache
+	SMDEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "smd",
+		Name:      "events_total",
+		Help:      "SMD inventory change events applied to the SMD cache, by type and action.",
+	}, []string{"type", "action"})
+	// SMDCacheNodes is the number of nodes in the SMD cache
+	SMDCacheNodes = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "smd",
+		Name:      "cache_nodes",
+		Help:      "Number of nodes in the SMD cache.",
+	})
+	// SMDCacheLastUpdate is when the SMD cache was last populated successfully
+	SMDCacheLastUpdate = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "smd",
+		Name:      "cache_last_update_timestamp_seconds",
+		Help:      "Unix time at which the SMD cache was last populated successfully.",
+	})
+	// SMDCachePopulateDuration tracks how long populating the SMD cache takes
+	SMDCachePopulateDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "smd",
+		Name:      "cache_populate_duration_seconds",
+		Help:      "Time taken to populate the SMD cache, by outcome (success or error).",
+		Buckets:   []float64{.1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, []string{"outcome"})
+
+	// WireGuardPeersReaped counts WireGuard peers removed by the reaper
+	WireGuardPeersReaped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "wireguard",
+		Name:      "peers_reaped_total",
+		Help:      "WireGuard peers removed by the reaper, by reason (ttl or idle).",
+	}, []string{"reason"})
+
+	// storeOperationDuration tracks storage backend operations
+	storeOperationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "store",
+		Name:      "operation_duration_seconds",
+		Help:      "Time taken by storage backend operations, by operation and outcome (success or error).",
+		Buckets:   []float64{.0001, .0005, .001, .005, .01, .05, .1, .5, 1},
+	}, []string{"operation", "outcome"})
+)
+
+// Outcome returns the outcome label for an operation that returned err
+func Outcome(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Middleware records the count and duration of requests by chi route pattern
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		httpRequests.WithLabelValues(route, r.Method, strconv.Itoa(status)).Inc()
+		httpRequestDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+	})
+}
+
+// RegisterWireGuardPeers exports the number of active WireGuard peers, as
+// counted by peers at scrape time
+func RegisterWireGuardPeers(peers func() int) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "wireguard",
+		Name:      "peers",
+		Help:      "Number of active WireGuard peers.",
+	}, func() float64 {
+		return float64(peers())
+	})
+}
@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/OpenCHAMI/cloud-init/internal/memstore"
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	storetesting "github.com/OpenCHAMI/cloud-init/pkg/cistore/testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	router := chi.NewRouter()
+	router.Use(Middleware)
+	router.Get("/{group}.yaml", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	router.Get("/metrics", Handler().ServeHTTP)
+
+	for _, path := range []string{"/compute.yaml", "/io.yaml", "/unknown/path"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	// Requests are labelled by route pattern, not path
+	assert.Equal(t, 2.0, testutil.ToFloat64(httpRequests.WithLabelValues("/{group}.yaml", http.MethodGet, "404")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(httpRequests.WithLabelValues("unmatched", http.MethodGet, "404")))
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `cloud_init_http_requests_total{code="404",method="GET",route="/{group}.yaml"} 2`)
+}
+
+func TestOutcome(t *testing.T) {
+	assert.Equal(t, "success", Outcome(nil))
+	assert.Equal(t, "error", Outcome(errors.New("failed")))
+}
+
+func TestInstrumentStore(t *testing.T) {
+	// The wrapped store must behave exactly like the store it wraps
+	store := InstrumentStore(memstore.NewMemStore())
+	storetesting.RunStoreTests(t, store, nil)
+
+	_, err := store.GetGroupData("missing")
+	assert.Error(t, err)
+
+	rr := httptest.NewRecorder()
+	Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Contains(t, rr.Body.String(), `cloud_init_store_operation_duration_seconds_count{operation="GetGroupData",outcome="error"}`)
+	assert.Contains(t, rr.Body.String(), `cloud_init_store_operation_duration_seconds_count{operation="AddGroupData",outcome="success"}`)
+}
@@ -0,0 +1,128 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/OpenCHAMI/cloud-init/pkg/cistore"
+)
+
+// instrumentedStore records the latency of every operation on a cistore.Store
+type instrumentedStore struct {
+	store cistore.Store
+}
+
+// InstrumentStore wraps store so that the duration and outcome of each
+// operation are recorded
+func InstrumentStore(store cistore.Store) cistore.Store {
+	return &instrumentedStore{store: store}
+}
+
+// observe records an operation that started at start and returned err
+func observe(operation string, start time.Time, err error) {
+	storeOperationDuration.WithLabelValues(operation, Outcome(err)).Observe(time.Since(start).Seconds())
+}
+
+func (s *instrumentedStore) GetGroups() map[string]cistore.GroupData {
+	defer observe("GetGroups", time.Now(), nil)
+	return s.store.GetGroups()
+}
+
+func (s *instrumentedStore) AddGroupData(groupName string, groupData cistore.GroupData) (err error) {
+	defer func(start time.Time) { observe("AddGroupData", start, err) }(time.Now())
+	return s.store.AddGroupData(groupName, groupData)
+}
+
+func (s *instrumentedStore) GetGroupData(groupName string) (data cistore.GroupData, err error) {
+	defer func(start time.Time) { observe("GetGroupData", start, err) }(time.Now())
+	return s.store.GetGroupData(groupName)
+}
+
+func (s *instrumentedStore) UpdateGroupData(groupName string, groupData cistore.GroupData, create bool) (err error) {
+	defer func(start time.Time) { observe("UpdateGroupData", start, err) }(time.Now())
+	return s.store.UpdateGroupData(groupName, groupData, create)
+}
+
+func (s *instrumentedStore) RemoveGroupData(groupName string) (err error) {
+	defer func(start time.Time) { observe("RemoveGroupData", start, err) }(time.Now())
+	return s.store.RemoveGroupData(groupName)
+}
+
+func (s *instrumentedStore) GetGroupVersions(groupName string) (revisions []cistore.GroupRevision, err error) {
+	defer func(start time.Time) { observe("GetGroupVersions", start, err) }(time.Now())
+	return s.store.GetGroupVersions(groupName)
+}
+
+func (s *instrumentedStore) GetGroupVersion(groupName string, revision int) (rev cistore.GroupRevision, err error) {
+	defer func(start time.Time) { observe("GetGroupVersion", start, err) }(time.Now())
+	return s.store.GetGroupVersion(groupName, revision)
+}
+
+func (s *instrumentedStore) GetInstanceInfo(nodeName string) (info cistore.OpenCHAMIInstanceInfo, err error) {
+	defer func(start time.Time) { observe("GetInstanceInfo", start, err) }(time.Now())
+	return s.store.GetInstanceInfo(nodeName)
+}
+
+func (s *instrumentedStore) SetInstanceInfo(nodeName string, instanceInfo cistore.OpenCHAMIInstanceInfo) (err error) {
+	defer func(start time.Time) { observe("SetInstanceInfo", start, err) }(time.Now())
+	return s.store.SetInstanceInfo(nodeName, instanceInfo)
+}
+
+func (s *instrumentedStore) DeleteInstanceInfo(nodeName string) (err error) {
+	defer func(start time.Time) { observe("DeleteInstanceInfo", start, err) }(time.Now())
+	return s.store.DeleteInstanceInfo(nodeName)
+}
+
+func (s *instrumentedStore) GetClusterDefaults() (defaults cistore.ClusterDefaults, err error) {
+	defer func(start time.Time) { observe("GetClusterDefaults", start, err) }(time.Now())
+	return s.store.GetClusterDefaults()
+}
+
+func (s *instrumentedStore) SetClusterDefaults(clusterDefaults cistore.ClusterDefaults) (err error) {
+	defer func(start time.Time) { observe("SetClusterDefaults", start, err) }(time.Now())
+	return s.store.SetClusterDefaults(clusterDefaults)
+}
+
+func (s *instrumentedStore) AddAuditEntry(entry cistore.AuditEntry) (err error) {
+	defer func(start time.Time) { observe("AddAuditEntry", start, err) }(time.Now())
+	return s.store.AddAuditEntry(entry)
+}
+
+func (s *instrumentedStore) GetAuditEntries(filter cistore.AuditFilter) (entries []cistore.AuditEntry, err error) {
+	defer func(start time.Time) { observe("GetAuditEntries", start, err) }(time.Now())
+	return s.store.GetAuditEntries(filter)
+}
+
+func (s *instrumentedStore) GetWireGuardServer() (server cistore.WireGuardServer, err error) {
+	defer func(start time.Time) { observe("GetWireGuardServer", start, err) }(time.Now())
+	return s.store.GetWireGuardServer()
+}
+
+func (s *instrumentedStore) SetWireGuardServer(server cistore.WireGuardServer) (err error) {
+	defer func(start time.Time) { observe("SetWireGuardServer", start, err) }(time.Now())
+	return s.store.SetWireGuardServer(server)
+}
+
+func (s *instrumentedStore) GetWireGuardPeers() (peers []cistore.WireGuardPeer, err error) {
+	defer func(start time.Time) { observe("GetWireGuardPeers", start, err) }(time.Now())
+	return s.store.GetWireGuardPeers()
+}
+
+func (s *instrumentedStore) SetWireGuardPeer(peer cistore.WireGuardPeer) (err error) {
+	defer func(start time.Time) { observe("SetWireGuardPeer", start, err) }(time.Now())
+	return s.store.SetWireGuardPeer(peer)
+}
+
+func (s *instrumentedStore) DeleteWireGuardPeer(name string) (err error) {
+	defer func(start time.Time) { observe("DeleteWireGuardPeer", start, err) }(time.Now())
+	return s.store.DeleteWireGuardPeer(name)
+}
+
+func (s *instrumentedStore) Subscribe(ctx context.Context) <-chan cistore.ChangeEvent {
+	return s.store.Subscribe(ctx)
+}
+
+func (s *instrumentedStore) Ping() (err error) {
+	defer func(start time.Time) { observe("Ping", start, err) }(time.Now())
+	return s.store.Ping()
+}
@@ -0,0 +1,207 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type clientIPContextKey struct{}
+
+// ClientIPResolver finds the IP address of the client that made a request.
+// Forwarding headers are only honoured from trusted proxies, since any other
+// host could use them to claim another node's IP.
+type ClientIPResolver struct {
+	trustedProxies []*net.IPNet
+}
+
+// NewClientIPResolver creates a resolver that trusts the forwarding headers of
+// proxies in a comma-separated list of CIDRs or IP addresses, e.g.
+// 10.0.0.0/8,fd00::1. An empty list trusts no proxies.
+func NewClientIPResolver(trustedProxies string) (*ClientIPResolver, error) {
+	c := &ClientIPResolver{}
+	for _, proxy := range strings.Split(trustedProxies, ",") {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", proxy)
+			}
+			bits := 8 * len(ip)
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			c.trustedProxies = append(c.trustedProxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+		}
+		c.trustedProxies = append(c.trustedProxies, network)
+	}
+	return c, nil
+}
+
+// trusted reports whether ip belongs to a trusted proxy
+func (c *ClientIPResolver) trusted(ip net.IP) bool {
+	for _, network := range c.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the IP address of the client that made a request.
+//
+// If the request came from a trusted proxy, the X-Forwarded-For header, or if
+// that is absent the RFC 7239 Forwarded header, is read from right to left.
+// Each trusted proxy in the chain vouches for the address to its left, so the
+// first address that isn't a trusted proxy is the client. Entries left of it
+// were supplied by the client and are ignored. If the chain contains an entry
+// that isn't an IP address, such as "unknown", the nearest proxy is used.
+//
+// Otherwise, the request's remote address is used. It is returned as-is if it
+// isn't an IP address.
+func (c *ClientIPResolver) ClientIP(r *http.Request) string {
+	host := remoteHost(r.RemoteAddr)
+	peer := net.ParseIP(host)
+	if peer == nil || !c.trusted(peer) {
+		return host
+	}
+
+	chain := forwardedForChain(r.Header.Values("X-Forwarded-For"))
+	if len(chain) == 0 {
+		chain = forwardedChain(r.Header.Values("Forwarded"))
+	}
+	client := peer
+	for i := len(chain) - 1; i >= 0; i-- {
+		ip := parseNode(chain[i])
+		if ip == nil {
+			break
+		}
+		client = ip
+		if !c.trusted(ip) {
+			break
+		}
+	}
+	return client.String()
+}
+
+// Middleware resolves the client IP of each request once, for ClientIP to
+// return to later handlers. It replaces chi's RealIP middleware, which trusts
+// forwarding headers from any host.
+func (c *ClientIPResolver) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), clientIPContextKey{}, c.ClientIP(r))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// ClientIP returns the client IP of a request, as resolved by a
+// ClientIPResolver's middleware. Without the middleware, no proxies are
+// trusted and the request's remote address is used.
+func ClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPContextKey{}).(string); ok {
+		return ip
+	}
+	return remoteHost(r.RemoteAddr)
+}
+
+// remoteHost returns the host of a request's remote address, stripping the
+// port and the brackets around IPv6 addresses, e.g. [fd00::1]:8080
+func remoteHost(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		// There is no port
+		host = remoteAddr
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+}
+
+// forwardedForChain returns the addresses in X-Forwarded-For headers, from the
+// client to the nearest proxy
+func forwardedForChain(headers []string) []string {
+	var chain []string
+	for _, header := range headers {
+		for _, addr := range strings.Split(header, ",") {
+			chain = append(chain, strings.TrimSpace(addr))
+		}
+	}
+	return chain
+}
+
+// forwardedChain returns the for= nodes of RFC 7239 Forwarded headers, from
+// the client to the nearest proxy. An element without a for= parameter gives
+// an empty node, since the proxy that added it didn't identify its client.
+func forwardedChain(headers []string) []string {
+	var chain []string
+	for _, header := range headers {
+		for _, element := range splitQuoted(header, ',') {
+			node := ""
+			for _, pair := range splitQuoted(element, ';') {
+				key, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+				if found && strings.EqualFold(key, "for") {
+					node = unquote(value)
+				}
+			}
+			chain = append(chain, node)
+		}
+	}
+	return chain
+}
+
+// splitQuoted splits s at each sep that is outside a quoted string
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted, escaped, start := false, false, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case escaped:
+			escaped = false
+		case quoted && s[i] == '\\':
+			escaped = true
+		case s[i] == '"':
+			quoted = !quoted
+		case !quoted && s[i] == sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// unquote returns the value of a token or quoted string
+func unquote(value string) string {
+	value = strings.TrimSpace(value)
+	if len(value) < 2 || value[0] != '"' || value[len(value)-1] != '"' {
+		return value
+	}
+	var b strings.Builder
+	escaped := false
+	for _, c := range value[1 : len(value)-1] {
+		if c == '\\' && !escaped {
+			escaped = true
+			continue
+		}
+		escaped = false
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// parseNode returns the IP address of a forwarded node, which may have a port
+// and, for IPv6, brackets, e.g. 192.0.2.43:47011 or [2001:db8::1]:4711. It
+// returns nil for unknown and obfuscated nodes.
+func parseNode(node string) net.IP {
+	if ip := net.ParseIP(node); ip != nil {
+		return ip
+	}
+	return net.ParseIP(remoteHost(node))
+}
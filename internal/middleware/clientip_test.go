@@ -0,0 +1,154 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIPResolver(t *testing.T) {
+	resolver, err := NewClientIPResolver("10.0.0.0/24, 10.0.1.1, fd00::/64")
+	if err != nil {
+		t.Fatalf("Failed to create client IP resolver: %v", err)
+	}
+
+	testCases := []struct {
+		name       string
+		remoteAddr string
+		xff        []string
+		forwarded  []string
+		expected   string
+	}{
+		{
+			name:       "No forwarding headers",
+			remoteAddr: "192.168.1.10:12345",
+			expected:   "192.168.1.10",
+		},
+		{
+			name:       "Bracketed IPv6 remote address",
+			remoteAddr: "[fd01::10]:12345",
+			expected:   "fd01::10",
+		},
+		{
+			name:       "Headers from untrusted host are ignored",
+			remoteAddr: "192.168.1.10:12345",
+			xff:        []string{"10.20.30.1"},
+			forwarded:  []string{"for=10.20.30.1"},
+			expected:   "192.168.1.10",
+		},
+		{
+			name:       "Trusted proxy",
+			remoteAddr: "10.0.0.5:12345",
+			xff:        []string{"10.20.30.1"},
+			expected:   "10.20.30.1",
+		},
+		{
+			name:       "Trusted proxy given as an address",
+			remoteAddr: "10.0.1.1:12345",
+			xff:        []string{"10.20.30.1"},
+			expected:   "10.20.30.1",
+		},
+		{
+			name:       "Entries spoofed by the client are ignored",
+			remoteAddr: "10.0.0.5:12345",
+			xff:        []string{"10.20.30.2, 10.20.30.1"},
+			expected:   "10.20.30.1",
+		},
+		{
+			name:       "Chain of trusted proxies",
+			remoteAddr: "10.0.0.5:12345",
+			xff:        []string{"10.20.30.2, 10.20.30.1, fd00::7", "10.0.1.1"},
+			expected:   "10.20.30.1",
+		},
+		{
+			name:       "Only trusted proxies",
+			remoteAddr: "10.0.0.5:12345",
+			xff:        []string{"10.0.0.6, 10.0.0.7"},
+			expected:   "10.0.0.6",
+		},
+		{
+			name:       "Invalid entry stops the chain",
+			remoteAddr: "10.0.0.5:12345",
+			xff:        []string{"10.20.30.1, not-an-ip, 10.0.0.6"},
+			expected:   "10.0.0.6",
+		},
+		{
+			name:       "Forwarded header",
+			remoteAddr: "10.0.0.5:12345",
+			forwarded:  []string{`for=10.20.30.2;proto=http, For="[fd01::1]:4711";by=10.0.0.5`},
+			expected:   "fd01::1",
+		},
+		{
+			name:       "Forwarded header across several lines",
+			remoteAddr: "10.0.0.5:12345",
+			forwarded:  []string{"for=10.20.30.1:47011", `for="[fd00::7]"`},
+			expected:   "10.20.30.1",
+		},
+		{
+			name:       "Forwarded header with unknown node",
+			remoteAddr: "[fd00::5]:12345",
+			forwarded:  []string{`for=10.20.30.1, for=unknown, for="_hidden;proxy", for=fd00::7`},
+			expected:   "fd00::7",
+		},
+		{
+			name:       "X-Forwarded-For takes precedence over Forwarded",
+			remoteAddr: "10.0.0.5:12345",
+			xff:        []string{"10.20.30.1"},
+			forwarded:  []string{"for=10.20.30.2"},
+			expected:   "10.20.30.1",
+		},
+		{
+			name:       "Invalid remote address",
+			remoteAddr: "invalid",
+			xff:        []string{"10.20.30.1"},
+			expected:   "invalid",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/test", nil)
+			req.RemoteAddr = tc.remoteAddr
+			for _, xff := range tc.xff {
+				req.Header.Add("X-Forwarded-For", xff)
+			}
+			for _, forwarded := range tc.forwarded {
+				req.Header.Add("Forwarded", forwarded)
+			}
+
+			if ip := resolver.ClientIP(req); ip != tc.expected {
+				t.Errorf("Expected client IP %s, got %s", tc.expected, ip)
+			}
+
+			// The middleware makes the resolved IP available to handlers
+			var resolved string
+			handler := resolver.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				resolved = ClientIP(r)
+			}))
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			if resolved != tc.expected {
+				t.Errorf("Expected client IP %s from the middleware, got %s", tc.expected, resolved)
+			}
+		})
+	}
+}
+
+func TestClientIP_WithoutMiddleware(t *testing.T) {
+	req := httptest.NewRequest("GET", "/test", nil)
+	req.RemoteAddr = "10.0.0.5:12345"
+	req.Header.Set("X-Forwarded-For", "10.20.30.1")
+	if ip := ClientIP(req); ip != "10.0.0.5" {
+		t.Errorf("Expected the remote address without a resolver, got %s", ip)
+	}
+}
+
+func TestNewClientIPResolver_Invalid(t *testing.T) {
+	for _, proxies := range []string{"10.0.0.0/33", "proxy.example.com", "10.0.0.0/8,invalid"} {
+		if _, err := NewClientIPResolver(proxies); err == nil {
+			t.Errorf("Expected an error for trusted proxies %q", proxies)
+		}
+	}
+	if _, err := NewClientIPResolver(""); err != nil {
+		t.Errorf("Expected no trusted proxies to be valid: %v", err)
+	}
+}
@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/OpenCHAMI/jwtauth/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// RequireScope creates a middleware that only allows requests whose JWT grants
+// the given scope. It relies on the token having been verified and
+// authenticated by earlier middleware, e.g. jwtauth.Verifier followed by an
+// authenticator.
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, claims, err := jwtauth.FromContext(r.Context())
+			if err != nil || token == nil {
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+
+			if !slices.Contains(TokenScopes(claims), scope) {
+				log.Debug().Msgf("Denying %s %s to %s: missing scope %s", r.Method, r.URL.Path, token.Subject(), scope)
+				http.Error(w, "missing required scope "+scope, http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// TokenScopes returns the scopes granted by a token's claims. Scopes are read
+// from the space-delimited "scope" claim (RFC 8693) and from the "scp" claim,
+// which some issuers use instead, as either a list or a space-delimited string.
+func TokenScopes(claims map[string]interface{}) []string {
+	var scopes []string
+	for _, claim := range []string{"scope", "scp"} {
+		switch v := claims[claim].(type) {
+		case string:
+			scopes = append(scopes, strings.Fields(v)...)
+		case []string:
+			scopes = append(scopes, v...)
+		case []interface{}:
+			for _, s := range v {
+				if s, ok := s.(string); ok {
+					scopes = append(scopes, s)
+				}
+			}
+		}
+	}
+	return scopes
+}
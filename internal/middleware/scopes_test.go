@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/OpenCHAMI/jwtauth/v5"
+)
+
+// TestTokenScopes tests reading scopes from the supported claims
+func TestTokenScopes(t *testing.T) {
+	testCases := []struct {
+		name     string
+		claims   map[string]interface{}
+		expected []string
+	}{
+		{"No scopes", map[string]interface{}{"sub": "alice"}, nil},
+		{"Space-delimited scope", map[string]interface{}{"scope": "cloud-init:read cloud-init:write"}, []string{"cloud-init:read", "cloud-init:write"}},
+		{"scp list", map[string]interface{}{"scp": []interface{}{"cloud-init:read", 3}}, []string{"cloud-init:read"}},
+		{"Both claims", map[string]interface{}{"scope": "a", "scp": []string{"b"}}, []string{"a", "b"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if scopes := TokenScopes(tc.claims); !reflect.DeepEqual(scopes, tc.expected) {
+				t.Errorf("expected scopes %v, got %v", tc.expected, scopes)
+			}
+		})
+	}
+}
+
+// TestRequireScope tests that requests are only passed on with the required scope
+func TestRequireScope(t *testing.T) {
+	tokenAuth := jwtauth.New("HS256", []byte("secret"), nil)
+	handler := jwtauth.Verifier(tokenAuth)(RequireScope("cloud-init:write")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+
+	testCases := []struct {
+		name           string
+		claims         map[string]interface{}
+		expectedStatus int
+	}{
+		{"No token", nil, http.StatusUnauthorized},
+		{"Missing scope", map[string]interface{}{"sub": "alice", "scope": "cloud-init:read"}, http.StatusForbidden},
+		{"Granted scope", map[string]interface{}{"sub": "alice", "scope": "cloud-init:read cloud-init:write"}, http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/admin/groups", nil)
+			if tc.claims != nil {
+				_, token, err := tokenAuth.Encode(tc.claims)
+				if err != nil {
+					t.Fatalf("failed to encode token: %v", err)
+				}
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tc.expectedStatus {
+				t.Errorf("expected status %d, got %d", tc.expectedStatus, rr.Code)
+			}
+		})
+	}
+}
@@ -9,44 +9,46 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
+// parseWireGuardCIDRs parses a comma-separated list of WireGuard subnets, so
+// that a dual-stack tunnel can have both an IPv4 and an IPv6 subnet, e.g.
+// 100.97.0.0/16,fd42::/64
+func parseWireGuardCIDRs(wireGuardCIDRs string) ([]*net.IPNet, error) {
+	var wgNets []*net.IPNet
+	for _, cidr := range strings.Split(wireGuardCIDRs, ",") {
+		_, wgNet, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, err
+		}
+		wgNets = append(wgNets, wgNet)
+	}
+	return wgNets, nil
+}
+
+// inWireGuardSubnet reports whether ip is in any of the WireGuard subnets
+func inWireGuardSubnet(wgNets []*net.IPNet, ip net.IP) bool {
+	for _, wgNet := range wgNets {
+		if wgNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
 // WireGuardMiddlewareWithProxy creates a middleware to enforce WireGuard policy.
+// The client IP is the one resolved by a ClientIPResolver's middleware, so
+// forwarding headers are only honoured from trusted proxies.
+// wireGuardCIDR may list several comma-separated subnets, e.g. an IPv4 and an
+// IPv6 subnet for a dual-stack tunnel.
 func WireGuardMiddlewareWithProxy(wireGuardCIDR string, allow bool) func(http.Handler) http.Handler {
-	_, wgNet, err := net.ParseCIDR(wireGuardCIDR)
+	wgNets, err := parseWireGuardCIDRs(wireGuardCIDR)
 	if err != nil {
 		panic("Invalid WireGuard CIDR provided: " + err.Error())
 	}
 
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			var clientIP string
-
-			// Check for X-Forwarded-For header
-			xff := r.Header.Get("X-Forwarded-For")
-			if xff != "" {
-				clientIP = strings.Split(xff, ",")[0]
-			}
-
-			// Check for Forwarded header
-			if clientIP == "" {
-				forwarded := r.Header.Get("Forwarded")
-				if forwarded != "" {
-					for _, part := range strings.Split(forwarded, ";") {
-						if strings.HasPrefix(strings.TrimSpace(part), "for=") {
-							clientIP = strings.Trim(strings.Split(part, "=")[1], "\"")
-							break
-						}
-					}
-				}
-			}
-
-			// Fallback to RemoteAddr
-			if clientIP == "" {
-				clientIP, _, err = net.SplitHostPort(r.RemoteAddr)
-				if err != nil {
-					http.Error(w, "Invalid Remote Address", http.StatusForbidden)
-					return
-				}
-			}
+			// Only trusted proxies may forward the client IP
+			clientIP := ClientIP(r)
 
 			// Parse client IP
 			ip := net.ParseIP(clientIP)
@@ -55,8 +57,8 @@ func WireGuardMiddlewareWithProxy(wireGuardCIDR string, allow bool) func(http.Ha
 				return
 			}
 
-			// Check if IP is in WireGuard subnet
-			isInWireGuardSubnet := wgNet.Contains(ip)
+			// Check if IP is in a WireGuard subnet
+			isInWireGuardSubnet := inWireGuardSubnet(wgNets, ip)
 
 			// Enforce policy
 			if allow && !isInWireGuardSubnet {
@@ -75,53 +77,23 @@ func WireGuardMiddlewareWithProxy(wireGuardCIDR string, allow bool) func(http.Ha
 
 // WireGuardMiddlewareWithInterface enforces policies based on the client's IP and the WireGuard subnet.
 // It allows requests if the CLIENT IP is either:
-// 1. In the WireGuard subnet (e.g., 100.97.0.0/16), OR
+// 1. In a WireGuard subnet (e.g., 100.97.0.0/16 or, for dual-stack tunnels, 100.97.0.0/16,fd42::/64), OR
 // 2. Arriving on the specified WireGuard interface
 //
 // This ensures that nodes can access cloud-init either:
 // - Through their WireGuard tunnel (client IP in WireGuard subnet)
 // - Directly on the server's WireGuard interface
 func WireGuardMiddlewareWithInterface(wireGuardInterface string, wireGuardCIDR string) func(http.Handler) http.Handler {
-	// Parse the WireGuard CIDR into a *net.IPNet
-	_, wgNet, err := net.ParseCIDR(wireGuardCIDR)
+	// Parse the WireGuard CIDRs into *net.IPNets
+	wgNets, err := parseWireGuardCIDRs(wireGuardCIDR)
 	if err != nil {
 		panic("Invalid WireGuard CIDR provided: " + err.Error())
 	}
 
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Extract client IP from request
-			var clientIP string
-
-			// Check for X-Forwarded-For header
-			xff := r.Header.Get("X-Forwarded-For")
-			if xff != "" {
-				clientIP = strings.TrimSpace(strings.Split(xff, ",")[0])
-			}
-
-			// Check for Forwarded header
-			if clientIP == "" {
-				forwarded := r.Header.Get("Forwarded")
-				if forwarded != "" {
-					for _, part := range strings.Split(forwarded, ";") {
-						if strings.HasPrefix(strings.TrimSpace(part), "for=") {
-							clientIP = strings.Trim(strings.Split(part, "=")[1], "\"")
-							break
-						}
-					}
-				}
-			}
-
-			// Fallback to RemoteAddr
-			if clientIP == "" {
-				var err error
-				clientIP, _, err = net.SplitHostPort(r.RemoteAddr)
-				if err != nil {
-					log.Debug().Err(err).Msg("Invalid Remote Address")
-					http.Error(w, "Invalid Remote Address", http.StatusForbidden)
-					return
-				}
-			}
+			// Extract client IP from request. Only trusted proxies may forward it.
+			clientIP := ClientIP(r)
 
 			// Parse client IP
 			clientIPParsed := net.ParseIP(clientIP)
@@ -132,7 +104,7 @@ func WireGuardMiddlewareWithInterface(wireGuardInterface string, wireGuardCIDR s
 			}
 
 			// Check if CLIENT IP is in WireGuard subnet
-			isInWireGuardSubnet := wgNet.Contains(clientIPParsed)
+			isInWireGuardSubnet := inWireGuardSubnet(wgNets, clientIPParsed)
 
 			// Retrieve the local address (where the request arrived on the server)
 			var localIP string
@@ -22,6 +22,20 @@ func (m mockAddr) String() string {
 	return m.address
 }
 
+// testProxies are the trusted proxies of the middleware tests, whose
+// forwarding headers are honoured
+const testProxies = "192.168.1.10,fd00::5"
+
+// withTestProxies resolves the client IP of requests to handler, trusting
+// testProxies
+func withTestProxies(t *testing.T, handler http.Handler) http.Handler {
+	resolver, err := NewClientIPResolver(testProxies)
+	if err != nil {
+		t.Fatalf("Failed to create client IP resolver: %v", err)
+	}
+	return resolver.Middleware(handler)
+}
+
 // TestWireGuardMiddlewareWithProxy tests the proxy-based WireGuard middleware
 func TestWireGuardMiddlewareWithProxy(t *testing.T) {
 	testCases := []struct {
@@ -84,6 +98,33 @@ func TestWireGuardMiddlewareWithProxy(t *testing.T) {
 			expectedStatus: http.StatusOK,
 			expectedBody:   "OK",
 		},
+		{
+			name:           "Ignore X-Forwarded-For from untrusted host",
+			wireGuardCIDR:  "100.97.0.0/16",
+			allow:          true,
+			clientIP:       "192.168.1.11",
+			xff:            "100.97.0.20",
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   "Access denied: Not in WireGuard subnet\n",
+		},
+		{
+			name:           "Ignore Forwarded from untrusted host",
+			wireGuardCIDR:  "100.97.0.0/16",
+			allow:          true,
+			clientIP:       "192.168.1.11",
+			forwarded:      "for=100.97.0.30",
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   "Access denied: Not in WireGuard subnet\n",
+		},
+		{
+			name:           "Ignore X-Forwarded-For entries spoofed by the client",
+			wireGuardCIDR:  "100.97.0.0/16",
+			allow:          true,
+			clientIP:       "192.168.1.10",
+			xff:            "100.97.0.20, 192.168.1.11",
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   "Access denied: Not in WireGuard subnet\n",
+		},
 		{
 			name:           "X-Forwarded-For takes precedence over Forwarded",
 			wireGuardCIDR:  "100.97.0.0/16",
@@ -94,6 +135,39 @@ func TestWireGuardMiddlewareWithProxy(t *testing.T) {
 			expectedStatus: http.StatusOK,
 			expectedBody:   "OK",
 		},
+		{
+			name:           "Allow IPv6 client in dual-stack WireGuard subnets",
+			wireGuardCIDR:  "100.97.0.0/16, fd42::/64",
+			allow:          true,
+			clientIP:       "fd42::5",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "OK",
+		},
+		{
+			name:           "Allow IPv4 client in dual-stack WireGuard subnets",
+			wireGuardCIDR:  "100.97.0.0/16,fd42::/64",
+			allow:          true,
+			clientIP:       "100.97.0.5",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "OK",
+		},
+		{
+			name:           "Deny IPv6 client not in dual-stack WireGuard subnets",
+			wireGuardCIDR:  "100.97.0.0/16,fd42::/64",
+			allow:          true,
+			clientIP:       "fd00::5",
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   "Access denied: Not in WireGuard subnet\n",
+		},
+		{
+			name:           "Use bracketed IPv6 Forwarded header",
+			wireGuardCIDR:  "100.97.0.0/16,fd42::/64",
+			allow:          true,
+			clientIP:       "fd00::5",
+			forwarded:      `for="[fd42::30]:4711"`,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "OK",
+		},
 	}
 
 	for _, tc := range testCases {
@@ -106,11 +180,11 @@ func TestWireGuardMiddlewareWithProxy(t *testing.T) {
 
 			// Apply middleware
 			middleware := WireGuardMiddlewareWithProxy(tc.wireGuardCIDR, tc.allow)
-			wrappedHandler := middleware(handler)
+			wrappedHandler := withTestProxies(t, middleware(handler))
 
 			// Create test request
 			req := httptest.NewRequest("GET", "/test", nil)
-			req.RemoteAddr = tc.clientIP + ":12345"
+			req.RemoteAddr = net.JoinHostPort(tc.clientIP, "12345")
 			if tc.xff != "" {
 				req.Header.Set("X-Forwarded-For", tc.xff)
 			}
@@ -176,14 +250,14 @@ func TestWireGuardMiddlewareWithInterface(t *testing.T) {
 			description:        "Should use X-Forwarded-For when present",
 		},
 		{
-			name:               "Allow multiple IPs in X-Forwarded-For (use first)",
+			name:               "Allow multiple IPs in X-Forwarded-For (use first untrusted from the right)",
 			wireGuardCIDR:      "100.97.0.0/16",
 			wireGuardInterface: "wg0",
 			clientIP:           "192.168.1.10",
-			xff:                "100.97.0.30, 10.0.0.1, 172.16.0.1",
+			xff:                "10.0.0.1, 100.97.0.30, 192.168.1.10",
 			localAddr:          mockAddr{"tcp", "192.168.1.100:27777"},
 			expectedStatus:     http.StatusOK,
-			description:        "Should use first IP in X-Forwarded-For chain",
+			description:        "Should use the first IP in X-Forwarded-For that isn't a trusted proxy, reading from the right",
 		},
 		{
 			name:               "Deny invalid client IP",
@@ -203,6 +277,15 @@ func TestWireGuardMiddlewareWithInterface(t *testing.T) {
 			expectedStatus:     http.StatusOK,
 			description:        "IP at edge of subnet should be allowed",
 		},
+		{
+			name:               "Allow IPv6 client in dual-stack WireGuard subnets",
+			wireGuardCIDR:      "100.97.0.0/16,fd42::/64",
+			wireGuardInterface: "wg0",
+			clientIP:           "fd42::1:5",
+			localAddr:          mockAddr{"tcp", "[fd00::100]:27777"},
+			expectedStatus:     http.StatusOK,
+			description:        "IPv6 client in the IPv6 WireGuard subnet should be allowed",
+		},
 		{
 			name:               "Deny client just outside subnet",
 			wireGuardCIDR:      "100.97.0.0/16",
@@ -242,11 +325,11 @@ func TestWireGuardMiddlewareWithInterface(t *testing.T) {
 
 			// Apply middleware
 			middleware := WireGuardMiddlewareWithInterface(tc.wireGuardInterface, tc.wireGuardCIDR)
-			wrappedHandler := middleware(handler)
+			wrappedHandler := withTestProxies(t, middleware(handler))
 
 			// Create test request
 			req := httptest.NewRequest("GET", "/test", nil)
-			req.RemoteAddr = tc.clientIP + ":12345"
+			req.RemoteAddr = net.JoinHostPort(tc.clientIP, "12345")
 			if tc.xff != "" {
 				req.Header.Set("X-Forwarded-For", tc.xff)
 			}
@@ -281,6 +364,19 @@ func TestWireGuardMiddlewareWithProxy_InvalidCIDR(t *testing.T) {
 	_ = WireGuardMiddlewareWithProxy("invalid-cidr", true)
 }
 
+// TestWireGuardMiddlewareWithProxy_InvalidCIDRInList tests panic on an invalid
+// CIDR in a list of ranges
+func TestWireGuardMiddlewareWithProxy_InvalidCIDRInList(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("Expected panic with invalid CIDR, but didn't panic")
+		}
+	}()
+
+	// This should panic
+	_ = WireGuardMiddlewareWithProxy("100.97.0.0/16,invalid-cidr", true)
+}
+
 // TestWireGuardMiddlewareWithInterface_InvalidCIDR tests panic on invalid CIDR
 func TestWireGuardMiddlewareWithInterface_InvalidCIDR(t *testing.T) {
 	defer func() {
@@ -1,6 +1,7 @@
 package quackstore
 
 import (
+	"context"
 	"crypto/rand"
 	"database/sql"
 	"encoding/json"
@@ -12,7 +13,8 @@ import (
 
 // QuackStore implements the cistore.Store interface using Quack for persistence
 type QuackStore struct {
-	db *sql.DB
+	db       *sql.DB
+	notifier cistore.Notifier
 }
 
 // NewQuackStore creates a new QuackStore instance
@@ -41,6 +43,12 @@ func (s *QuackStore) initializeSchema() error {
 			name TEXT PRIMARY KEY,
 			data BLOB
 		)`,
+		`CREATE TABLE IF NOT EXISTS group_versions (
+			name TEXT,
+			revision INTEGER,
+			data BLOB,
+			PRIMARY KEY (name, revision)
+		)`,
 		`CREATE TABLE IF NOT EXISTS instances (
 			node_name TEXT PRIMARY KEY,
 			data BLOB
@@ -49,6 +57,23 @@ func (s *QuackStore) initializeSchema() error {
 			id INTEGER PRIMARY KEY,
 			data BLOB
 		)`,
+		`CREATE SEQUENCE IF NOT EXISTS audit_log_id START 1`,
+		`CREATE TABLE IF NOT EXISTS audit_log (
+			id BIGINT PRIMARY KEY DEFAULT nextval('audit_log_id'),
+			time TIMESTAMPTZ,
+			entity TEXT,
+			name TEXT,
+			subject TEXT,
+			data BLOB
+		)`,
+		`CREATE TABLE IF NOT EXISTS wireguard_server (
+			id INTEGER PRIMARY KEY,
+			data BLOB
+		)`,
+		`CREATE TABLE IF NOT EXISTS wireguard_peers (
+			name TEXT PRIMARY KEY,
+			data BLOB
+		)`,
 	}
 
 	for _, query := range queries {
@@ -108,6 +133,8 @@ func (s *QuackStore) AddGroupData(groupName string, groupData cistore.GroupData)
 
 	// Ensure name is set correctly
 	groupData.Name = groupName
+	// Versions are maintained by the store
+	groupData.Versions = nil
 
 	data, err := json.Marshal(groupData)
 	if err != nil {
@@ -121,6 +148,7 @@ func (s *QuackStore) AddGroupData(groupName string, groupData cistore.GroupData)
 		return fmt.Errorf("failed to insert group: %w", err)
 	}
 
+	s.notifier.Publish(cistore.EntityGroup, groupName, cistore.OperationCreate, nil, groupData)
 	return nil
 }
 
@@ -147,45 +175,74 @@ func (s *QuackStore) GetGroupData(groupName string) (cistore.GroupData, error) {
 	return group, nil
 }
 
-// UpdateGroupData updates an existing group
+// UpdateGroupData updates an existing group, keeping its previous meta-data
+// and cloud-config as a new revision
 func (s *QuackStore) UpdateGroupData(groupName string, groupData cistore.GroupData, create bool) error {
 	// Ensure name is set correctly
 	groupData.Name = groupName
+	groupData.Versions = nil
 
-	data, err := json.Marshal(groupData)
-	if err != nil {
-		return fmt.Errorf("failed to marshal group data: %w", err)
+	previous, err := s.GetGroupData(groupName)
+	exists := err == nil
+	if !exists && !create {
+		return fmt.Errorf("group (%s) not found", groupName)
 	}
 
-	fmt.Printf("Storing data for group %s: %s\n", groupName, string(data))
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback() // Ignoring error as this is a no-op once committed
+	}()
 
-	if create {
-		_, err = s.db.Exec("INSERT OR REPLACE INTO groups (name, data) VALUES (?, ?)", groupName, data)
+	if exists {
+		var next int
+		err = tx.QueryRow("SELECT COALESCE(MAX(revision), 0) + 1 FROM group_versions WHERE name = ?", groupName).Scan(&next)
+		if err != nil {
+			return fmt.Errorf("failed to get next revision: %w", err)
+		}
+		var revision cistore.GroupRevision
+		revision, groupData.Versions = cistore.ArchiveGroupData(previous, next)
+		revisionData, err := json.Marshal(revision)
+		if err != nil {
+			return fmt.Errorf("failed to marshal group revision: %w", err)
+		}
+		_, err = tx.Exec("INSERT INTO group_versions (name, revision, data) VALUES (?, ?, ?)", groupName, next, revisionData)
 		if err != nil {
-			return fmt.Errorf("failed to upsert group: %w", err)
+			return fmt.Errorf("failed to insert group revision: %w", err)
 		}
-		return nil
 	}
 
-	result, err := s.db.Exec("UPDATE groups SET data = ? WHERE name = ?", data, groupName)
+	data, err := json.Marshal(groupData)
 	if err != nil {
-		return fmt.Errorf("failed to update group: %w", err)
+		return fmt.Errorf("failed to marshal group data: %w", err)
 	}
 
-	rows, err := result.RowsAffected()
+	fmt.Printf("Storing data for group %s: %s\n", groupName, string(data))
+
+	_, err = tx.Exec("INSERT OR REPLACE INTO groups (name, data) VALUES (?, ?)", groupName, data)
 	if err != nil {
-		return fmt.Errorf("failed to get rows affected: %w", err)
+		return fmt.Errorf("failed to upsert group: %w", err)
 	}
 
-	if rows == 0 {
-		return fmt.Errorf("group (%s) not found", groupName)
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit group update: %w", err)
 	}
 
+	if exists {
+		s.notifier.Publish(cistore.EntityGroup, groupName, cistore.OperationUpdate, previous, groupData)
+	} else {
+		s.notifier.Publish(cistore.EntityGroup, groupName, cistore.OperationCreate, nil, groupData)
+	}
 	return nil
 }
 
 // RemoveGroupData removes a group
 func (s *QuackStore) RemoveGroupData(groupName string) error {
+	// Keep the removed group for change subscribers
+	previous, _ := s.GetGroupData(groupName)
+
 	result, err := s.db.Exec("DELETE FROM groups WHERE name = ?", groupName)
 	if err != nil {
 		return fmt.Errorf("failed to delete group: %w", err)
@@ -200,9 +257,61 @@ func (s *QuackStore) RemoveGroupData(groupName string) error {
 		return fmt.Errorf("group (%s) not found", groupName)
 	}
 
+	if _, err := s.db.Exec("DELETE FROM group_versions WHERE name = ?", groupName); err != nil {
+		return fmt.Errorf("failed to delete group revisions: %w", err)
+	}
+
+	s.notifier.Publish(cistore.EntityGroup, groupName, cistore.OperationDelete, previous, nil)
 	return nil
 }
 
+// GetGroupVersions returns the previous revisions of a group, oldest first
+func (s *QuackStore) GetGroupVersions(groupName string) ([]cistore.GroupRevision, error) {
+	if _, err := s.GetGroupData(groupName); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query("SELECT data FROM group_versions WHERE name = ? ORDER BY revision", groupName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query group revisions: %w", err)
+	}
+	defer func() {
+		_ = rows.Close() // Ignoring error on deferred Close
+	}()
+
+	revisions := []cistore.GroupRevision{}
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("failed to scan group revision: %w", err)
+		}
+		var revision cistore.GroupRevision
+		if err := json.Unmarshal(data, &revision); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal group revision: %w", err)
+		}
+		revisions = append(revisions, revision)
+	}
+	return revisions, rows.Err()
+}
+
+// GetGroupVersion returns a single previous revision of a group
+func (s *QuackStore) GetGroupVersion(groupName string, revision int) (cistore.GroupRevision, error) {
+	var data []byte
+	err := s.db.QueryRow("SELECT data FROM group_versions WHERE name = ? AND revision = ?", groupName, revision).Scan(&data)
+	if err == sql.ErrNoRows {
+		return cistore.GroupRevision{}, fmt.Errorf("revision %d of group (%s) not found", revision, groupName)
+	}
+	if err != nil {
+		return cistore.GroupRevision{}, fmt.Errorf("failed to query group revision: %w", err)
+	}
+
+	var groupRevision cistore.GroupRevision
+	if err := json.Unmarshal(data, &groupRevision); err != nil {
+		return cistore.GroupRevision{}, fmt.Errorf("failed to unmarshal group revision: %w", err)
+	}
+	return groupRevision, nil
+}
+
 // GetInstanceInfo returns instance information for a node
 func (s *QuackStore) GetInstanceInfo(nodeName string) (cistore.OpenCHAMIInstanceInfo, error) {
 	var data []byte
@@ -229,10 +338,11 @@ func (s *QuackStore) GetInstanceInfo(nodeName string) (cistore.OpenCHAMIInstance
 func (s *QuackStore) SetInstanceInfo(nodeName string, instanceInfo cistore.OpenCHAMIInstanceInfo) error {
 	// Get existing instance info to preserve instance ID if it exists
 	var existingData []byte
+	var existingInfo *cistore.OpenCHAMIInstanceInfo
 	err := s.db.QueryRow("SELECT data FROM instances WHERE node_name = ?", nodeName).Scan(&existingData)
 	if err == nil {
-		var existingInfo cistore.OpenCHAMIInstanceInfo
-		if err := json.Unmarshal(existingData, &existingInfo); err == nil {
+		existingInfo = &cistore.OpenCHAMIInstanceInfo{}
+		if err := json.Unmarshal(existingData, existingInfo); err == nil {
 			// Preserve existing instance ID
 			instanceInfo.InstanceID = existingInfo.InstanceID
 		}
@@ -253,11 +363,19 @@ func (s *QuackStore) SetInstanceInfo(nodeName string, instanceInfo cistore.OpenC
 		return fmt.Errorf("failed to save instance info: %w", err)
 	}
 
+	if existingInfo != nil {
+		s.notifier.Publish(cistore.EntityInstance, nodeName, cistore.OperationUpdate, *existingInfo, instanceInfo)
+	} else {
+		s.notifier.Publish(cistore.EntityInstance, nodeName, cistore.OperationCreate, nil, instanceInfo)
+	}
 	return nil
 }
 
 // DeleteInstanceInfo deletes instance information for a node
 func (s *QuackStore) DeleteInstanceInfo(nodeName string) error {
+	// Keep the removed instance info for change subscribers
+	previous, _ := s.GetInstanceInfo(nodeName)
+
 	result, err := s.db.Exec("DELETE FROM instances WHERE node_name = ?", nodeName)
 	if err != nil {
 		return fmt.Errorf("failed to delete instance: %w", err)
@@ -272,6 +390,7 @@ func (s *QuackStore) DeleteInstanceInfo(nodeName string) error {
 		return fmt.Errorf("instance not found: %s", nodeName)
 	}
 
+	s.notifier.Publish(cistore.EntityInstance, nodeName, cistore.OperationDelete, previous, nil)
 	return nil
 }
 
@@ -298,10 +417,12 @@ func (s *QuackStore) GetClusterDefaults() (cistore.ClusterDefaults, error) {
 func (s *QuackStore) SetClusterDefaults(clusterDefaults cistore.ClusterDefaults) error {
 	// Get existing defaults to merge with
 	var existingData []byte
+	var previous cistore.ClusterDefaults
 	err := s.db.QueryRow("SELECT data FROM cluster_defaults WHERE id = 1").Scan(&existingData)
 	if err == nil {
 		var existingDefaults cistore.ClusterDefaults
 		if err := json.Unmarshal(existingData, &existingDefaults); err == nil {
+			previous = existingDefaults
 			// Merge with existing defaults
 			if clusterDefaults.ClusterName != "" {
 				existingDefaults.ClusterName = clusterDefaults.ClusterName
@@ -327,6 +448,15 @@ func (s *QuackStore) SetClusterDefaults(clusterDefaults cistore.ClusterDefaults)
 			if len(clusterDefaults.PublicKeys) > 0 {
 				existingDefaults.PublicKeys = clusterDefaults.PublicKeys
 			}
+			if clusterDefaults.BootSubnet != "" {
+				existingDefaults.BootSubnet = clusterDefaults.BootSubnet
+			}
+			if clusterDefaults.WGSubnet != "" {
+				existingDefaults.WGSubnet = clusterDefaults.WGSubnet
+			}
+			if clusterDefaults.BootInterfaceDescription != "" {
+				existingDefaults.BootInterfaceDescription = clusterDefaults.BootInterfaceDescription
+			}
 			clusterDefaults = existingDefaults
 		}
 	} else if err != sql.ErrNoRows {
@@ -343,6 +473,168 @@ func (s *QuackStore) SetClusterDefaults(clusterDefaults cistore.ClusterDefaults)
 		return fmt.Errorf("failed to save cluster defaults: %w", err)
 	}
 
+	s.notifier.Publish(cistore.EntityClusterDefaults, "", cistore.OperationUpdate, previous, clusterDefaults)
+	return nil
+}
+
+// AddAuditEntry appends an entry to the audit trail
+func (s *QuackStore) AddAuditEntry(entry cistore.AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit entry: %w", err)
+	}
+
+	_, err = s.db.Exec("INSERT INTO audit_log (time, entity, name, subject, data) VALUES (?, ?, ?, ?, ?)",
+		entry.Time, string(entry.Entity), entry.Name, entry.Subject, data)
+	if err != nil {
+		return fmt.Errorf("failed to insert audit entry: %w", err)
+	}
+	return nil
+}
+
+// GetAuditEntries returns the audit entries selected by filter, newest first
+func (s *QuackStore) GetAuditEntries(filter cistore.AuditFilter) ([]cistore.AuditEntry, error) {
+	query := "SELECT id, data FROM audit_log WHERE 1 = 1"
+	args := []interface{}{}
+	if filter.Entity != "" {
+		query += " AND entity = ?"
+		args = append(args, string(filter.Entity))
+	}
+	if filter.Name != "" {
+		query += " AND name = ?"
+		args = append(args, filter.Name)
+	}
+	if filter.Subject != "" {
+		query += " AND subject = ?"
+		args = append(args, filter.Subject)
+	}
+	if !filter.Since.IsZero() {
+		query += " AND time >= ?"
+		args = append(args, filter.Since)
+	}
+	query += " ORDER BY id DESC"
+	if filter.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", filter.Limit)
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit log: %w", err)
+	}
+	defer func() {
+		_ = rows.Close() // Ignoring error on deferred Close
+	}()
+
+	entries := []cistore.AuditEntry{}
+	for rows.Next() {
+		var id int64
+		var data []byte
+		if err := rows.Scan(&id, &data); err != nil {
+			return nil, fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		var entry cistore.AuditEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal audit entry: %w", err)
+		}
+		entry.ID = id
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// GetWireGuardServer returns the WireGuard server state, which is empty if it
+// hasn't been set
+func (s *QuackStore) GetWireGuardServer() (cistore.WireGuardServer, error) {
+	var data []byte
+	err := s.db.QueryRow("SELECT data FROM wireguard_server WHERE id = 1").Scan(&data)
+	if err == sql.ErrNoRows {
+		return cistore.WireGuardServer{}, nil
+	}
+	if err != nil {
+		return cistore.WireGuardServer{}, fmt.Errorf("failed to query WireGuard server: %w", err)
+	}
+
+	var server cistore.WireGuardServer
+	if err := json.Unmarshal(data, &server); err != nil {
+		return cistore.WireGuardServer{}, fmt.Errorf("failed to unmarshal WireGuard server: %w", err)
+	}
+	return server, nil
+}
+
+// SetWireGuardServer sets the WireGuard server state
+func (s *QuackStore) SetWireGuardServer(server cistore.WireGuardServer) error {
+	data, err := json.Marshal(server)
+	if err != nil {
+		return fmt.Errorf("failed to marshal WireGuard server: %w", err)
+	}
+
+	_, err = s.db.Exec("INSERT OR REPLACE INTO wireguard_server (id, data) VALUES (1, ?)", data)
+	if err != nil {
+		return fmt.Errorf("failed to save WireGuard server: %w", err)
+	}
+	return nil
+}
+
+// GetWireGuardPeers returns the WireGuard peers, sorted by name
+func (s *QuackStore) GetWireGuardPeers() ([]cistore.WireGuardPeer, error) {
+	rows, err := s.db.Query("SELECT data FROM wireguard_peers ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query WireGuard peers: %w", err)
+	}
+	defer func() {
+		_ = rows.Close() // Ignoring error on deferred Close
+	}()
+
+	peers := []cistore.WireGuardPeer{}
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("failed to scan WireGuard peer: %w", err)
+		}
+		var peer cistore.WireGuardPeer
+		if err := json.Unmarshal(data, &peer); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal WireGuard peer: %w", err)
+		}
+		peers = append(peers, peer)
+	}
+	return peers, rows.Err()
+}
+
+// SetWireGuardPeer adds a WireGuard peer or replaces the one with the same name
+func (s *QuackStore) SetWireGuardPeer(peer cistore.WireGuardPeer) error {
+	data, err := json.Marshal(peer)
+	if err != nil {
+		return fmt.Errorf("failed to marshal WireGuard peer: %w", err)
+	}
+
+	_, err = s.db.Exec("INSERT OR REPLACE INTO wireguard_peers (name, data) VALUES (?, ?)", peer.Name, data)
+	if err != nil {
+		return fmt.Errorf("failed to save WireGuard peer: %w", err)
+	}
+	return nil
+}
+
+// DeleteWireGuardPeer removes a WireGuard peer. Removing a peer that doesn't
+// exist is not an error.
+func (s *QuackStore) DeleteWireGuardPeer(name string) error {
+	if _, err := s.db.Exec("DELETE FROM wireguard_peers WHERE name = ?", name); err != nil {
+		return fmt.Errorf("failed to delete WireGuard peer: %w", err)
+	}
+	return nil
+}
+
+// Subscribe returns a channel receiving every change made through this store
+// until ctx is done
+func (s *QuackStore) Subscribe(ctx context.Context) <-chan cistore.ChangeEvent {
+	return s.notifier.Subscribe(ctx)
+}
+
+// Ping checks that the Quack database can still be queried
+func (s *QuackStore) Ping() error {
+	var one int
+	if err := s.db.QueryRow("SELECT 1").Scan(&one); err != nil {
+		return fmt.Errorf("failed to query database: %w", err)
+	}
 	return nil
 }
 
@@ -7,6 +7,7 @@ import (
 	"net"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/rs/zerolog/log"
 
@@ -106,6 +107,20 @@ func (f *FakeSMDClient) MACfromID(id string) (string, error) {
 	return "", errors.New("not found")
 }
 
+func (f *FakeSMDClient) InterfacesFromID(id string) ([]NodeInterface, error) {
+	for _, c := range f.rosetta_mapping {
+		if c.ComponentID == id {
+			return []NodeInterface{{
+				MAC:  c.BootMAC,
+				IP:   c.BootIPAddress,
+				IPs:  []string{c.BootIPAddress},
+				WGIP: c.WGIPAddress,
+			}}, nil
+		}
+	}
+	return nil, errors.New("not found")
+}
+
 func (f *FakeSMDClient) GroupMembership(id string) ([]string, error) {
 	myGroups := make([]string, 0)
 	for group, components := range f.groups {
@@ -286,6 +301,36 @@ func (f *FakeSMDClient) PopulateNodes() {
 	// no-op
 }
 
+// RefreshCache is a no-op as the simulated inventory is never stale
+func (f *FakeSMDClient) RefreshCache() (CacheDiff, error) {
+	return CacheDiff{Added: []string{}, Removed: []string{}, IPsChanged: map[string]IPChange{}}, nil
+}
+
+// CachedNodes returns the simulated inventory as node mappings, in the order
+// the nodes were added
+func (f *FakeSMDClient) CachedNodes() []NodeMapping {
+	nodes := make([]NodeMapping, 0, len(f.rosetta_mapping))
+	for _, c := range f.rosetta_mapping {
+		interfaces, _ := f.InterfacesFromID(c.ComponentID)
+		groups, _ := f.GroupMembership(c.ComponentID)
+		nodes = append(nodes, NodeMapping{
+			Xname:      c.ComponentID,
+			Interfaces: interfaces,
+			Groups:     groups,
+		})
+	}
+	return nodes
+}
+
+// CacheStatus reports the simulated inventory as an always-fresh cache
+func (f *FakeSMDClient) CacheStatus() CacheStatus {
+	return CacheStatus{
+		Populated:  true,
+		LastUpdate: time.Now(),
+		Nodes:      len(f.components),
+	}
+}
+
 // ***** Simulated SMD Client functions.  Not part of the SMDClientInterface *****
 
 // AddNodeToInventory adds a node to the inventory.  This is not part of the SMDClient Interface and only useful as part of the simulator
@@ -374,6 +419,19 @@ func (f *FakeSMDClient) AddWGIP(id string, wgip string) error {
 	return fmt.Errorf("node (%s) not found", id)
 }
 
+func (f *FakeSMDClient) RemoveWGIP(id string, wgip string) error {
+	for idx, c := range f.rosetta_mapping {
+		if c.ComponentID == id {
+			if c.WGIPAddress == wgip {
+				c.WGIPAddress = ""
+				f.rosetta_mapping[idx] = c
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("node (%s) not found", id)
+}
+
 func (f *FakeSMDClient) WGIPfromID(id string) (string, error) {
 	for _, c := range f.rosetta_mapping {
 		if c.ComponentID == id {
@@ -1,6 +1,7 @@
 package smdclient
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
@@ -9,14 +10,18 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"net/netip"
 	"os"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
 	base "github.com/Cray-HPE/hms-base"
+	"github.com/OpenCHAMI/cloud-init/internal/metrics"
 	"github.com/OpenCHAMI/smd/v2/pkg/sm"
 	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/singleflight"
 )
 
 // Create an SMDClient Interface which can be more easily tested and mocked
@@ -25,13 +30,41 @@ type SMDClientInterface interface {
 	IDfromIP(ipaddr string) (string, error)
 	IPfromID(id string) (string, error)
 	MACfromID(id string) (string, error)
+	InterfacesFromID(id string) ([]NodeInterface, error)
 	GroupMembership(id string) ([]string, error)
 	ComponentInformation(id string) (base.Component, error)
 	ComponentInformationWithRetry(id string, maxRetries int) (base.Component, error)
 	PopulateNodes()
+	RefreshCache() (CacheDiff, error)
+	CachedNodes() []NodeMapping
 	ClusterName() string
 	AddWGIP(id string, wgip string) error
+	RemoveWGIP(id string, wgip string) error
 	WGIPfromID(id string) (string, error)
+	CacheStatus() CacheStatus
+}
+
+// CacheStatus describes the client's cache of SMD nodes
+type CacheStatus struct {
+	// Populated is false until the cache has been filled from SMD at least once
+	Populated  bool      `json:"populated" yaml:"populated"`
+	LastUpdate time.Time `json:"last-update,omitempty" yaml:"last-update,omitempty"`
+	Nodes      int       `json:"nodes" yaml:"nodes"`
+}
+
+// CacheDiff describes the changes a cache refresh made to the cached nodes
+type CacheDiff struct {
+	Added   []string `json:"added" yaml:"added" description:"Nodes that were not cached before the refresh"`
+	Removed []string `json:"removed" yaml:"removed" description:"Nodes that are no longer in SMD"`
+	// IPsChanged holds, for each node cached both before and after the
+	// refresh, the interface IPs it had before and has now
+	IPsChanged map[string]IPChange `json:"ips-changed" yaml:"ips-changed"`
+}
+
+// IPChange holds the interface IPs of a node before and after a refresh
+type IPChange struct {
+	Before []string `json:"before" yaml:"before"`
+	After  []string `json:"after" yaml:"after"`
 }
 
 // Add client usage examples
@@ -55,13 +88,38 @@ type SMDClient struct {
 	ipToXname   map[string]string
 	macToXname  map[string]string
 	wgipToXname map[string]string
+	// Direct lookups of IPs missing from the cache, see EnableCacheMissLookup
+	missLookupTimeout time.Duration
+	negativeCacheTTL  time.Duration
+	missLookups       singleflight.Group
+	unknownIPs        map[string]time.Time
+	unknownIPsMutex   sync.Mutex
+	// Events applied while a refresh is fetching from SMD, replayed onto the
+	// refreshed cache, see ApplyEvents
+	refreshesInFlight   int
+	eventsDuringRefresh []Event
+	// bootInfo picks the interface each node boots from, see SetBootInfo
+	bootInfo BootInfo
 }
 
 type NodeInterface struct {
-	MAC  string `json:"mac" yaml:"mac"`
-	IP   string `json:"ip" yaml:"ip"`
-	WGIP string `json:"wgip" yaml:"wgip"`
-	Desc string `json:"description" yaml:"description"`
+	MAC string `json:"mac" yaml:"mac"`
+	// IP is the interface's first IP address, and IPs holds all of them
+	IP   string   `json:"ip" yaml:"ip"`
+	IPs  []string `json:"ips,omitempty" yaml:"ips,omitempty"`
+	WGIP string   `json:"wgip" yaml:"wgip"`
+	Desc string   `json:"description" yaml:"description"`
+}
+
+// Addresses returns all of the interface's IP addresses
+func (i NodeInterface) Addresses() []string {
+	if len(i.IPs) > 0 {
+		return i.IPs
+	}
+	if i.IP != "" {
+		return []string{i.IP}
+	}
+	return nil
 }
 
 type NodeMapping struct {
@@ -71,8 +129,9 @@ type NodeMapping struct {
 }
 
 // NewSMDClient creates a new SMDClient which connects to the SMD server at baseurl
-// and uses the provided JWT server for authentication
-func NewSMDClient(clusterName, baseurl, jwtURL, accessToken, certPath string, insecure bool) (*SMDClient, error) {
+// and uses the provided JWT server for authentication. The node cache is
+// refreshed from SMD every refreshInterval, or only on demand if it is zero.
+func NewSMDClient(clusterName, baseurl, jwtURL, accessToken, certPath string, insecure bool, refreshInterval time.Duration) (*SMDClient, error) {
 	var (
 		c        *http.Client
 		certPool *x509.CertPool
@@ -106,39 +165,40 @@ func NewSMDClient(clusterName, baseurl, jwtURL, accessToken, certPath string, in
 	}
 
 	client := &SMDClient{
-		clusterName:       clusterName,
-		smdClient:         c,
-		smdBaseURL:        baseurl,
-		tokenEndpoint:     jwtURL,
-		accessToken:       accessToken,
-		nodesMutex:        &sync.RWMutex{},
-		nodes_last_update: time.Now(),
-		nodes:             make(map[string]NodeMapping),
-		stopCacheRefresh:  make(chan struct{}),
-		ipToXname:         make(map[string]string),
-		macToXname:        make(map[string]string),
-		wgipToXname:       make(map[string]string),
+		clusterName:      clusterName,
+		smdClient:        c,
+		smdBaseURL:       baseurl,
+		tokenEndpoint:    jwtURL,
+		accessToken:      accessToken,
+		nodesMutex:       &sync.RWMutex{},
+		nodes:            make(map[string]NodeMapping),
+		stopCacheRefresh: make(chan struct{}),
+		ipToXname:        make(map[string]string),
+		macToXname:       make(map[string]string),
+		wgipToXname:      make(map[string]string),
 	}
 
 	// Populate the cache initially
 	client.PopulateNodes()
 
 	// Start the cache refresh goroutine
-	go client.startCacheRefresh()
+	if refreshInterval > 0 {
+		go client.startCacheRefresh(refreshInterval)
+	}
 
 	return client, nil
 }
 
-// startCacheRefresh starts a goroutine that refreshes the cache every minute
-func (s *SMDClient) startCacheRefresh() {
-	ticker := time.NewTicker(1 * time.Minute)
+// startCacheRefresh refreshes the cache every interval until StopCacheRefresh is called
+func (s *SMDClient) startCacheRefresh(interval time.Duration) {
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
 			log.Debug().Msg("Ticker triggered. Refreshing cache")
-			s.RefreshCache()
+			s.PopulateNodes()
 		case <-s.stopCacheRefresh:
 			ticker.Stop()
 			return
@@ -146,18 +206,11 @@ func (s *SMDClient) startCacheRefresh() {
 	}
 }
 
-// RefreshCache refreshes the cache
-func (s *SMDClient) RefreshCache() {
-	log.Debug().Msg("Refreshing SMD cache")
-	s.PopulateNodes()
-}
-
 // StopCacheRefresh stops the cache refresh goroutine
 func (s *SMDClient) StopCacheRefresh() {
 	s.stopOnce.Do(func() {
 		close(s.stopCacheRefresh)
 	})
-	close(s.stopCacheRefresh)
 }
 
 // ClusterName returns the name of the cluster
@@ -165,14 +218,36 @@ func (s *SMDClient) ClusterName() string {
 	return s.clusterName
 }
 
+// smdEndpointLabel returns the metrics label for an SMD endpoint, with any
+// component ID and query string removed so that requests aren't labelled per
+// node
+func smdEndpointLabel(ep string) string {
+	ep, _, _ = strings.Cut(ep, "?")
+	for _, prefix := range []string{"/hsm/v2/memberships/", "/hsm/v2/State/Components/"} {
+		if strings.HasPrefix(ep, prefix) && len(ep) > len(prefix) {
+			return prefix + "{id}"
+		}
+	}
+	return ep
+}
+
 // getSMD is a helper function to initialize the SMDClient
 func (s *SMDClient) getSMD(ep string, smd interface{}) error {
+	return s.getSMDContext(context.Background(), ep, smd)
+}
+
+// getSMDContext GETs an SMD endpoint and decodes the JSON response into smd,
+// giving up once ctx is done
+func (s *SMDClient) getSMDContext(ctx context.Context, ep string, smd interface{}) (err error) {
+	defer func(start time.Time) {
+		metrics.SMDRequestDuration.WithLabelValues(smdEndpointLabel(ep), metrics.Outcome(err)).Observe(time.Since(start).Seconds())
+	}(time.Now())
 	url := s.smdBaseURL + ep
 	var resp *http.Response
 	// Manage fetching a new JWT if we initially fail
 	freshToken := false
 	for {
-		req, err := http.NewRequest("GET", url, nil)
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 		if err != nil {
 			return err
 		}
@@ -227,99 +302,215 @@ func (s *SMDClient) getSMD(ep string, smd interface{}) error {
 	return nil
 }
 
-// PopulateNodes fetches the Ethernet interface data from the SMD server and populates the nodes map
-// with the corresponding node information, including MAC addresses, IP addresses, descriptions, and group membership.
+// PopulateNodes refreshes the node cache from SMD, logging any failure
 func (s *SMDClient) PopulateNodes() {
-	s.nodesMutex.Lock()
-	defer s.nodesMutex.Unlock()
-	var ethIfaceArray []sm.CompEthInterfaceV2
-	ep := "/hsm/v2/Inventory/EthernetInterfaces/"
-	if err := s.getSMD(ep, &ethIfaceArray); err != nil {
+	if _, err := s.RefreshCache(); err != nil {
 		log.Error().Err(err).Msg("Failed to get SMD data")
-		return
-	}
-	log.Debug().Msgf("Populating nodes with %d Ethernet interfaces", len(ethIfaceArray))
-	for _, ep := range ethIfaceArray {
-		if existingNode, exists := s.nodes[ep.CompID]; exists {
-			found := false
-			for index, existingInterface := range existingNode.Interfaces {
-				if strings.EqualFold(existingInterface.MAC, ep.MACAddr) {
-					// found the interface.  Update the IP and Description
-					found = true
-					// Update the IP and Description
-					if len(ep.IPAddrs) > 0 {
-						existingInterface.IP = ep.IPAddrs[0].IPAddr
-					}
-					existingInterface.Desc = ep.Desc
-					existingNode.Interfaces[index] = existingInterface
-				}
-			}
-			if !found {
-				// This is a new interface.  Add it to the map
-				newInterface := NodeInterface{
-					MAC:  ep.MACAddr,
-					Desc: ep.Desc,
-				}
-				if len(ep.IPAddrs) > 0 {
-					newInterface.IP = ep.IPAddrs[0].IPAddr
-				}
-				existingNode.Interfaces = append(existingNode.Interfaces, newInterface)
-				s.nodes[ep.CompID] = existingNode
-			}
-		} else { // This is a new node
-			newNode := NodeMapping{
-				Xname: ep.CompID,
-			}
-			newInterface := NodeInterface{
-				MAC:  ep.MACAddr,
-				Desc: ep.Desc,
-			}
-			log.Debug().Msgf("Adding new node %s with MAC %s and IPs: %v", ep.CompID, ep.MACAddr, ep.IPAddrs)
-			if len(ep.IPAddrs) > 0 {
-				newInterface.IP = ep.IPAddrs[0].IPAddr
-			}
-			newNode.Interfaces = append(newNode.Interfaces, newInterface)
-			s.nodes[ep.CompID] = newNode
-		}
 	}
+}
 
-	// Populate group membership for all nodes
-	log.Debug().Msg("Fetching group membership for all nodes")
-	for xname, node := range s.nodes {
-		ml := new(sm.Membership)
-		membershipEp := "/hsm/v2/memberships/" + xname
-		if err := s.getSMD(membershipEp, ml); err != nil {
-			log.Debug().Err(err).Msgf("Failed to get group membership for %s", xname)
-			node.Groups = []string{} // Empty groups if fetch fails
-		} else {
-			node.Groups = ml.GroupLabels
+// RefreshCache fetches the Ethernet interfaces and group memberships of all nodes from SMD and
+// replaces the node cache with them, returning the changes it made. The new cache is built without
+// holding nodesMutex and then swapped in, so lookups are not blocked while SMD is queried. Nodes and
+// interfaces that were removed from SMD are dropped. If either request to SMD fails, the previous
+// cache is kept.
+func (s *SMDClient) RefreshCache() (CacheDiff, error) {
+	log.Debug().Msg("Refreshing SMD cache")
+	s.nodesMutex.Lock()
+	s.refreshesInFlight++
+	s.nodesMutex.Unlock()
+	defer func() {
+		s.nodesMutex.Lock()
+		s.refreshesInFlight--
+		if s.refreshesInFlight == 0 {
+			s.eventsDuringRefresh = nil
 		}
-		s.nodes[xname] = node
+		s.nodesMutex.Unlock()
+	}()
+
+	start := time.Now()
+	nodes, err := s.fetchNodes()
+	metrics.SMDCachePopulateDuration.WithLabelValues(metrics.Outcome(err)).Observe(time.Since(start).Seconds())
+	if err != nil {
+		return CacheDiff{}, err
 	}
 
-	// Build reverse indexes for O(1) lookups
+	// Build the reverse indexes for O(1) lookups before taking the lock
 	log.Debug().Msg("Building reverse indexes")
-	s.ipToXname = make(map[string]string)
-	s.macToXname = make(map[string]string)
-	s.wgipToXname = make(map[string]string)
-
-	for xname, node := range s.nodes {
+	ipToXname := make(map[string]string)
+	macToXname := make(map[string]string)
+	for xname, node := range nodes {
 		for _, iface := range node.Interfaces {
-			if iface.IP != "" {
-				s.ipToXname[strings.ToLower(iface.IP)] = xname
+			for _, ip := range iface.Addresses() {
+				ipToXname[ipKey(ip)] = xname
 			}
 			if iface.MAC != "" {
-				s.macToXname[strings.ToLower(iface.MAC)] = xname
+				macToXname[strings.ToLower(iface.MAC)] = xname
 			}
-			if iface.WGIP != "" {
-				s.wgipToXname[strings.ToLower(iface.WGIP)] = xname
+		}
+	}
+
+	s.nodesMutex.Lock()
+	defer s.nodesMutex.Unlock()
+
+	// WireGuard IPs are assigned by cloud-init rather than SMD, so keep them for
+	// interfaces that still exist, including any assigned during the refresh
+	wgipToXname := make(map[string]string)
+	for xname, node := range nodes {
+		previous, found := s.nodes[xname]
+		if !found {
+			continue
+		}
+		for i, iface := range node.Interfaces {
+			for _, previousIface := range previous.Interfaces {
+				if previousIface.WGIP != "" && strings.EqualFold(iface.MAC, previousIface.MAC) {
+					node.Interfaces[i].WGIP = previousIface.WGIP
+					wgipToXname[ipKey(previousIface.WGIP)] = xname
+				}
 			}
 		}
 	}
 
+	previous := s.nodes
+	s.nodes = nodes
+	s.ipToXname = ipToXname
+	s.macToXname = macToXname
+	s.wgipToXname = wgipToXname
+	// Events received since the fetch started may not be reflected in it
+	for _, event := range s.eventsDuringRefresh {
+		s.applyEventLocked(event)
+	}
+	diff := diffNodes(previous, s.nodes)
 	s.nodes_last_update = time.Now()
+	metrics.SMDCacheNodes.Set(float64(len(s.nodes)))
+	metrics.SMDCacheLastUpdate.Set(float64(s.nodes_last_update.Unix()))
 	log.Debug().Msgf("Nodes map populated with %d nodes, %d IP mappings, %d MAC mappings",
 		len(s.nodes), len(s.ipToXname), len(s.macToXname))
+	return diff, nil
+}
+
+// diffNodes compares two node caches by xname and interface IPs
+func diffNodes(before, after map[string]NodeMapping) CacheDiff {
+	diff := CacheDiff{
+		Added:      []string{},
+		Removed:    []string{},
+		IPsChanged: make(map[string]IPChange),
+	}
+	for xname, node := range after {
+		previous, found := before[xname]
+		if !found {
+			diff.Added = append(diff.Added, xname)
+			continue
+		}
+		beforeIPs, afterIPs := interfaceIPs(previous), interfaceIPs(node)
+		if !slices.Equal(beforeIPs, afterIPs) {
+			diff.IPsChanged[xname] = IPChange{Before: beforeIPs, After: afterIPs}
+		}
+	}
+	for xname := range before {
+		if _, found := after[xname]; !found {
+			diff.Removed = append(diff.Removed, xname)
+		}
+	}
+	slices.Sort(diff.Added)
+	slices.Sort(diff.Removed)
+	return diff
+}
+
+// interfaceIPs returns the sorted IPs of all of a node's interfaces
+func interfaceIPs(node NodeMapping) []string {
+	ips := []string{}
+	for _, iface := range node.Interfaces {
+		ips = append(ips, iface.Addresses()...)
+	}
+	slices.Sort(ips)
+	return ips
+}
+
+// ipKey returns the reverse index key of an IP address. IPv6 addresses can be
+// written in several ways, so parseable addresses use their canonical form,
+// e.g. fd00:0:0::0a and FD00::A share a key.
+func ipKey(ip string) string {
+	if addr, err := netip.ParseAddr(ip); err == nil {
+		return addr.Unmap().String()
+	}
+	return strings.ToLower(ip)
+}
+
+// fetchNodes builds a new node map from SMD's Ethernet interfaces and its
+// bulk group memberships
+func (s *SMDClient) fetchNodes() (map[string]NodeMapping, error) {
+	var ethIfaceArray []sm.CompEthInterfaceV2
+	if err := s.getSMD("/hsm/v2/Inventory/EthernetInterfaces/", &ethIfaceArray); err != nil {
+		return nil, fmt.Errorf("failed to get Ethernet interfaces: %w", err)
+	}
+	log.Debug().Msg("Fetching group membership for all nodes")
+	var memberships []sm.Membership
+	if err := s.getSMD("/hsm/v2/memberships", &memberships); err != nil {
+		return nil, fmt.Errorf("failed to get group memberships: %w", err)
+	}
+
+	log.Debug().Msgf("Populating nodes with %d Ethernet interfaces", len(ethIfaceArray))
+	nodes := make(map[string]NodeMapping)
+	for _, ep := range ethIfaceArray {
+		node := nodes[ep.CompID]
+		node.Xname = ep.CompID
+		node.Interfaces = append(node.Interfaces, newNodeInterface(ep))
+		node.Groups = []string{}
+		nodes[ep.CompID] = node
+	}
+	for _, membership := range memberships {
+		if node, found := nodes[membership.ID]; found && membership.GroupLabels != nil {
+			node.Groups = membership.GroupLabels
+			nodes[membership.ID] = node
+		}
+	}
+	return nodes, nil
+}
+
+// newNodeInterface converts an SMD Ethernet interface to a NodeInterface
+func newNodeInterface(ep sm.CompEthInterfaceV2) NodeInterface {
+	iface := NodeInterface{
+		MAC:  ep.MACAddr,
+		Desc: ep.Desc,
+	}
+	for _, addr := range ep.IPAddrs {
+		if addr.IPAddr != "" {
+			iface.IPs = append(iface.IPs, addr.IPAddr)
+		}
+	}
+	if len(iface.IPs) > 0 {
+		iface.IP = iface.IPs[0]
+	}
+	return iface
+}
+
+// CacheStatus reports whether the node cache has been populated from SMD, and
+// when it was last successfully refreshed
+func (s *SMDClient) CacheStatus() CacheStatus {
+	s.nodesMutex.RLock()
+	defer s.nodesMutex.RUnlock()
+	return CacheStatus{
+		Populated:  !s.nodes_last_update.IsZero(),
+		LastUpdate: s.nodes_last_update,
+		Nodes:      len(s.nodes),
+	}
+}
+
+// CachedNodes returns a copy of the cached nodes, sorted by xname
+func (s *SMDClient) CachedNodes() []NodeMapping {
+	s.nodesMutex.RLock()
+	defer s.nodesMutex.RUnlock()
+	nodes := make([]NodeMapping, 0, len(s.nodes))
+	for _, node := range s.nodes {
+		node.Interfaces = cloneInterfaces(node.Interfaces)
+		node.Groups = slices.Clone(node.Groups)
+		nodes = append(nodes, node)
+	}
+	slices.SortFunc(nodes, func(a, b NodeMapping) int {
+		return strings.Compare(a.Xname, b.Xname)
+	})
+	return nodes
 }
 
 // IDfromMAC returns the ID of the xname that has the MAC address
@@ -334,48 +525,76 @@ func (s *SMDClient) IDfromMAC(mac string) (string, error) {
 	return "", fmt.Errorf("MAC %s not found for an xname in nodes", mac)
 }
 
-// IDfromIP returns the ID of the xname that has the IP address
+// IDfromIP returns the ID of the xname that has the IP address. If the IP is
+// not cached and cache-miss lookups are enabled, SMD is queried for it.
 func (s *SMDClient) IDfromIP(ipaddr string) (string, error) {
+	if xname, found := s.cachedIDfromIP(ipaddr); found {
+		return xname, nil
+	}
+	if s.missLookupTimeout > 0 {
+		return s.lookupIP(ipaddr)
+	}
+	return "", fmt.Errorf("IP address %s not found for an xname in nodes", ipaddr)
+}
+
+// cachedIDfromIP looks an IP address up in the node cache
+func (s *SMDClient) cachedIDfromIP(ipaddr string) (string, bool) {
 	s.nodesMutex.RLock()
 	defer s.nodesMutex.RUnlock()
 
-	key := strings.ToLower(ipaddr)
+	key := ipKey(ipaddr)
 	if xname, found := s.ipToXname[key]; found {
-		return xname, nil
+		return xname, true
 	}
 	if xname, found := s.wgipToXname[key]; found {
-		return xname, nil
+		return xname, true
 	}
-	return "", fmt.Errorf("IP address %s not found for an xname in nodes", ipaddr)
+	return "", false
 }
 
-// IPfromID returns the IP address of the xname with the given ID
+// IPfromID returns the IP address of the interface the node with the given ID
+// boots from, chosen by BootInterface. The interface's address in the
+// cluster's boot subnet is preferred.
 func (s *SMDClient) IPfromID(id string) (string, error) {
-	s.nodesMutex.RLock()
-	defer s.nodesMutex.RUnlock()
-	if node, found := s.nodes[id]; found {
-		if node.Interfaces != nil {
-			if len(node.Interfaces) > 0 {
-				return node.Interfaces[0].IP, nil
-			}
-			return "", errors.New("no interfaces found for ID " + id)
-		}
+	iface, clusterDefaults, err := s.bootInterfaceOf(id)
+	if err != nil {
+		return "", err
+	}
+	if addr := iface.AddressIn(BootSubnet(clusterDefaults)); addr != "" {
+		return addr, nil
 	}
-	return "", errors.New("ID " + id + " not found in nodes")
+	return iface.IP, nil
 }
 
+// MACfromID returns the MAC address of the interface the node with the given
+// ID boots from, chosen by BootInterface
 func (s *SMDClient) MACfromID(id string) (string, error) {
+	iface, _, err := s.bootInterfaceOf(id)
+	if err != nil {
+		return "", err
+	}
+	return iface.MAC, nil
+}
+
+// InterfacesFromID returns a copy of the cached interfaces of the xname with
+// the given ID
+func (s *SMDClient) InterfacesFromID(id string) ([]NodeInterface, error) {
 	s.nodesMutex.RLock()
 	defer s.nodesMutex.RUnlock()
 	if node, found := s.nodes[id]; found {
-		if node.Interfaces != nil {
-			if len(node.Interfaces) > 0 {
-				return node.Interfaces[0].MAC, nil
-			}
-			return "", errors.New("no interfaces found for ID " + id)
-		}
+		return cloneInterfaces(node.Interfaces), nil
+	}
+	return nil, errors.New("ID " + id + " not found in nodes")
+}
+
+// cloneInterfaces returns a deep copy of interfaces
+func cloneInterfaces(interfaces []NodeInterface) []NodeInterface {
+	clone := make([]NodeInterface, len(interfaces))
+	for i, iface := range interfaces {
+		iface.IPs = slices.Clone(iface.IPs)
+		clone[i] = iface
 	}
-	return "", errors.New("ID " + id + " not found in nodes")
+	return clone
 }
 
 // GroupMembership returns the group labels for the xname with the given ID
@@ -433,6 +652,7 @@ func (s *SMDClient) ComponentInformationWithRetry(id string, maxRetries int) (ba
 
 		// Don't sleep on the last attempt
 		if attempt < maxRetries-1 {
+			metrics.SMDComponentRetries.Inc()
 			// Exponential backoff: 100ms, 200ms, 400ms, 800ms, etc.
 			backoff := time.Duration(100<<uint(attempt)) * time.Millisecond
 			log.Warn().
@@ -497,34 +717,70 @@ func isRetryableError(err error) bool {
 	return false
 }
 
+// AddWGIP assigns a WireGuard IP to the node with the given ID. A node has
+// at most one WireGuard IP, which is kept on the interface it boots from.
 func (s *SMDClient) AddWGIP(id string, wgip string) error {
+	clusterDefaults, instanceInfo := s.bootSettings(id)
 	s.nodesMutex.Lock()
 	defer s.nodesMutex.Unlock()
-	if node, found := s.nodes[id]; found {
-		if node.Interfaces != nil {
-			if len(node.Interfaces) > 0 {
-				node.Interfaces[0].WGIP = wgip
-				s.nodes[id] = node
-				// Update reverse index
-				s.wgipToXname[strings.ToLower(wgip)] = id
-				return nil
-			}
-			return errors.New("no interfaces found for ID " + id)
+	node, found := s.nodes[id]
+	if !found {
+		return nil
+	}
+	if len(node.Interfaces) == 0 {
+		return errors.New("no interfaces found for ID " + id)
+	}
+	for i, iface := range node.Interfaces {
+		if iface.WGIP != "" && s.wgipToXname[ipKey(iface.WGIP)] == id {
+			delete(s.wgipToXname, ipKey(iface.WGIP))
 		}
+		node.Interfaces[i].WGIP = ""
 	}
+	node.Interfaces[BootInterface(node.Interfaces, clusterDefaults, instanceInfo)].WGIP = wgip
+	s.nodes[id] = node
+	// Update reverse index
+	s.wgipToXname[ipKey(wgip)] = id
 	return nil
 }
 
+// RemoveWGIP unassigns a WireGuard IP from the node with the given ID. It does
+// nothing if the node has since been assigned another WireGuard IP, or isn't
+// cached.
+func (s *SMDClient) RemoveWGIP(id string, wgip string) error {
+	s.nodesMutex.Lock()
+	defer s.nodesMutex.Unlock()
+	node, found := s.nodes[id]
+	if !found {
+		return nil
+	}
+	for i, iface := range node.Interfaces {
+		if iface.WGIP != "" && ipKey(iface.WGIP) == ipKey(wgip) {
+			node.Interfaces[i].WGIP = ""
+		}
+	}
+	s.nodes[id] = node
+	if s.wgipToXname[ipKey(wgip)] == id {
+		delete(s.wgipToXname, ipKey(wgip))
+	}
+	return nil
+}
+
+// WGIPfromID returns the WireGuard IP assigned to the node with the given ID,
+// or an empty string if it has none
 func (s *SMDClient) WGIPfromID(id string) (string, error) {
 	s.nodesMutex.RLock()
 	defer s.nodesMutex.RUnlock()
-	if node, found := s.nodes[id]; found {
-		if node.Interfaces != nil {
-			if len(node.Interfaces) > 0 {
-				return node.Interfaces[0].WGIP, nil
-			}
-			return "", errors.New("no interfaces found for ID " + id)
+	node, found := s.nodes[id]
+	if !found {
+		return "", errors.New("ID " + id + " not found in nodes")
+	}
+	if len(node.Interfaces) == 0 {
+		return "", errors.New("no interfaces found for ID " + id)
+	}
+	for _, iface := range node.Interfaces {
+		if iface.WGIP != "" {
+			return iface.WGIP, nil
 		}
 	}
-	return "", errors.New("ID " + id + " not found in nodes")
+	return "", nil
 }
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -31,8 +32,8 @@ func TestGroupMembershipCached(t *testing.T) {
 					"Description": "Test Node 1"
 				}
 			]`))
-		case "/hsm/v2/memberships/x1000":
-			_, _ = w.Write([]byte(`{"GroupLabels": ["compute", "cabinet1"]}`))
+		case "/hsm/v2/memberships":
+			_, _ = w.Write([]byte(`[{"id": "x1000", "groupLabels": ["compute", "cabinet1"]}]`))
 		}
 	})
 	server := httptest.NewServer(handler)
@@ -98,10 +99,11 @@ func TestConcurrentReads(t *testing.T) {
 					"Description": "Test Node 2"
 				}
 			]`))
-		case "/hsm/v2/memberships/x1000":
-			_, _ = w.Write([]byte(`{"GroupLabels": ["compute"]}`))
-		case "/hsm/v2/memberships/x1001":
-			_, _ = w.Write([]byte(`{"GroupLabels": ["io"]}`))
+		case "/hsm/v2/memberships":
+			_, _ = w.Write([]byte(`[
+				{"id": "x1000", "groupLabels": ["compute"]},
+				{"id": "x1001", "groupLabels": ["io"]}
+			]`))
 		}
 	})
 	server := httptest.NewServer(handler)
@@ -180,8 +182,8 @@ func TestReverseIndexPerformance(t *testing.T) {
 
 		if r.URL.Path == "/hsm/v2/Inventory/EthernetInterfaces/" {
 			_, _ = w.Write([]byte(ethInterfaces))
-		} else if len(r.URL.Path) >= len("/hsm/v2/memberships/") && r.URL.Path[:len("/hsm/v2/memberships/")] == "/hsm/v2/memberships/" {
-			_, _ = w.Write([]byte(`{"GroupLabels": ["compute"]}`))
+		} else if r.URL.Path == "/hsm/v2/memberships" {
+			_, _ = w.Write([]byte(computeMemberships(nodeCount)))
 		}
 	})
 	server := httptest.NewServer(handler)
@@ -256,12 +258,12 @@ func TestCaseInsensitiveLookup(t *testing.T) {
 				{
 					"ComponentID": "x1000",
 					"MACAddress": "AA:BB:CC:DD:EE:FF",
-					"IPAddresses": [{"IPAddress": "192.168.1.1"}],
+					"IPAddresses": [{"IPAddress": "192.168.1.1"}, {"IPAddress": "FD00:0:0::0A"}],
 					"Description": "Test Node"
 				}
 			]`))
-		case "/hsm/v2/memberships/x1000":
-			_, _ = w.Write([]byte(`{"GroupLabels": ["compute"]}`))
+		case "/hsm/v2/memberships":
+			_, _ = w.Write([]byte(`[{"id": "x1000", "groupLabels": ["compute"]}]`))
 		}
 	})
 	server := httptest.NewServer(handler)
@@ -295,7 +297,9 @@ func TestCaseInsensitiveLookup(t *testing.T) {
 	// Test case variations for IP (though IPs are typically lowercase)
 	testIPs := []string{
 		"192.168.1.1",
-		"192.168.1.1", // IPs don't have case, but test anyway
+		"::ffff:192.168.1.1", // IPv4-mapped, as seen on dual-stack sockets
+		"fd00::a",            // IPv6 addresses match however they are written
+		"FD00:0000::000A",
 	}
 
 	for _, ip := range testIPs {
@@ -321,8 +325,8 @@ func TestAddWGIPUpdatesReverseIndex(t *testing.T) {
 					"Description": "Test Node"
 				}
 			]`))
-		case "/hsm/v2/memberships/x1000":
-			_, _ = w.Write([]byte(`{"GroupLabels": ["compute"]}`))
+		case "/hsm/v2/memberships":
+			_, _ = w.Write([]byte(`[{"id": "x1000", "groupLabels": ["compute"]}]`))
 		}
 	})
 	server := httptest.NewServer(handler)
@@ -357,6 +361,20 @@ func TestAddWGIPUpdatesReverseIndex(t *testing.T) {
 	wgip, err := client.WGIPfromID("x1000")
 	require.NoError(t, err)
 	assert.Equal(t, "10.99.0.1", wgip)
+
+	// Removing a WireGuard IP the node no longer has leaves it alone
+	require.NoError(t, client.RemoveWGIP("x1000", "10.99.0.2"))
+	wgip, err = client.WGIPfromID("x1000")
+	require.NoError(t, err)
+	assert.Equal(t, "10.99.0.1", wgip)
+
+	// Removing it clears the node's WireGuard IP and the reverse index
+	require.NoError(t, client.RemoveWGIP("x1000", "10.99.0.1"))
+	wgip, err = client.WGIPfromID("x1000")
+	require.NoError(t, err)
+	assert.Empty(t, wgip)
+	_, err = client.IDfromIP("10.99.0.1")
+	assert.Error(t, err, "WireGuard IP should not be found after RemoveWGIP")
 }
 
 // BenchmarkIDfromIP benchmarks the IP lookup performance
@@ -381,8 +399,8 @@ func BenchmarkIDfromIP(b *testing.B) {
 			}
 			ethInterfaces += "]"
 			_, _ = w.Write([]byte(ethInterfaces))
-		} else if len(r.URL.Path) >= len("/hsm/v2/memberships/") && r.URL.Path[:len("/hsm/v2/memberships/")] == "/hsm/v2/memberships/" {
-			_, _ = w.Write([]byte(`{"GroupLabels": ["compute"]}`))
+		} else if r.URL.Path == "/hsm/v2/memberships" {
+			_, _ = w.Write([]byte(computeMemberships(1000)))
 		}
 	})
 	server := httptest.NewServer(handler)
@@ -423,8 +441,8 @@ func BenchmarkGroupMembership(b *testing.B) {
 					"Description": "Test Node"
 				}
 			]`))
-		case "/hsm/v2/memberships/x1000":
-			_, _ = w.Write([]byte(`{"GroupLabels": ["compute", "cabinet1", "rack1"]}`))
+		case "/hsm/v2/memberships":
+			_, _ = w.Write([]byte(`[{"id": "x1000", "groupLabels": ["compute", "cabinet1", "rack1"]}]`))
 		}
 	})
 	server := httptest.NewServer(handler)
@@ -447,3 +465,13 @@ func BenchmarkGroupMembership(b *testing.B) {
 		_, _ = client.GroupMembership("x1000")
 	}
 }
+
+// computeMemberships returns a bulk memberships response placing nodes x0
+// through x<count-1> in the compute group
+func computeMemberships(count int) string {
+	memberships := make([]string, count)
+	for i := range memberships {
+		memberships[i] = fmt.Sprintf(`{"id": "x%d", "groupLabels": ["compute"]}`, i)
+	}
+	return "[" + strings.Join(memberships, ",") + "]"
+}
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestPopulateNodes(t *testing.T) {
@@ -51,14 +52,13 @@ func TestPopulateNodes(t *testing.T) {
 				"Description": "Test Node 4 Interface 2"
 			}
 		]`))
-		case "/hsm/v2/memberships/x1000":
-			_, _ = w.Write([]byte(`{"GroupLabels": ["compute"]}`))
-		case "/hsm/v2/memberships/x1001":
-			_, _ = w.Write([]byte(`{"GroupLabels": ["compute", "io"]}`))
-		case "/hsm/v2/memberships/x1002":
-			_, _ = w.Write([]byte(`{"GroupLabels": ["compute"]}`))
-		case "/hsm/v2/memberships/x1003":
-			_, _ = w.Write([]byte(`{"GroupLabels": ["compute", "cabinet1"]}`))
+		case "/hsm/v2/memberships":
+			_, _ = w.Write([]byte(`[
+				{"id": "x1000", "groupLabels": ["compute"]},
+				{"id": "x1001", "groupLabels": ["compute", "io"]},
+				{"id": "x1002", "groupLabels": ["compute"]},
+				{"id": "x1003", "groupLabels": ["compute", "cabinet1"]}
+			]`))
 		}
 	})
 	server := httptest.NewServer(handler)
@@ -152,14 +152,13 @@ func TestIPfromID(t *testing.T) {
 				"Description": "Test Node 4 Interface 2"
 			}
 		]`))
-		case "/hsm/v2/memberships/x1000":
-			_, _ = w.Write([]byte(`{"GroupLabels": ["compute"]}`))
-		case "/hsm/v2/memberships/x1001":
-			_, _ = w.Write([]byte(`{"GroupLabels": ["compute"]}`))
-		case "/hsm/v2/memberships/x1002":
-			_, _ = w.Write([]byte(`{"GroupLabels": ["compute"]}`))
-		case "/hsm/v2/memberships/x1003":
-			_, _ = w.Write([]byte(`{"GroupLabels": ["compute"]}`))
+		case "/hsm/v2/memberships":
+			_, _ = w.Write([]byte(`[
+				{"id": "x1000", "groupLabels": ["compute"]},
+				{"id": "x1001", "groupLabels": ["compute"]},
+				{"id": "x1002", "groupLabels": ["compute"]},
+				{"id": "x1003", "groupLabels": ["compute"]}
+			]`))
 		}
 	})
 	server := httptest.NewServer(handler)
@@ -245,14 +244,13 @@ func TestIDfromIP(t *testing.T) {
 				"Description": "Test Node 4 Interface 2"
 			}
 		]`))
-		case "/hsm/v2/memberships/x1000":
-			_, _ = w.Write([]byte(`{"GroupLabels": ["compute"]}`))
-		case "/hsm/v2/memberships/x1001":
-			_, _ = w.Write([]byte(`{"GroupLabels": ["compute"]}`))
-		case "/hsm/v2/memberships/x1002":
-			_, _ = w.Write([]byte(`{"GroupLabels": ["compute"]}`))
-		case "/hsm/v2/memberships/x1003":
-			_, _ = w.Write([]byte(`{"GroupLabels": ["compute"]}`))
+		case "/hsm/v2/memberships":
+			_, _ = w.Write([]byte(`[
+				{"id": "x1000", "groupLabels": ["compute"]},
+				{"id": "x1001", "groupLabels": ["compute"]},
+				{"id": "x1002", "groupLabels": ["compute"]},
+				{"id": "x1003", "groupLabels": ["compute"]}
+			]`))
 		}
 	})
 	server := httptest.NewServer(handler)
@@ -338,14 +336,13 @@ func TestIDfromMAC(t *testing.T) {
 				"Description": "Test Node 4 Interface 2"
 			}
 		]`))
-		case "/hsm/v2/memberships/x1000":
-			_, _ = w.Write([]byte(`{"GroupLabels": ["compute"]}`))
-		case "/hsm/v2/memberships/x1001":
-			_, _ = w.Write([]byte(`{"GroupLabels": ["compute"]}`))
-		case "/hsm/v2/memberships/x1002":
-			_, _ = w.Write([]byte(`{"GroupLabels": ["compute"]}`))
-		case "/hsm/v2/memberships/x1003":
-			_, _ = w.Write([]byte(`{"GroupLabels": ["compute"]}`))
+		case "/hsm/v2/memberships":
+			_, _ = w.Write([]byte(`[
+				{"id": "x1000", "groupLabels": ["compute"]},
+				{"id": "x1001", "groupLabels": ["compute"]},
+				{"id": "x1002", "groupLabels": ["compute"]},
+				{"id": "x1003", "groupLabels": ["compute"]}
+			]`))
 		}
 	})
 	server := httptest.NewServer(handler)
@@ -391,3 +388,355 @@ func TestIDfromMAC(t *testing.T) {
 		})
 	}
 }
+
+func TestInterfacesFromID(t *testing.T) {
+	// Mock SMD server
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		switch r.URL.Path {
+		case "/hsm/v2/Inventory/EthernetInterfaces/":
+			_, _ = w.Write([]byte(`[
+			{
+				"ComponentID": "x1000",
+				"MACAddress": "00:11:22:33:44:55",
+				"IPAddresses": [{"IPAddress": "192.168.1.1"}],
+				"Description": "Test Node 1"
+			},
+			{
+				"ComponentID": "x1003",
+				"MACAddress": "22:33:44:55:66:77",
+				"IPAddresses": [{"IPAddress": "192.168.1.5"}],
+				"Description": "Test Node 4 Interface 1"
+			},
+			{
+				"ComponentID": "x1003",
+				"MACAddress": "88:99:AA:BB:CC:DD",
+				"IPAddresses": [],
+				"Description": "Test Node 4 Interface 2"
+			}
+		]`))
+		case "/hsm/v2/memberships":
+			_, _ = w.Write([]byte(`[
+				{"id": "x1000", "groupLabels": ["compute"]},
+				{"id": "x1003", "groupLabels": ["compute"]}
+			]`))
+		}
+	})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	// Create SMDClient
+	client := &SMDClient{
+		smdClient:         server.Client(),
+		smdBaseURL:        server.URL,
+		nodesMutex:        &sync.RWMutex{},
+		nodes_last_update: time.Now(),
+		nodes:             make(map[string]NodeMapping),
+		ipToXname:         make(map[string]string),
+		macToXname:        make(map[string]string),
+		wgipToXname:       make(map[string]string),
+	}
+
+	// Call PopulateNodes to populate the nodes map
+	client.PopulateNodes()
+
+	interfaces, err := client.InterfacesFromID("x1003")
+	assert.NoError(t, err)
+	assert.Equal(t, []NodeInterface{
+		{MAC: "22:33:44:55:66:77", IP: "192.168.1.5", IPs: []string{"192.168.1.5"}, Desc: "Test Node 4 Interface 1"},
+		{MAC: "88:99:AA:BB:CC:DD", Desc: "Test Node 4 Interface 2"},
+	}, interfaces)
+
+	// Modifying the returned slice must not modify the cache
+	interfaces[0].IP = "10.0.0.1"
+	ip, err := client.IPfromID("x1003")
+	assert.NoError(t, err)
+	assert.Equal(t, "192.168.1.5", ip)
+
+	_, err = client.InterfacesFromID("x9999")
+	assert.EqualError(t, err, "ID x9999 not found in nodes")
+}
+
+func TestCacheStatus(t *testing.T) {
+	available := false
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !available {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/hsm/v2/Inventory/EthernetInterfaces/":
+			_, _ = w.Write([]byte(`[{"ComponentID": "x1000", "MACAddress": "00:11:22:33:44:55", "IPAddresses": [{"IPAddress": "192.168.1.1"}]}]`))
+		case "/hsm/v2/memberships":
+			_, _ = w.Write([]byte(`[{"id": "x1000", "groupLabels": ["compute"]}]`))
+		}
+	})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	client := &SMDClient{
+		smdClient:   server.Client(),
+		smdBaseURL:  server.URL,
+		nodesMutex:  &sync.RWMutex{},
+		nodes:       make(map[string]NodeMapping),
+		ipToXname:   make(map[string]string),
+		macToXname:  make(map[string]string),
+		wgipToXname: make(map[string]string),
+	}
+
+	// A failed fetch leaves the cache unpopulated
+	client.PopulateNodes()
+	status := client.CacheStatus()
+	assert.False(t, status.Populated)
+	assert.True(t, status.LastUpdate.IsZero())
+
+	available = true
+	client.PopulateNodes()
+	status = client.CacheStatus()
+	assert.True(t, status.Populated)
+	assert.WithinDuration(t, time.Now(), status.LastUpdate, time.Minute)
+	assert.Equal(t, 1, status.Nodes)
+}
+
+func TestSMDEndpointLabel(t *testing.T) {
+	assert.Equal(t, "/hsm/v2/Inventory/EthernetInterfaces/", smdEndpointLabel("/hsm/v2/Inventory/EthernetInterfaces/"))
+	assert.Equal(t, "/hsm/v2/memberships/{id}", smdEndpointLabel("/hsm/v2/memberships/x1000"))
+	assert.Equal(t, "/hsm/v2/State/Components/{id}", smdEndpointLabel("/hsm/v2/State/Components/x1000c0s0b0n0"))
+	assert.Equal(t, "/hsm/v2/Inventory/EthernetInterfaces", smdEndpointLabel("/hsm/v2/Inventory/EthernetInterfaces?IPAddress=10.0.0.1"))
+}
+
+func TestPopulateNodes_Refresh(t *testing.T) {
+	var mu sync.Mutex
+	interfaces := `[
+		{"ComponentID": "x1000", "MACAddress": "00:11:22:33:44:55", "IPAddresses": [{"IPAddress": "192.168.1.1"}]},
+		{"ComponentID": "x1000", "MACAddress": "00:11:22:33:44:66", "IPAddresses": [{"IPAddress": "192.168.1.2"}]},
+		{"ComponentID": "x1001", "MACAddress": "66:77:88:99:AA:BB", "IPAddresses": [{"IPAddress": "192.168.1.3"}]}
+	]`
+	memberships := `[{"id": "x1000", "groupLabels": ["compute"]}, {"id": "x1001", "groupLabels": ["io"]}]`
+	failMemberships := false
+	membershipsRequested := make(chan struct{}, 1)
+	releaseMemberships := make(chan struct{})
+	close(releaseMemberships)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ifaces, members, fail, release := interfaces, memberships, failMemberships, releaseMemberships
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/hsm/v2/Inventory/EthernetInterfaces/":
+			_, _ = w.Write([]byte(ifaces))
+		case "/hsm/v2/memberships":
+			select {
+			case membershipsRequested <- struct{}{}:
+			default:
+			}
+			<-release
+			if fail {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			_, _ = w.Write([]byte(members))
+		default:
+			t.Errorf("unexpected request for %s", r.URL.Path)
+		}
+	})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	client := &SMDClient{
+		smdClient:   server.Client(),
+		smdBaseURL:  server.URL,
+		nodesMutex:  &sync.RWMutex{},
+		nodes:       make(map[string]NodeMapping),
+		ipToXname:   make(map[string]string),
+		macToXname:  make(map[string]string),
+		wgipToXname: make(map[string]string),
+	}
+	client.PopulateNodes()
+	<-membershipsRequested
+	require.NoError(t, client.AddWGIP("x1000", "100.97.0.2"))
+
+	// x1001 and one of x1000's interfaces are removed from SMD, and x1000 changes groups
+	mu.Lock()
+	interfaces = `[{"ComponentID": "x1000", "MACAddress": "00:11:22:33:44:55", "IPAddresses": [{"IPAddress": "192.168.1.1"}]}]`
+	memberships = `[{"id": "x1000", "groupLabels": ["compute", "gpu"]}]`
+	releaseMemberships = make(chan struct{})
+	mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		client.PopulateNodes()
+		close(done)
+	}()
+
+	// Lookups are served from the previous cache while SMD is being queried
+	<-membershipsRequested
+	id, err := client.IDfromIP("192.168.1.3")
+	require.NoError(t, err)
+	assert.Equal(t, "x1001", id)
+	mu.Lock()
+	close(releaseMemberships)
+	mu.Unlock()
+	<-done
+
+	_, err = client.IDfromIP("192.168.1.3")
+	assert.Error(t, err)
+	_, err = client.IDfromIP("192.168.1.2")
+	assert.Error(t, err)
+	interfacesFound, err := client.InterfacesFromID("x1000")
+	require.NoError(t, err)
+	require.Len(t, interfacesFound, 1)
+	groups, err := client.GroupMembership("x1000")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"compute", "gpu"}, groups)
+	_, err = client.GroupMembership("x1001")
+	assert.Error(t, err)
+
+	// WireGuard IPs assigned by cloud-init survive the refresh
+	wgip, err := client.WGIPfromID("x1000")
+	require.NoError(t, err)
+	assert.Equal(t, "100.97.0.2", wgip)
+
+	// A failed refresh keeps the previous cache
+	mu.Lock()
+	failMemberships = true
+	mu.Unlock()
+	client.PopulateNodes()
+	<-membershipsRequested
+	id, err = client.IDfromIP("192.168.1.1")
+	require.NoError(t, err)
+	assert.Equal(t, "x1000", id)
+}
+
+func TestRefreshCache_Diff(t *testing.T) {
+	var mu sync.Mutex
+	interfaces := `[
+		{"ComponentID": "x1000", "MACAddress": "00:11:22:33:44:55", "IPAddresses": [{"IPAddress": "192.168.1.1"}]},
+		{"ComponentID": "x1001", "MACAddress": "66:77:88:99:AA:BB", "IPAddresses": [{"IPAddress": "192.168.1.3"}]},
+		{"ComponentID": "x1002", "MACAddress": "66:77:88:99:AA:CC", "IPAddresses": [{"IPAddress": "192.168.1.4"}]}
+	]`
+	failMemberships := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ifaces, fail := interfaces, failMemberships
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/hsm/v2/Inventory/EthernetInterfaces/":
+			_, _ = w.Write([]byte(ifaces))
+		case "/hsm/v2/memberships":
+			if fail {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			_, _ = w.Write([]byte(`[{"id": "x1000", "groupLabels": ["compute"]}]`))
+		default:
+			t.Errorf("unexpected request for %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	client := &SMDClient{
+		smdClient:   server.Client(),
+		smdBaseURL:  server.URL,
+		nodesMutex:  &sync.RWMutex{},
+		nodes:       make(map[string]NodeMapping),
+		ipToXname:   make(map[string]string),
+		macToXname:  make(map[string]string),
+		wgipToXname: make(map[string]string),
+	}
+
+	// Every node is new to an empty cache
+	diff, err := client.RefreshCache()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"x1000", "x1001", "x1002"}, diff.Added)
+	assert.Empty(t, diff.Removed)
+	assert.Empty(t, diff.IPsChanged)
+
+	// x1001 is removed, x1002 is renumbered, and x1003 is added
+	mu.Lock()
+	interfaces = `[
+		{"ComponentID": "x1000", "MACAddress": "00:11:22:33:44:55", "IPAddresses": [{"IPAddress": "192.168.1.1"}]},
+		{"ComponentID": "x1002", "MACAddress": "66:77:88:99:AA:CC", "IPAddresses": [{"IPAddress": "192.168.1.14"}]},
+		{"ComponentID": "x1003", "MACAddress": "66:77:88:99:AA:DD", "IPAddresses": [{"IPAddress": "192.168.1.5"}]}
+	]`
+	mu.Unlock()
+	diff, err = client.RefreshCache()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"x1003"}, diff.Added)
+	assert.Equal(t, []string{"x1001"}, diff.Removed)
+	assert.Equal(t, map[string]IPChange{
+		"x1002": {Before: []string{"192.168.1.4"}, After: []string{"192.168.1.14"}},
+	}, diff.IPsChanged)
+
+	nodes := client.CachedNodes()
+	require.Len(t, nodes, 3)
+	assert.Equal(t, "x1000", nodes[0].Xname)
+	assert.Equal(t, []string{"compute"}, nodes[0].Groups)
+	assert.Equal(t, "x1003", nodes[2].Xname)
+	assert.Empty(t, nodes[2].Groups)
+
+	// A failed refresh reports the error and leaves the cache alone
+	mu.Lock()
+	failMemberships = true
+	mu.Unlock()
+	_, err = client.RefreshCache()
+	assert.Error(t, err)
+	assert.Len(t, client.CachedNodes(), 3)
+}
+
+func TestPopulateNodes_AllAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/hsm/v2/Inventory/EthernetInterfaces/":
+			_, _ = w.Write([]byte(`[
+				{"ComponentID": "x1000", "MACAddress": "00:11:22:33:44:55", "Description": "Management",
+				 "IPAddresses": [{"IPAddress": "10.1.0.1"}]},
+				{"ComponentID": "x1000", "MACAddress": "00:11:22:33:44:66", "Description": "Boot NIC",
+				 "IPAddresses": [{"IPAddress": "192.168.1.1"}, {"IPAddress": "192.168.2.1"}]}
+			]`))
+		case "/hsm/v2/memberships":
+			_, _ = w.Write([]byte(`[{"id": "x1000", "groupLabels": ["compute"]}]`))
+		default:
+			t.Errorf("unexpected request for %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	client := &SMDClient{
+		smdClient:   server.Client(),
+		smdBaseURL:  server.URL,
+		nodesMutex:  &sync.RWMutex{},
+		nodes:       make(map[string]NodeMapping),
+		ipToXname:   make(map[string]string),
+		macToXname:  make(map[string]string),
+		wgipToXname: make(map[string]string),
+	}
+	client.PopulateNodes()
+
+	// Every address of every interface identifies the node
+	for _, ip := range []string{"10.1.0.1", "192.168.1.1", "192.168.2.1"} {
+		id, err := client.IDfromIP(ip)
+		require.NoError(t, err, ip)
+		assert.Equal(t, "x1000", id)
+	}
+	interfaces, err := client.InterfacesFromID("x1000")
+	require.NoError(t, err)
+	require.Len(t, interfaces, 2)
+	assert.Equal(t, "192.168.1.1", interfaces[1].IP)
+	assert.Equal(t, []string{"192.168.1.1", "192.168.2.1"}, interfaces[1].Addresses())
+
+	// A node has a single WireGuard IP, whichever interface holds it
+	require.NoError(t, client.AddWGIP("x1000", "100.97.0.2"))
+	require.NoError(t, client.AddWGIP("x1000", "100.97.0.3"))
+	wgip, err := client.WGIPfromID("x1000")
+	require.NoError(t, err)
+	assert.Equal(t, "100.97.0.3", wgip)
+	_, err = client.IDfromIP("100.97.0.2")
+	assert.Error(t, err)
+}
@@ -0,0 +1,122 @@
+package smdclient
+
+import (
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/OpenCHAMI/cloud-init/pkg/cistore"
+	"github.com/rs/zerolog/log"
+)
+
+// BootInfo provides the cluster defaults and per-node instance info that
+// decide which interface a node boots from. cistore.Store implements it.
+type BootInfo interface {
+	GetClusterDefaults() (cistore.ClusterDefaults, error)
+	GetInstanceInfo(nodeName string) (cistore.OpenCHAMIInstanceInfo, error)
+}
+
+// BootSubnet returns the cluster's boot subnet, or nil if it is unset or
+// invalid
+func BootSubnet(clusterDefaults cistore.ClusterDefaults) *net.IPNet {
+	if clusterDefaults.BootSubnet == "" {
+		return nil
+	}
+	_, bootSubnet, err := net.ParseCIDR(clusterDefaults.BootSubnet)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Ignoring invalid boot subnet %s", clusterDefaults.BootSubnet)
+		return nil
+	}
+	return bootSubnet
+}
+
+// AddressIn returns the interface's first address within subnet, or an empty
+// string if it has none or subnet is nil
+func (i NodeInterface) AddressIn(subnet *net.IPNet) string {
+	if subnet == nil {
+		return ""
+	}
+	for _, addr := range i.Addresses() {
+		if ip := net.ParseIP(addr); ip != nil && subnet.Contains(ip) {
+			return addr
+		}
+	}
+	return ""
+}
+
+// BootInterface returns the index of the interface a node boots from, or -1
+// if it has no interfaces. The node's boot-mac instance info is used if it
+// matches an interface. Otherwise the first interface whose description
+// contains the cluster's boot-interface-description is used, then the first
+// interface with an address in the cluster's boot subnet, and finally the
+// first interface.
+func BootInterface(interfaces []NodeInterface, clusterDefaults cistore.ClusterDefaults, instanceInfo cistore.OpenCHAMIInstanceInfo) int {
+	if len(interfaces) == 0 {
+		return -1
+	}
+	if instanceInfo.BootMAC != "" {
+		for i, iface := range interfaces {
+			if strings.EqualFold(iface.MAC, instanceInfo.BootMAC) {
+				return i
+			}
+		}
+		log.Warn().Msgf("Ignoring boot MAC %s, which does not match any of the node's interfaces", instanceInfo.BootMAC)
+	}
+	if description := strings.ToLower(clusterDefaults.BootInterfaceDescription); description != "" {
+		for i, iface := range interfaces {
+			if strings.Contains(strings.ToLower(iface.Desc), description) {
+				return i
+			}
+		}
+	}
+	if bootSubnet := BootSubnet(clusterDefaults); bootSubnet != nil {
+		for i, iface := range interfaces {
+			if iface.AddressIn(bootSubnet) != "" {
+				return i
+			}
+		}
+	}
+	return 0
+}
+
+// SetBootInfo makes the client pick the interface a node boots from with
+// BootInterface, using the cluster defaults and instance info from info.
+// Without it, the first interface is used. It must be called before the
+// client is used.
+func (s *SMDClient) SetBootInfo(info BootInfo) {
+	s.bootInfo = info
+}
+
+// bootSettings returns the cluster defaults and instance info that pick the
+// boot interface of the node with the given ID. Settings that can't be
+// loaded are left empty.
+func (s *SMDClient) bootSettings(id string) (cistore.ClusterDefaults, cistore.OpenCHAMIInstanceInfo) {
+	var clusterDefaults cistore.ClusterDefaults
+	var instanceInfo cistore.OpenCHAMIInstanceInfo
+	if s.bootInfo == nil {
+		return clusterDefaults, instanceInfo
+	}
+	clusterDefaults, err := s.bootInfo.GetClusterDefaults()
+	if err != nil {
+		log.Debug().Err(err).Msg("failed to get cluster defaults")
+	}
+	instanceInfo, err = s.bootInfo.GetInstanceInfo(id)
+	if err != nil {
+		log.Debug().Err(err).Msgf("failed to get instance info of %s", id)
+	}
+	return clusterDefaults, instanceInfo
+}
+
+// bootInterfaceOf returns a copy of the interface the node with the given ID
+// boots from, along with the cluster defaults that picked it
+func (s *SMDClient) bootInterfaceOf(id string) (NodeInterface, cistore.ClusterDefaults, error) {
+	interfaces, err := s.InterfacesFromID(id)
+	if err != nil {
+		return NodeInterface{}, cistore.ClusterDefaults{}, err
+	}
+	if len(interfaces) == 0 {
+		return NodeInterface{}, cistore.ClusterDefaults{}, errors.New("no interfaces found for ID " + id)
+	}
+	clusterDefaults, instanceInfo := s.bootSettings(id)
+	return interfaces[BootInterface(interfaces, clusterDefaults, instanceInfo)], clusterDefaults, nil
+}
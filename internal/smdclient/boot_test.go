@@ -0,0 +1,153 @@
+package smdclient
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/OpenCHAMI/cloud-init/pkg/cistore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testInterfaces = []NodeInterface{
+	{MAC: "00:DE:AD:BE:EF:01", IP: "172.16.0.1", IPs: []string{"172.16.0.1"}, Desc: "High-speed"},
+	{MAC: "00:DE:AD:BE:EF:02", IP: "192.168.0.1", IPs: []string{"192.168.0.1", "10.20.30.1"}, Desc: "Onboard NIC"},
+	{MAC: "00:DE:AD:BE:EF:03", IP: "10.20.30.3", IPs: []string{"10.20.30.3"}, Desc: "Management"},
+}
+
+func TestBootInterface(t *testing.T) {
+	tests := []struct {
+		name            string
+		interfaces      []NodeInterface
+		clusterDefaults cistore.ClusterDefaults
+		instanceInfo    cistore.OpenCHAMIInstanceInfo
+		expected        int
+	}{
+		{"no interfaces", nil, cistore.ClusterDefaults{}, cistore.OpenCHAMIInstanceInfo{}, -1},
+		{"first interface by default", testInterfaces, cistore.ClusterDefaults{}, cistore.OpenCHAMIInstanceInfo{}, 0},
+		{"boot subnet", testInterfaces, cistore.ClusterDefaults{BootSubnet: "10.20.16.0/20"}, cistore.OpenCHAMIInstanceInfo{}, 1},
+		{"invalid boot subnet", testInterfaces, cistore.ClusterDefaults{BootSubnet: "10.20.16.0"}, cistore.OpenCHAMIInstanceInfo{}, 0},
+		{
+			"description before boot subnet", testInterfaces,
+			cistore.ClusterDefaults{BootSubnet: "10.20.16.0/20", BootInterfaceDescription: "management"},
+			cistore.OpenCHAMIInstanceInfo{}, 2,
+		},
+		{
+			"unmatched description", testInterfaces,
+			cistore.ClusterDefaults{BootSubnet: "10.20.16.0/20", BootInterfaceDescription: "boot"},
+			cistore.OpenCHAMIInstanceInfo{}, 1,
+		},
+		{
+			"boot MAC before everything", testInterfaces,
+			cistore.ClusterDefaults{BootSubnet: "10.20.16.0/20", BootInterfaceDescription: "management"},
+			cistore.OpenCHAMIInstanceInfo{BootMAC: "00:de:ad:be:ef:01"}, 0,
+		},
+		{
+			"unmatched boot MAC", testInterfaces,
+			cistore.ClusterDefaults{BootInterfaceDescription: "management"},
+			cistore.OpenCHAMIInstanceInfo{BootMAC: "00:de:ad:be:ef:99"}, 2,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, BootInterface(tc.interfaces, tc.clusterDefaults, tc.instanceInfo))
+		})
+	}
+}
+
+// testBootInfo serves fixed cluster defaults and per-node instance info
+type testBootInfo struct {
+	clusterDefaults cistore.ClusterDefaults
+	instanceInfo    map[string]cistore.OpenCHAMIInstanceInfo
+}
+
+func (b testBootInfo) GetClusterDefaults() (cistore.ClusterDefaults, error) {
+	return b.clusterDefaults, nil
+}
+
+func (b testBootInfo) GetInstanceInfo(nodeName string) (cistore.OpenCHAMIInstanceInfo, error) {
+	return b.instanceInfo[nodeName], nil
+}
+
+func TestBootInterface_Lookups(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/hsm/v2/Inventory/EthernetInterfaces/":
+			_, _ = w.Write([]byte(`[
+				{"ComponentID": "x1000", "MACAddress": "00:11:22:33:44:55", "Description": "High-speed",
+				 "IPAddresses": [{"IPAddress": "172.16.0.1"}]},
+				{"ComponentID": "x1000", "MACAddress": "00:11:22:33:44:66", "Description": "Onboard NIC",
+				 "IPAddresses": [{"IPAddress": "192.168.0.1"}, {"IPAddress": "10.20.30.1"}]},
+				{"ComponentID": "x1000", "MACAddress": "00:11:22:33:44:77", "Description": "Management",
+				 "IPAddresses": [{"IPAddress": "10.1.0.1"}]},
+				{"ComponentID": "x1001", "MACAddress": "00:11:22:33:55:55", "Description": "High-speed",
+				 "IPAddresses": [{"IPAddress": "172.16.0.2"}]},
+				{"ComponentID": "x1001", "MACAddress": "00:11:22:33:55:66", "Description": "Onboard NIC",
+				 "IPAddresses": [{"IPAddress": "10.20.30.2"}]}
+			]`))
+		case "/hsm/v2/memberships":
+			_, _ = w.Write([]byte(`[{"id": "x1000", "groupLabels": ["compute"]}, {"id": "x1001", "groupLabels": ["compute"]}]`))
+		default:
+			t.Errorf("unexpected request for %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	client := &SMDClient{
+		smdClient:   server.Client(),
+		smdBaseURL:  server.URL,
+		nodesMutex:  &sync.RWMutex{},
+		nodes:       make(map[string]NodeMapping),
+		ipToXname:   make(map[string]string),
+		macToXname:  make(map[string]string),
+		wgipToXname: make(map[string]string),
+	}
+	client.SetBootInfo(testBootInfo{
+		clusterDefaults: cistore.ClusterDefaults{BootSubnet: "10.20.16.0/20"},
+		instanceInfo: map[string]cistore.OpenCHAMIInstanceInfo{
+			"x1001": {BootMAC: "00:11:22:33:55:66"},
+		},
+	})
+	client.PopulateNodes()
+
+	// x1000 boots from its second interface, which is in the boot subnet,
+	// using its address in that subnet
+	ip, err := client.IPfromID("x1000")
+	require.NoError(t, err)
+	assert.Equal(t, "10.20.30.1", ip)
+	mac, err := client.MACfromID("x1000")
+	require.NoError(t, err)
+	assert.Equal(t, "00:11:22:33:44:66", mac)
+
+	// x1001 boots from the interface with its boot MAC
+	ip, err = client.IPfromID("x1001")
+	require.NoError(t, err)
+	assert.Equal(t, "10.20.30.2", ip)
+	mac, err = client.MACfromID("x1001")
+	require.NoError(t, err)
+	assert.Equal(t, "00:11:22:33:55:66", mac)
+
+	// The WireGuard IP is kept on the boot interface
+	require.NoError(t, client.AddWGIP("x1000", "100.97.0.2"))
+	interfaces, err := client.InterfacesFromID("x1000")
+	require.NoError(t, err)
+	require.Len(t, interfaces, 3)
+	assert.Empty(t, interfaces[0].WGIP)
+	assert.Equal(t, "100.97.0.2", interfaces[1].WGIP)
+	assert.Empty(t, interfaces[2].WGIP)
+	wgip, err := client.WGIPfromID("x1000")
+	require.NoError(t, err)
+	assert.Equal(t, "100.97.0.2", wgip)
+	id, err := client.IDfromIP("100.97.0.2")
+	require.NoError(t, err)
+	assert.Equal(t, "x1000", id)
+
+	// Without boot info, the first interface is used
+	client.SetBootInfo(nil)
+	ip, err = client.IPfromID("x1000")
+	require.NoError(t, err)
+	assert.Equal(t, "172.16.0.1", ip)
+}
@@ -0,0 +1,150 @@
+package smdclient
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/OpenCHAMI/cloud-init/internal/metrics"
+	"github.com/OpenCHAMI/smd/v2/pkg/sm"
+	"github.com/rs/zerolog/log"
+)
+
+// EnableCacheMissLookup makes IDfromIP query SMD directly for IPs that are
+// missing from the node cache, so that nodes which booted after the last
+// refresh are served without waiting for the next one. Each lookup gives up
+// after timeout. IPs that SMD doesn't know, or that could not be looked up, are
+// not queried again for negativeTTL, so that unknown hosts can't cause a storm
+// of requests to SMD. It must be called before the client is used.
+func (s *SMDClient) EnableCacheMissLookup(timeout, negativeTTL time.Duration) {
+	s.missLookupTimeout = timeout
+	s.negativeCacheTTL = negativeTTL
+}
+
+// lookupIP finds the node with an IP address that missed the cache in SMD and
+// adds it to the cache
+func (s *SMDClient) lookupIP(ipaddr string) (string, error) {
+	key := ipKey(ipaddr)
+	if s.isUnknownIP(key) {
+		metrics.SMDCacheMissLookups.WithLabelValues("negative-cached").Inc()
+		return "", fmt.Errorf("IP address %s not found for an xname in nodes", ipaddr)
+	}
+
+	// Concurrent requests from the same IP share a single lookup
+	xname, err, _ := s.missLookups.Do(key, func() (interface{}, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), s.missLookupTimeout)
+		defer cancel()
+
+		node, err := s.fetchNodeByIP(ctx, ipaddr)
+		if err != nil {
+			metrics.SMDCacheMissLookups.WithLabelValues("error").Inc()
+			s.rememberUnknownIP(key)
+			return "", fmt.Errorf("IP address %s not found for an xname in nodes: failed to look it up in SMD: %w", ipaddr, err)
+		}
+		if node == nil {
+			metrics.SMDCacheMissLookups.WithLabelValues("unknown").Inc()
+			s.rememberUnknownIP(key)
+			return "", fmt.Errorf("IP address %s not found for an xname in nodes", ipaddr)
+		}
+		metrics.SMDCacheMissLookups.WithLabelValues("found").Inc()
+		log.Info().Msgf("Added %s to the SMD cache after a cache miss for IP %s", node.Xname, ipaddr)
+		s.storeNode(*node)
+		return node.Xname, nil
+	})
+	return xname.(string), err
+}
+
+// fetchNodeByIP returns the node that has an IP address in SMD, with all of
+// its interfaces and group memberships, or nil if SMD doesn't know the IP
+func (s *SMDClient) fetchNodeByIP(ctx context.Context, ipaddr string) (*NodeMapping, error) {
+	var matches []sm.CompEthInterfaceV2
+	if err := s.getSMDContext(ctx, "/hsm/v2/Inventory/EthernetInterfaces?IPAddress="+url.QueryEscape(ipaddr), &matches); err != nil {
+		return nil, fmt.Errorf("failed to get Ethernet interfaces: %w", err)
+	}
+	xname := ""
+	for _, match := range matches {
+		if match.CompID != "" {
+			xname = match.CompID
+			break
+		}
+	}
+	if xname == "" {
+		return nil, nil
+	}
+
+	var ethIfaceArray []sm.CompEthInterfaceV2
+	if err := s.getSMDContext(ctx, "/hsm/v2/Inventory/EthernetInterfaces?ComponentID="+url.QueryEscape(xname), &ethIfaceArray); err != nil {
+		return nil, fmt.Errorf("failed to get Ethernet interfaces of %s: %w", xname, err)
+	}
+	var membership sm.Membership
+	if err := s.getSMDContext(ctx, "/hsm/v2/memberships/"+url.PathEscape(xname), &membership); err != nil {
+		return nil, fmt.Errorf("failed to get group membership of %s: %w", xname, err)
+	}
+
+	node := &NodeMapping{Xname: xname, Groups: []string{}}
+	for _, ep := range ethIfaceArray {
+		node.Interfaces = append(node.Interfaces, newNodeInterface(ep))
+	}
+	if membership.GroupLabels != nil {
+		node.Groups = membership.GroupLabels
+	}
+	return node, nil
+}
+
+// storeNode adds a node to the cache, or replaces the cached node with the
+// same xname, keeping the WireGuard IPs of interfaces that still exist
+func (s *SMDClient) storeNode(node NodeMapping) {
+	s.nodesMutex.Lock()
+	defer s.nodesMutex.Unlock()
+
+	if previous, found := s.nodes[node.Xname]; found {
+		for _, previousIface := range previous.Interfaces {
+			for i, iface := range node.Interfaces {
+				if previousIface.WGIP != "" && strings.EqualFold(iface.MAC, previousIface.MAC) {
+					node.Interfaces[i].WGIP = previousIface.WGIP
+				}
+			}
+			s.unindexInterfaceLocked(node.Xname, previousIface)
+		}
+	}
+	for _, iface := range node.Interfaces {
+		s.indexInterfaceLocked(node.Xname, iface)
+	}
+	s.nodes[node.Xname] = node
+	metrics.SMDCacheNodes.Set(float64(len(s.nodes)))
+}
+
+// isUnknownIP reports whether an IP was recently looked up in SMD and not found
+func (s *SMDClient) isUnknownIP(key string) bool {
+	s.unknownIPsMutex.Lock()
+	defer s.unknownIPsMutex.Unlock()
+	expires, found := s.unknownIPs[key]
+	return found && time.Now().Before(expires)
+}
+
+// rememberUnknownIP stops an IP from being looked up in SMD again until the
+// negative cache TTL has passed, and forgets IPs whose TTL has passed
+func (s *SMDClient) rememberUnknownIP(key string) {
+	s.unknownIPsMutex.Lock()
+	defer s.unknownIPsMutex.Unlock()
+	now := time.Now()
+	if s.unknownIPs == nil {
+		s.unknownIPs = make(map[string]time.Time)
+	}
+	for ip, expires := range s.unknownIPs {
+		if !now.Before(expires) {
+			delete(s.unknownIPs, ip)
+		}
+	}
+	s.unknownIPs[key] = now.Add(s.negativeCacheTTL)
+}
+
+// forgetUnknownIP allows an IP to be looked up in SMD again, e.g. once SMD
+// has reported an interface with it
+func (s *SMDClient) forgetUnknownIP(key string) {
+	s.unknownIPsMutex.Lock()
+	defer s.unknownIPsMutex.Unlock()
+	delete(s.unknownIPs, key)
+}
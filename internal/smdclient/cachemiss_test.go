@@ -0,0 +1,136 @@
+package smdclient
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIDfromIP_CacheMiss(t *testing.T) {
+	var requests atomic.Int32
+	var delay atomic.Int64
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		time.Sleep(time.Duration(delay.Load()))
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/hsm/v2/Inventory/EthernetInterfaces":
+			if r.URL.Query().Get("IPAddress") == "192.168.1.5" || r.URL.Query().Get("ComponentID") == "x1005" {
+				_, _ = w.Write([]byte(`[
+					{"ComponentID": "x1005", "MACAddress": "00:11:22:33:44:05", "IPAddresses": [{"IPAddress": "192.168.1.5"}]},
+					{"ComponentID": "x1005", "MACAddress": "00:11:22:33:44:06", "IPAddresses": [{"IPAddress": "192.168.2.5"}]}
+				]`))
+				return
+			}
+			_, _ = w.Write([]byte(`[]`))
+		case "/hsm/v2/memberships/x1005":
+			_, _ = w.Write([]byte(`{"id": "x1005", "groupLabels": ["compute"]}`))
+		default:
+			t.Errorf("unexpected request for %s", r.URL.Path)
+		}
+	})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	client := &SMDClient{
+		smdClient:   server.Client(),
+		smdBaseURL:  server.URL,
+		nodesMutex:  &sync.RWMutex{},
+		nodes:       make(map[string]NodeMapping),
+		ipToXname:   make(map[string]string),
+		macToXname:  make(map[string]string),
+		wgipToXname: make(map[string]string),
+	}
+
+	// Without cache-miss lookups, SMD is not queried
+	_, err := client.IDfromIP("192.168.1.5")
+	assert.Error(t, err)
+	assert.Equal(t, int32(0), requests.Load())
+
+	client.EnableCacheMissLookup(time.Second, time.Minute)
+
+	// A node that booted after the last refresh is found and cached
+	id, err := client.IDfromIP("192.168.1.5")
+	require.NoError(t, err)
+	assert.Equal(t, "x1005", id)
+	assert.Equal(t, int32(3), requests.Load())
+
+	id, err = client.IDfromIP("192.168.2.5")
+	require.NoError(t, err)
+	assert.Equal(t, "x1005", id)
+	id, err = client.IDfromMAC("00:11:22:33:44:06")
+	require.NoError(t, err)
+	assert.Equal(t, "x1005", id)
+	groups, err := client.GroupMembership("x1005")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"compute"}, groups)
+	assert.Equal(t, int32(3), requests.Load())
+
+	// Unknown IPs are only looked up once within the negative cache TTL
+	_, err = client.IDfromIP("192.168.1.9")
+	assert.Error(t, err)
+	assert.Equal(t, int32(4), requests.Load())
+	_, err = client.IDfromIP("192.168.1.9")
+	assert.Error(t, err)
+	assert.Equal(t, int32(4), requests.Load())
+
+	// Lookups that time out fail quickly and are negatively cached as well
+	client.EnableCacheMissLookup(20*time.Millisecond, time.Minute)
+	delay.Store(int64(200 * time.Millisecond))
+	start := time.Now()
+	_, err = client.IDfromIP("192.168.1.10")
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), 150*time.Millisecond)
+	delay.Store(0)
+	before := requests.Load()
+	_, err = client.IDfromIP("192.168.1.10")
+	assert.Error(t, err)
+	assert.Equal(t, before, requests.Load())
+
+	// Unknown IPs are looked up again once their TTL has passed
+	client.EnableCacheMissLookup(time.Second, 0)
+	_, err = client.IDfromIP("192.168.1.11")
+	assert.Error(t, err)
+	_, err = client.IDfromIP("192.168.1.11")
+	assert.Error(t, err)
+	assert.Equal(t, before+2, requests.Load())
+}
+
+func TestStoreNode_KeepsWGIPs(t *testing.T) {
+	client := &SMDClient{
+		nodesMutex:  &sync.RWMutex{},
+		nodes:       make(map[string]NodeMapping),
+		ipToXname:   make(map[string]string),
+		macToXname:  make(map[string]string),
+		wgipToXname: make(map[string]string),
+	}
+	client.storeNode(NodeMapping{Xname: "x1000", Interfaces: []NodeInterface{
+		{MAC: "00:11:22:33:44:55", IP: "192.168.1.1"},
+		{MAC: "00:11:22:33:44:66", IP: "192.168.1.2"},
+	}})
+	require.NoError(t, client.AddWGIP("x1000", "100.97.0.2"))
+
+	// The interface with the WireGuard IP is renumbered and the other is removed
+	client.storeNode(NodeMapping{Xname: "x1000", Interfaces: []NodeInterface{
+		{MAC: "00:11:22:33:44:55", IP: "192.168.1.11"},
+	}})
+	wgip, err := client.WGIPfromID("x1000")
+	require.NoError(t, err)
+	assert.Equal(t, "100.97.0.2", wgip)
+	id, err := client.IDfromIP("100.97.0.2")
+	require.NoError(t, err)
+	assert.Equal(t, "x1000", id)
+	id, err = client.IDfromIP("192.168.1.11")
+	require.NoError(t, err)
+	assert.Equal(t, "x1000", id)
+	_, err = client.IDfromIP("192.168.1.1")
+	assert.Error(t, err)
+	_, err = client.IDfromMAC("00:11:22:33:44:66")
+	assert.Error(t, err)
+}
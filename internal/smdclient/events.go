@@ -0,0 +1,219 @@
+package smdclient
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/OpenCHAMI/cloud-init/internal/metrics"
+	"github.com/OpenCHAMI/smd/v2/pkg/sm"
+	"github.com/rs/zerolog/log"
+)
+
+// EventType identifies the kind of SMD resource an Event changes
+type EventType string
+
+const (
+	EventComponent  EventType = "component"
+	EventInterface  EventType = "interface"
+	EventMembership EventType = "membership"
+)
+
+// EventAction identifies whether an Event creates or changes a resource, or
+// removes it
+type EventAction string
+
+const (
+	EventUpdate EventAction = "update"
+	EventDelete EventAction = "delete"
+)
+
+// Event describes a change to SMD's inventory, as sent by SMD or an event
+// bridge so that the node cache can be kept fresh without polling
+type Event struct {
+	Type   EventType   `json:"type" yaml:"type" enums:"component,interface,membership"`
+	Action EventAction `json:"action" yaml:"action" enums:"update,delete"`
+	// ID is the xname of the component the change applies to. For interface
+	// events it defaults to the interface's ComponentID.
+	ID string `json:"id,omitempty" yaml:"id,omitempty" example:"x3000c0s1b0n0"`
+	// Interface is the Ethernet interface as SMD reports it. Only its
+	// MACAddress is needed to delete it.
+	Interface *sm.CompEthInterfaceV2 `json:"interface,omitempty" yaml:"interface,omitempty"`
+	// Groups are the component's group labels after a membership update
+	Groups []string `json:"groups,omitempty" yaml:"groups,omitempty"`
+}
+
+// validate checks that an event has everything needed to apply it, filling
+// in its ID from its interface if needed
+func (e *Event) validate() error {
+	if e.Action != EventUpdate && e.Action != EventDelete {
+		return fmt.Errorf("invalid action %q: must be %q or %q", e.Action, EventUpdate, EventDelete)
+	}
+	switch e.Type {
+	case EventComponent, EventMembership:
+		if e.ID == "" {
+			return fmt.Errorf("%s event has no id", e.Type)
+		}
+	case EventInterface:
+		if e.Interface == nil || e.Interface.MACAddr == "" {
+			return fmt.Errorf("interface event has no interface MAC address")
+		}
+		if e.ID == "" {
+			e.ID = e.Interface.CompID
+		}
+		if e.ID == "" && e.Action == EventUpdate {
+			return fmt.Errorf("interface event for %s has no id or ComponentID", e.Interface.MACAddr)
+		}
+	default:
+		return fmt.Errorf("invalid type %q: must be %q, %q, or %q", e.Type, EventComponent, EventInterface, EventMembership)
+	}
+	return nil
+}
+
+// ApplyEvents applies changes to SMD's inventory to the node cache as they
+// arrive. The events are validated before any is applied, so either all of
+// them are applied or none are. Events applied while the cache is being
+// refreshed are applied again to the refreshed cache, so that a refresh that
+// started before them doesn't undo them.
+func (s *SMDClient) ApplyEvents(events []Event) error {
+	for i := range events {
+		if err := events[i].validate(); err != nil {
+			return fmt.Errorf("event %d: %w", i, err)
+		}
+	}
+
+	s.nodesMutex.Lock()
+	defer s.nodesMutex.Unlock()
+	for _, event := range events {
+		s.applyEventLocked(event)
+		metrics.SMDEvents.WithLabelValues(string(event.Type), string(event.Action)).Inc()
+		if s.refreshesInFlight > 0 {
+			s.eventsDuringRefresh = append(s.eventsDuringRefresh, event)
+		}
+	}
+	metrics.SMDCacheNodes.Set(float64(len(s.nodes)))
+	return nil
+}
+
+// applyEventLocked applies a validated event. nodesMutex must be held.
+func (s *SMDClient) applyEventLocked(event Event) {
+	log.Debug().Msgf("Applying SMD %s %s event for %s", event.Type, event.Action, event.ID)
+	switch event.Type {
+	case EventComponent:
+		if event.Action == EventDelete {
+			s.removeNodeLocked(event.ID)
+		} else {
+			s.ensureNodeLocked(event.ID)
+		}
+	case EventInterface:
+		mac := strings.ToLower(event.Interface.MACAddr)
+		// The interface may have moved from another node
+		if owner, found := s.macToXname[mac]; found && (owner != event.ID || event.Action == EventDelete) {
+			s.removeInterfaceLocked(owner, mac)
+		}
+		if event.Action == EventDelete {
+			if event.ID != "" {
+				s.removeInterfaceLocked(event.ID, mac)
+			}
+			return
+		}
+		iface := newNodeInterface(*event.Interface)
+		node := s.ensureNodeLocked(event.ID)
+		replaced := false
+		for i, existing := range node.Interfaces {
+			if strings.EqualFold(existing.MAC, iface.MAC) {
+				iface.WGIP = existing.WGIP
+				s.unindexInterfaceLocked(event.ID, existing)
+				node.Interfaces[i] = iface
+				replaced = true
+			}
+		}
+		if !replaced {
+			node.Interfaces = append(node.Interfaces, iface)
+		}
+		s.indexInterfaceLocked(event.ID, iface)
+		s.nodes[event.ID] = node
+		for _, ip := range iface.Addresses() {
+			s.forgetUnknownIP(ipKey(ip))
+		}
+	case EventMembership:
+		node := s.ensureNodeLocked(event.ID)
+		node.Groups = []string{}
+		if event.Action == EventUpdate && event.Groups != nil {
+			node.Groups = event.Groups
+		}
+		s.nodes[event.ID] = node
+	}
+}
+
+// ensureNodeLocked returns the cached node with an xname, adding an empty one
+// if it isn't cached. nodesMutex must be held.
+func (s *SMDClient) ensureNodeLocked(xname string) NodeMapping {
+	node, found := s.nodes[xname]
+	if !found {
+		node = NodeMapping{Xname: xname, Groups: []string{}}
+		s.nodes[xname] = node
+	}
+	return node
+}
+
+// removeNodeLocked removes a node and its interfaces from the cache.
+// nodesMutex must be held.
+func (s *SMDClient) removeNodeLocked(xname string) {
+	node, found := s.nodes[xname]
+	if !found {
+		return
+	}
+	for _, iface := range node.Interfaces {
+		s.unindexInterfaceLocked(xname, iface)
+	}
+	delete(s.nodes, xname)
+}
+
+// removeInterfaceLocked removes the interface with a MAC address from a
+// cached node. nodesMutex must be held.
+func (s *SMDClient) removeInterfaceLocked(xname, mac string) {
+	node, found := s.nodes[xname]
+	if !found {
+		return
+	}
+	interfaces := make([]NodeInterface, 0, len(node.Interfaces))
+	for _, iface := range node.Interfaces {
+		if strings.EqualFold(iface.MAC, mac) {
+			s.unindexInterfaceLocked(xname, iface)
+			continue
+		}
+		interfaces = append(interfaces, iface)
+	}
+	node.Interfaces = interfaces
+	s.nodes[xname] = node
+}
+
+// indexInterfaceLocked adds an interface of a node to the reverse indexes.
+// nodesMutex must be held.
+func (s *SMDClient) indexInterfaceLocked(xname string, iface NodeInterface) {
+	for _, ip := range iface.Addresses() {
+		s.ipToXname[ipKey(ip)] = xname
+	}
+	if iface.MAC != "" {
+		s.macToXname[strings.ToLower(iface.MAC)] = xname
+	}
+	if iface.WGIP != "" {
+		s.wgipToXname[ipKey(iface.WGIP)] = xname
+	}
+}
+
+// unindexInterfaceLocked removes an interface of a node from the reverse
+// indexes, leaving entries that now belong to other nodes. nodesMutex must be
+// held.
+func (s *SMDClient) unindexInterfaceLocked(xname string, iface NodeInterface) {
+	unindex := func(index map[string]string, key string) {
+		if key != "" && index[key] == xname {
+			delete(index, key)
+		}
+	}
+	for _, ip := range iface.Addresses() {
+		unindex(s.ipToXname, ipKey(ip))
+	}
+	unindex(s.macToXname, strings.ToLower(iface.MAC))
+	unindex(s.wgipToXname, ipKey(iface.WGIP))
+}
@@ -0,0 +1,196 @@
+package smdclient
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/OpenCHAMI/smd/v2/pkg/sm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newEventsTestClient returns a client whose cache was populated from an SMD
+// stand-in, and a function that blocks or releases the stand-in's membership
+// responses
+func newEventsTestClient(t *testing.T) (*SMDClient, func(block bool)) {
+	var mu sync.Mutex
+	release := make(chan struct{})
+	close(release)
+	requested := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/hsm/v2/Inventory/EthernetInterfaces/":
+			_, _ = w.Write([]byte(`[
+				{"ComponentID": "x1000", "MACAddress": "00:11:22:33:44:55", "IPAddresses": [{"IPAddress": "192.168.1.1"}]},
+				{"ComponentID": "x1001", "MACAddress": "66:77:88:99:AA:BB", "IPAddresses": [{"IPAddress": "192.168.1.3"}]}
+			]`))
+		case "/hsm/v2/memberships":
+			mu.Lock()
+			wait := release
+			mu.Unlock()
+			select {
+			case requested <- struct{}{}:
+			default:
+			}
+			<-wait
+			_, _ = w.Write([]byte(`[{"id": "x1000", "groupLabels": ["compute"]}, {"id": "x1001", "groupLabels": ["io"]}]`))
+		default:
+			t.Errorf("unexpected request for %s", r.URL.Path)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	client := &SMDClient{
+		smdClient:   server.Client(),
+		smdBaseURL:  server.URL,
+		nodesMutex:  &sync.RWMutex{},
+		nodes:       make(map[string]NodeMapping),
+		ipToXname:   make(map[string]string),
+		macToXname:  make(map[string]string),
+		wgipToXname: make(map[string]string),
+	}
+	_, err := client.RefreshCache()
+	require.NoError(t, err)
+	<-requested
+
+	block := func(block bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		if block {
+			release = make(chan struct{})
+		} else {
+			close(release)
+		}
+	}
+	return client, block
+}
+
+func TestApplyEvents(t *testing.T) {
+	client, _ := newEventsTestClient(t)
+	require.NoError(t, client.AddWGIP("x1000", "100.97.0.2"))
+
+	// A new node appears with an interface and groups
+	require.NoError(t, client.ApplyEvents([]Event{
+		{Type: EventComponent, Action: EventUpdate, ID: "x1002"},
+		{Type: EventInterface, Action: EventUpdate, Interface: &sm.CompEthInterfaceV2{
+			CompID: "x1002", MACAddr: "66:77:88:99:AA:CC", IPAddrs: []sm.IPAddressMapping{{IPAddr: "192.168.1.4"}},
+		}},
+		{Type: EventMembership, Action: EventUpdate, ID: "x1002", Groups: []string{"compute"}},
+	}))
+	id, err := client.IDfromIP("192.168.1.4")
+	require.NoError(t, err)
+	assert.Equal(t, "x1002", id)
+	id, err = client.IDfromMAC("66:77:88:99:aa:cc")
+	require.NoError(t, err)
+	assert.Equal(t, "x1002", id)
+	groups, err := client.GroupMembership("x1002")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"compute"}, groups)
+
+	// An interface is renumbered, keeping its WireGuard IP
+	require.NoError(t, client.ApplyEvents([]Event{{Type: EventInterface, Action: EventUpdate, Interface: &sm.CompEthInterfaceV2{
+		CompID: "x1000", MACAddr: "00:11:22:33:44:55", IPAddrs: []sm.IPAddressMapping{{IPAddr: "192.168.1.11"}},
+	}}}))
+	_, err = client.IDfromIP("192.168.1.1")
+	assert.Error(t, err)
+	id, err = client.IDfromIP("192.168.1.11")
+	require.NoError(t, err)
+	assert.Equal(t, "x1000", id)
+	wgip, err := client.WGIPfromID("x1000")
+	require.NoError(t, err)
+	assert.Equal(t, "100.97.0.2", wgip)
+
+	// An interface moves to another node
+	require.NoError(t, client.ApplyEvents([]Event{{Type: EventInterface, Action: EventUpdate, Interface: &sm.CompEthInterfaceV2{
+		CompID: "x1002", MACAddr: "66:77:88:99:AA:BB", IPAddrs: []sm.IPAddressMapping{{IPAddr: "192.168.1.3"}},
+	}}}))
+	id, err = client.IDfromIP("192.168.1.3")
+	require.NoError(t, err)
+	assert.Equal(t, "x1002", id)
+	interfaces, err := client.InterfacesFromID("x1001")
+	require.NoError(t, err)
+	assert.Empty(t, interfaces)
+
+	// Interfaces are deleted by MAC alone, and memberships are cleared
+	require.NoError(t, client.ApplyEvents([]Event{
+		{Type: EventInterface, Action: EventDelete, Interface: &sm.CompEthInterfaceV2{MACAddr: "66:77:88:99:aa:bb"}},
+		{Type: EventMembership, Action: EventDelete, ID: "x1002"},
+	}))
+	_, err = client.IDfromIP("192.168.1.3")
+	assert.Error(t, err)
+	groups, err = client.GroupMembership("x1002")
+	require.NoError(t, err)
+	assert.Empty(t, groups)
+
+	// Deleting a component removes it from every index
+	require.NoError(t, client.ApplyEvents([]Event{{Type: EventComponent, Action: EventDelete, ID: "x1000"}}))
+	_, err = client.IDfromIP("192.168.1.11")
+	assert.Error(t, err)
+	_, err = client.IDfromIP("100.97.0.2")
+	assert.Error(t, err)
+	_, err = client.IDfromMAC("00:11:22:33:44:55")
+	assert.Error(t, err)
+	_, err = client.GroupMembership("x1000")
+	assert.Error(t, err)
+}
+
+func TestApplyEvents_Invalid(t *testing.T) {
+	client, _ := newEventsTestClient(t)
+
+	testCases := []struct {
+		name  string
+		event Event
+	}{
+		{"unknown type", Event{Type: "node", Action: EventUpdate, ID: "x1000"}},
+		{"unknown action", Event{Type: EventComponent, Action: "create", ID: "x1000"}},
+		{"component without id", Event{Type: EventComponent, Action: EventDelete}},
+		{"membership without id", Event{Type: EventMembership, Action: EventUpdate, Groups: []string{"io"}}},
+		{"interface without MAC", Event{Type: EventInterface, Action: EventUpdate, ID: "x1000", Interface: &sm.CompEthInterfaceV2{}}},
+		{"interface update without node", Event{Type: EventInterface, Action: EventUpdate, Interface: &sm.CompEthInterfaceV2{MACAddr: "00:11:22:33:44:77"}}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Valid events before an invalid one are not applied either
+			err := client.ApplyEvents([]Event{
+				{Type: EventComponent, Action: EventDelete, ID: "x1001"},
+				tc.event,
+			})
+			assert.ErrorContains(t, err, "event 1")
+			_, err = client.IDfromIP("192.168.1.3")
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestApplyEvents_DuringRefresh(t *testing.T) {
+	client, block := newEventsTestClient(t)
+
+	// An event arrives while a refresh is waiting on SMD, whose response
+	// predates the event
+	block(true)
+	done := make(chan CacheDiff)
+	go func() {
+		diff, err := client.RefreshCache()
+		assert.NoError(t, err)
+		done <- diff
+	}()
+	require.Eventually(t, func() bool {
+		client.nodesMutex.RLock()
+		defer client.nodesMutex.RUnlock()
+		return client.refreshesInFlight > 0
+	}, time.Second, time.Millisecond)
+	require.NoError(t, client.ApplyEvents([]Event{{Type: EventComponent, Action: EventDelete, ID: "x1001"}}))
+	block(false)
+	diff := <-done
+
+	// The refresh doesn't bring the deleted node back
+	_, err := client.IDfromIP("192.168.1.3")
+	assert.Error(t, err)
+	assert.Empty(t, diff.Added)
+	assert.Len(t, client.CachedNodes(), 1)
+	assert.Nil(t, client.eventsDuringRefresh)
+}
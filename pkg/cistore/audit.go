@@ -0,0 +1,85 @@
+package cistore
+
+import (
+	"encoding/json"
+	"reflect"
+	"time"
+)
+
+// AuditEntry records a single mutating call to the admin API
+type AuditEntry struct {
+	ID        int64                  `json:"id" yaml:"id" description:"Sequence number of the entry, assigned by the store"`
+	Time      time.Time              `json:"time" yaml:"time"`
+	Subject   string                 `json:"subject,omitempty" yaml:"subject,omitempty" description:"JWT subject of the caller, when authentication is enabled"`
+	SourceIP  string                 `json:"source-ip" yaml:"source-ip"`
+	RequestID string                 `json:"request-id,omitempty" yaml:"request-id,omitempty"`
+	Method    string                 `json:"method" yaml:"method" example:"PUT"`
+	Path      string                 `json:"path" yaml:"path" example:"/admin/groups/compute"`
+	Status    int                    `json:"status" yaml:"status" example:"201" description:"HTTP status returned to the caller"`
+	Entity    EntityType             `json:"entity" yaml:"entity" example:"group"`
+	Name      string                 `json:"name,omitempty" yaml:"name,omitempty" example:"compute"`
+	Before    interface{}            `json:"before,omitempty" yaml:"before,omitempty" description:"Entity before the call, if it existed"`
+	After     interface{}            `json:"after,omitempty" yaml:"after,omitempty" description:"Entity after the call, if it exists"`
+	Diff      map[string]AuditChange `json:"diff,omitempty" yaml:"diff,omitempty" description:"Top-level fields changed by the call"`
+}
+
+// AuditChange holds the before and after values of a changed field
+type AuditChange struct {
+	Before interface{} `json:"before,omitempty" yaml:"before,omitempty"`
+	After  interface{} `json:"after,omitempty" yaml:"after,omitempty"`
+}
+
+// AuditFilter selects audit entries. Zero-valued fields match everything.
+type AuditFilter struct {
+	Entity  EntityType
+	Name    string
+	Subject string
+	Since   time.Time
+	// Limit caps the number of entries returned, newest first
+	Limit int
+}
+
+// Matches reports whether an entry is selected by the filter, ignoring Limit
+func (f AuditFilter) Matches(entry AuditEntry) bool {
+	return (f.Entity == "" || f.Entity == entry.Entity) &&
+		(f.Name == "" || f.Name == entry.Name) &&
+		(f.Subject == "" || f.Subject == entry.Subject) &&
+		(f.Since.IsZero() || !entry.Time.Before(f.Since))
+}
+
+// AuditDiff compares the JSON representations of two values field by field
+// and returns the top-level fields that differ. Either value may be nil.
+func AuditDiff(before, after interface{}) map[string]AuditChange {
+	beforeFields := jsonFields(before)
+	afterFields := jsonFields(after)
+
+	diff := make(map[string]AuditChange)
+	for k, v := range beforeFields {
+		if !reflect.DeepEqual(v, afterFields[k]) {
+			diff[k] = AuditChange{Before: v, After: afterFields[k]}
+		}
+	}
+	for k, v := range afterFields {
+		if _, ok := beforeFields[k]; !ok {
+			diff[k] = AuditChange{After: v}
+		}
+	}
+	return diff
+}
+
+// jsonFields returns the top-level JSON fields of v, or nil if v does not
+// encode to a JSON object
+func jsonFields(v interface{}) map[string]interface{} {
+	if v == nil {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil
+	}
+	return fields
+}
@@ -0,0 +1,23 @@
+package cistore
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditDiff(t *testing.T) {
+	before := GroupData{Name: "compute", Description: "old"}
+	after := GroupData{Name: "compute", Description: "new", PinnedVersion: "2"}
+
+	diff := AuditDiff(before, after)
+	assert.Len(t, diff, 2)
+	assert.Equal(t, AuditChange{Before: "old", After: "new"}, diff["description"])
+	assert.Equal(t, AuditChange{After: "2"}, diff["pinned-version"])
+
+	// Creations and deletions list every field
+	assert.Contains(t, AuditDiff(nil, after), "name")
+	assert.Equal(t, AuditChange{Before: "compute"}, AuditDiff(before, nil)["name"])
+
+	assert.Empty(t, AuditDiff(before, before))
+}
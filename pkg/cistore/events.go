@@ -0,0 +1,98 @@
+package cistore
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// EntityType identifies the kind of entity a ChangeEvent refers to
+type EntityType string
+
+const (
+	EntityGroup           EntityType = "group"
+	EntityInstance        EntityType = "instance"
+	EntityClusterDefaults EntityType = "cluster-defaults"
+	// EntityWireGuardPeer and EntitySMDCache only appear in the audit trail,
+	// as changes to WireGuard state and the SMD cache are not published
+	EntityWireGuardPeer EntityType = "wireguard-peer"
+	EntitySMDCache      EntityType = "smd-cache"
+)
+
+// Operation identifies the kind of change a ChangeEvent describes
+type Operation string
+
+const (
+	OperationCreate Operation = "create"
+	OperationUpdate Operation = "update"
+	OperationDelete Operation = "delete"
+)
+
+// ChangeEvent describes a change made to the store. Old and New hold a
+// GroupData, OpenCHAMIInstanceInfo, or ClusterDefaults depending on Entity.
+// Old is nil for a create and New is nil for a delete.
+type ChangeEvent struct {
+	Entity    EntityType  `json:"entity"`
+	Name      string      `json:"name,omitempty"`
+	Operation Operation   `json:"operation"`
+	Old       interface{} `json:"old,omitempty"`
+	New       interface{} `json:"new,omitempty"`
+	Time      time.Time   `json:"time"`
+}
+
+// subscriberBuffer is the number of events buffered for each subscriber
+const subscriberBuffer = 64
+
+// Notifier fans change events out to subscribers. Stores embed it to
+// implement Store.Subscribe and call Publish after each successful change.
+// The zero value is ready to use.
+type Notifier struct {
+	mu          sync.Mutex
+	subscribers map[chan ChangeEvent]struct{}
+}
+
+// Subscribe returns a channel receiving every change published from now on.
+// The channel is closed once ctx is done. Publishing never blocks the store,
+// so events are dropped for a subscriber that falls too far behind.
+func (n *Notifier) Subscribe(ctx context.Context) <-chan ChangeEvent {
+	ch := make(chan ChangeEvent, subscriberBuffer)
+	n.mu.Lock()
+	if n.subscribers == nil {
+		n.subscribers = make(map[chan ChangeEvent]struct{})
+	}
+	n.subscribers[ch] = struct{}{}
+	n.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		n.mu.Lock()
+		delete(n.subscribers, ch)
+		close(ch)
+		n.mu.Unlock()
+	}()
+	return ch
+}
+
+// Publish sends a change event to all current subscribers
+func (n *Notifier) Publish(entity EntityType, name string, op Operation, oldValue, newValue interface{}) {
+	event := ChangeEvent{
+		Entity:    entity,
+		Name:      name,
+		Operation: op,
+		Old:       oldValue,
+		New:       newValue,
+		Time:      time.Now().UTC(),
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for ch := range n.subscribers {
+		select {
+		case ch <- event:
+		default:
+			log.Warn().Msgf("dropping %s event for %s %s: subscriber is not keeping up", op, entity, name)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package cistore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotifier(t *testing.T) {
+	var n Notifier
+
+	// Publishing without subscribers is a no-op
+	n.Publish(EntityGroup, "compute", OperationCreate, nil, GroupData{Name: "compute"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := n.Subscribe(ctx)
+	second := n.Subscribe(context.Background())
+
+	n.Publish(EntityGroup, "compute", OperationDelete, GroupData{Name: "compute"}, nil)
+	for _, ch := range []<-chan ChangeEvent{first, second} {
+		event := <-ch
+		assert.Equal(t, EntityGroup, event.Entity)
+		assert.Equal(t, "compute", event.Name)
+		assert.Equal(t, OperationDelete, event.Operation)
+		assert.Nil(t, event.New)
+		assert.False(t, event.Time.IsZero())
+	}
+
+	cancel()
+	_, open := <-first
+	assert.False(t, open)
+
+	// A subscriber that is not reading never blocks publishing
+	for i := 0; i < subscriberBuffer*2; i++ {
+		n.Publish(EntityInstance, "x3000c0s1b0n0", OperationUpdate, nil, nil)
+	}
+	assert.Len(t, second, subscriberBuffer)
+}
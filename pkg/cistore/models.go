@@ -8,11 +8,13 @@ import (
 )
 
 type GroupData struct {
-	Name        string                 `json:"name" yaml:"name" example:"compute" description:"Group name"`
-	Description string                 `json:"description,omitempty" yaml:"description,omitempty" example:"The compute group" description:"A short description of the group"`
-	Data        map[string]interface{} `json:"meta-data,omitempty" yaml:"meta-data,omitempty" description:"json map of a string (key) to a struct (value) representing group meta-data"`
-	File        CloudConfigFile        `json:"file,omitempty" yaml:"file,omitempty" description:"Cloud-Init configuration for group"`
-	Versions    map[string]string      `json:"versions,omitempty" yaml:"versions,omitempty" description:"Map of group versions"`
+	Name          string                 `json:"name" yaml:"name" example:"compute" description:"Group name"`
+	Description   string                 `json:"description,omitempty" yaml:"description,omitempty" example:"The compute group" description:"A short description of the group"`
+	Data          map[string]interface{} `json:"meta-data,omitempty" yaml:"meta-data,omitempty" description:"json map of a string (key) to a struct (value) representing group meta-data"`
+	File          CloudConfigFile        `json:"file,omitempty" yaml:"file,omitempty" description:"Cloud-Init configuration for group"`
+	Versions      map[string]string      `json:"versions,omitempty" yaml:"versions,omitempty" description:"Map of revision numbers to the time each previous version of the group was recorded; maintained by the server"`
+	Network       *NetworkConfig         `json:"network-config,omitempty" yaml:"network-config,omitempty" description:"Network-config overrides applied to all members of the group"`
+	PinnedVersion string                 `json:"pinned-version,omitempty" yaml:"pinned-version,omitempty" example:"3" description:"Revision of the group's cloud-config served to its members, unless pinned per node; empty or 'latest' serves the current cloud-config"`
 }
 
 func (g *GroupData) ParseFromJSON(body []byte) error {
@@ -25,44 +27,61 @@ func (g *GroupData) ParseFromJSON(body []byte) error {
 	if g.Name == "" {
 		return errors.New("name is required")
 	}
+	if _, err := ParseRevisionPin(g.PinnedVersion); err != nil {
+		return err
+	}
 
 	return nil
 }
 
 type OpenCHAMIComponent struct {
 	base.Component
-	MAC  string `json:"mac"`            // MAC address of the inteface used to boot the component
-	IP   string `json:"ip"`             // IP address of the interface used to boot the component
-	WGIP string `json:"wgip,omitempty"` // Wireguard IP address of the interface used for cloud-init
+	MAC        string               `json:"mac"`                  // MAC address of the inteface used to boot the component
+	IP         string               `json:"ip"`                   // IP address of the interface used to boot the component
+	WGIP       string               `json:"wgip,omitempty"`       // Wireguard IP address of the interface used for cloud-init
+	Interfaces []ComponentInterface `json:"interfaces,omitempty"` // All of the component's network interfaces
+}
+
+// ComponentInterface describes one of a component's network interfaces
+type ComponentInterface struct {
+	MAC         string   `json:"mac" yaml:"mac"`
+	IPs         []string `json:"ips,omitempty" yaml:"ips,omitempty"`
+	Description string   `json:"description,omitempty" yaml:"description,omitempty"`
+	Boot        bool     `json:"boot,omitempty" yaml:"boot,omitempty" description:"Whether this is the interface the component boots from"`
 }
 
 type OpenCHAMIInstanceInfo struct {
-	ID               string   `json:"id" example:"x3000c1b1n1" description:"Node unique identifier, on systems that support xnames, this will be an xname which includes location information"`
-	InstanceID       string   `json:"instance-id" yaml:"instance-id"`
-	LocalHostname    string   `json:"local-hostname,omitempty" yaml:"local-hostname" example:"compute-1" description:"Node-specific hostname"`
-	Hostname         string   `json:"hostname,omitempty" yaml:"hostname"`
-	ClusterName      string   `json:"cluster-name,omitempty" yaml:"cluster-name" example:"demo" description:"Long name of entire cluster, used as a human-readable identifier and is used in the cluster's FQDN"`
-	Region           string   `json:"region,omitempty" yaml:"region"`
-	AvailabilityZone string   `json:"availability-zone,omitempty" yaml:"availability-zone"`
-	CloudProvider    string   `json:"cloud-provider,omitempty" yaml:"cloud-provider"`
-	InstanceType     string   `json:"instance-type,omitempty" yaml:"instance-type"`
-	CloudInitBaseURL string   `json:"cloud-init-base-url,omitempty" yaml:"cloud-init-base-url"`
-	PublicKeys       []string `json:"public-keys,omitempty" yaml:"public-keys,omitempty" example:"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIMLtQNuzGcMDatF+YVMMkuxbX2c5v2OxWftBhEVfFb+U user1@demo-head,ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIB4vVRvkzmGE5PyWX2fuzJEgEfET4PRLHXCnD1uFZ8ZL user2@demo-head"`
+	ID               string            `json:"id" example:"x3000c1b1n1" description:"Node unique identifier, on systems that support xnames, this will be an xname which includes location information"`
+	InstanceID       string            `json:"instance-id" yaml:"instance-id"`
+	LocalHostname    string            `json:"local-hostname,omitempty" yaml:"local-hostname" example:"compute-1" description:"Node-specific hostname"`
+	Hostname         string            `json:"hostname,omitempty" yaml:"hostname"`
+	ClusterName      string            `json:"cluster-name,omitempty" yaml:"cluster-name" example:"demo" description:"Long name of entire cluster, used as a human-readable identifier and is used in the cluster's FQDN"`
+	Region           string            `json:"region,omitempty" yaml:"region"`
+	AvailabilityZone string            `json:"availability-zone,omitempty" yaml:"availability-zone"`
+	CloudProvider    string            `json:"cloud-provider,omitempty" yaml:"cloud-provider"`
+	InstanceType     string            `json:"instance-type,omitempty" yaml:"instance-type"`
+	CloudInitBaseURL string            `json:"cloud-init-base-url,omitempty" yaml:"cloud-init-base-url"`
+	PublicKeys       []string          `json:"public-keys,omitempty" yaml:"public-keys,omitempty" example:"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIMLtQNuzGcMDatF+YVMMkuxbX2c5v2OxWftBhEVfFb+U user1@demo-head,ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIB4vVRvkzmGE5PyWX2fuzJEgEfET4PRLHXCnD1uFZ8ZL user2@demo-head"`
+	Network          *NetworkConfig    `json:"network-config,omitempty" yaml:"network-config,omitempty" description:"Node-specific network-config overrides, applied after any group overrides"`
+	UserData         *CloudConfigFile  `json:"user-data,omitempty" yaml:"user-data,omitempty" description:"Node-specific user-data (in either plain or base64 encoding), returned by the user-data endpoint"`
+	BootMAC          string            `json:"boot-mac,omitempty" yaml:"boot-mac,omitempty" example:"de:ca:fc:0f:fe:e1" description:"MAC address of the interface the node boots from, overriding the cluster's boot interface rules"`
+	GroupVersions    map[string]string `json:"group-versions,omitempty" yaml:"group-versions,omitempty" example:"compute:3" description:"Map of group names to the revision of the group's cloud-config served to this node, overriding the group's pinned-version; 'latest' serves the current cloud-config"`
 }
 
 // ClusterDefaults represents the possible meta-data that can be set as default
 // values for a cluster.
 type ClusterDefaults struct {
-	CloudProvider    string   `json:"cloud_provider,omitempty" yaml:"cloud-provider,omitempty"`
-	Region           string   `json:"region,omitempty" yaml:"region,omitempty"`
-	AvailabilityZone string   `json:"availability-zone,omitempty" yaml:"availability-zone,omitempty"`
-	ClusterName      string   `json:"cluster-name,omitempty" yaml:"cluster-name,omitempty" example:"demo" description:"Long name of entire cluster, used as a human-readable identifier and is used in the cluster's FQDN"`
-	PublicKeys       []string `json:"public-keys,omitempty" yaml:"public-keys,omitempty" example:"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIMLtQNuzGcMDatF+YVMMkuxbX2c5v2OxWftBhEVfFb+U user1@demo-head,ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIB4vVRvkzmGE5PyWX2fuzJEgEfET4PRLHXCnD1uFZ8ZL user2@demo-head"`
-	BaseUrl          string   `json:"base-url,omitempty" yaml:"base-url,omitempty" example:"http://demo.openchami.cluster:8081/cloud-init"`
-	BootSubnet       string   `json:"boot-subnet,omitempty" yaml:"boot-subnet,omitempty"`
-	WGSubnet         string   `json:"wg-subnet,omitempty" yaml:"wg-subnet,omitempty"`
-	ShortName        string   `json:"short-name,omitempty" yaml:"short-name,omitempty" example:"nid" description:"Shortened name of cluster; this string is prepended to padded NID and set as node hostname if hostname is not set for node"`
-	NidLength        int      `json:"nid-length,omitempty" yaml:"nid-length,omitempty" example:"3" description:"Width of digits for node ID"`
+	CloudProvider            string   `json:"cloud_provider,omitempty" yaml:"cloud-provider,omitempty"`
+	Region                   string   `json:"region,omitempty" yaml:"region,omitempty"`
+	AvailabilityZone         string   `json:"availability-zone,omitempty" yaml:"availability-zone,omitempty"`
+	ClusterName              string   `json:"cluster-name,omitempty" yaml:"cluster-name,omitempty" example:"demo" description:"Long name of entire cluster, used as a human-readable identifier and is used in the cluster's FQDN"`
+	PublicKeys               []string `json:"public-keys,omitempty" yaml:"public-keys,omitempty" example:"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIMLtQNuzGcMDatF+YVMMkuxbX2c5v2OxWftBhEVfFb+U user1@demo-head,ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIB4vVRvkzmGE5PyWX2fuzJEgEfET4PRLHXCnD1uFZ8ZL user2@demo-head"`
+	BaseUrl                  string   `json:"base-url,omitempty" yaml:"base-url,omitempty" example:"http://demo.openchami.cluster:8081/cloud-init"`
+	BootSubnet               string   `json:"boot-subnet,omitempty" yaml:"boot-subnet,omitempty"`
+	BootInterfaceDescription string   `json:"boot-interface-description,omitempty" yaml:"boot-interface-description,omitempty" example:"Boot NIC" description:"Text in the SMD description of the interface nodes boot from (case-insensitive), used to pick the boot interface of nodes with several"`
+	WGSubnet                 string   `json:"wg-subnet,omitempty" yaml:"wg-subnet,omitempty"`
+	ShortName                string   `json:"short-name,omitempty" yaml:"short-name,omitempty" example:"nid" description:"Shortened name of cluster; this string is prepended to padded NID and set as node hostname if hostname is not set for node"`
+	NidLength                int      `json:"nid-length,omitempty" yaml:"nid-length,omitempty" example:"3" description:"Width of digits for node ID"`
 }
 
 type CloudConfigFile struct {
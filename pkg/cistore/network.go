@@ -0,0 +1,105 @@
+package cistore
+
+import "strings"
+
+// NetworkConfig is a cloud-init network configuration in the version 2
+// format. Only the subset of the format that OpenCHAMI generates or allows to
+// be overridden is represented here.
+//
+// See https://cloudinit.readthedocs.io/en/latest/reference/network-config-format-v2.html
+type NetworkConfig struct {
+	Version   int                        `json:"version" yaml:"version" example:"2"`
+	Ethernets map[string]NetworkEthernet `json:"ethernets,omitempty" yaml:"ethernets,omitempty" description:"Map of interface ID to its configuration"`
+}
+
+// NetworkEthernet is the configuration of a single physical interface.
+type NetworkEthernet struct {
+	Match       *NetworkMatch       `json:"match,omitempty" yaml:"match,omitempty"`
+	SetName     string              `json:"set-name,omitempty" yaml:"set-name,omitempty" example:"hsn0"`
+	DHCP4       *bool               `json:"dhcp4,omitempty" yaml:"dhcp4,omitempty"`
+	DHCP6       *bool               `json:"dhcp6,omitempty" yaml:"dhcp6,omitempty"`
+	Addresses   []string            `json:"addresses,omitempty" yaml:"addresses,omitempty" example:"10.20.30.40/20"`
+	Routes      []NetworkRoute      `json:"routes,omitempty" yaml:"routes,omitempty"`
+	Nameservers *NetworkNameservers `json:"nameservers,omitempty" yaml:"nameservers,omitempty"`
+	MTU         int                 `json:"mtu,omitempty" yaml:"mtu,omitempty" example:"9000"`
+}
+
+// NetworkMatch selects the physical interface that an entry applies to.
+type NetworkMatch struct {
+	MACAddress string `json:"macaddress,omitempty" yaml:"macaddress,omitempty" example:"00:de:ad:be:ef:01"`
+	Name       string `json:"name,omitempty" yaml:"name,omitempty" example:"enp*"`
+	Driver     string `json:"driver,omitempty" yaml:"driver,omitempty"`
+}
+
+type NetworkRoute struct {
+	To     string `json:"to" yaml:"to" example:"0.0.0.0/0"`
+	Via    string `json:"via" yaml:"via" example:"10.20.16.1"`
+	Metric int    `json:"metric,omitempty" yaml:"metric,omitempty"`
+}
+
+type NetworkNameservers struct {
+	Addresses []string `json:"addresses,omitempty" yaml:"addresses,omitempty" example:"10.20.16.2"`
+	Search    []string `json:"search,omitempty" yaml:"search,omitempty" example:"demo.openchami.cluster"`
+}
+
+// Merge layers override on top of n and returns the result. Neither input is
+// modified.
+//
+// An override entry is applied to the entry with the same ID or, failing
+// that, to the entry whose match MAC address is the same. Within an entry,
+// every field that is set in the override replaces the existing value.
+// Override entries that match nothing are added as-is.
+func (n NetworkConfig) Merge(override NetworkConfig) NetworkConfig {
+	merged := NetworkConfig{
+		Version:   n.Version,
+		Ethernets: make(map[string]NetworkEthernet, len(n.Ethernets)),
+	}
+	if override.Version != 0 {
+		merged.Version = override.Version
+	}
+	for id, eth := range n.Ethernets {
+		merged.Ethernets[id] = eth
+	}
+
+	for id, eth := range override.Ethernets {
+		target := id
+		if _, ok := merged.Ethernets[id]; !ok && eth.Match != nil && eth.Match.MACAddress != "" {
+			for existingID, existing := range merged.Ethernets {
+				if existing.Match != nil && strings.EqualFold(existing.Match.MACAddress, eth.Match.MACAddress) {
+					target = existingID
+					break
+				}
+			}
+		}
+		merged.Ethernets[target] = merged.Ethernets[target].merge(eth)
+	}
+	return merged
+}
+
+func (e NetworkEthernet) merge(override NetworkEthernet) NetworkEthernet {
+	if override.Match != nil {
+		e.Match = override.Match
+	}
+	if override.SetName != "" {
+		e.SetName = override.SetName
+	}
+	if override.DHCP4 != nil {
+		e.DHCP4 = override.DHCP4
+	}
+	if override.DHCP6 != nil {
+		e.DHCP6 = override.DHCP6
+	}
+	if len(override.Addresses) > 0 {
+		e.Addresses = override.Addresses
+	}
+	if len(override.Routes) > 0 {
+		e.Routes = override.Routes
+	}
+	if override.Nameservers != nil {
+		e.Nameservers = override.Nameservers
+	}
+	if override.MTU != 0 {
+		e.MTU = override.MTU
+	}
+	return e
+}
@@ -0,0 +1,54 @@
+package cistore
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNetworkConfig_Merge(t *testing.T) {
+	no := false
+	yes := true
+	base := NetworkConfig{
+		Version: 2,
+		Ethernets: map[string]NetworkEthernet{
+			"interface0": {
+				Match:     &NetworkMatch{MACAddress: "00:11:22:33:44:55"},
+				DHCP4:     &no,
+				Addresses: []string{"192.168.1.1/24"},
+			},
+			"interface1": {
+				Match: &NetworkMatch{MACAddress: "66:77:88:99:AA:BB"},
+				DHCP4: &yes,
+			},
+		},
+	}
+
+	override := NetworkConfig{
+		Ethernets: map[string]NetworkEthernet{
+			// Same ID: only the MTU changes
+			"interface0": {MTU: 9000},
+			// Different ID, same MAC: merged into interface1
+			"hsn": {
+				Match:   &NetworkMatch{MACAddress: "66:77:88:99:aa:bb"},
+				SetName: "hsn0",
+			},
+			// Matches nothing: added
+			"extra": {DHCP6: &yes},
+		},
+	}
+
+	merged := base.Merge(override)
+
+	assert.Equal(t, 2, merged.Version)
+	assert.Len(t, merged.Ethernets, 3)
+	assert.Equal(t, []string{"192.168.1.1/24"}, merged.Ethernets["interface0"].Addresses)
+	assert.Equal(t, 9000, merged.Ethernets["interface0"].MTU)
+	assert.Equal(t, "hsn0", merged.Ethernets["interface1"].SetName)
+	assert.Equal(t, &yes, merged.Ethernets["interface1"].DHCP4)
+	assert.Equal(t, &yes, merged.Ethernets["extra"].DHCP6)
+
+	// The inputs are left untouched
+	assert.Equal(t, 0, base.Ethernets["interface0"].MTU)
+	assert.Len(t, base.Ethernets, 2)
+}
@@ -1,5 +1,7 @@
 package cistore
 
+import "context"
+
 // ciStore is an interface for storing cloud-init entries
 type Store interface {
 	// groups API
@@ -8,6 +10,9 @@ type Store interface {
 	GetGroupData(groupName string) (GroupData, error)
 	UpdateGroupData(groupName string, groupData GroupData, create bool) error
 	RemoveGroupData(groupName string) error
+	// Group revisions API
+	GetGroupVersions(groupName string) ([]GroupRevision, error)
+	GetGroupVersion(groupName string, revision int) (GroupRevision, error)
 	// Extended Instance Information API
 	GetInstanceInfo(nodeName string) (OpenCHAMIInstanceInfo, error)
 	SetInstanceInfo(nodeName string, instanceInfo OpenCHAMIInstanceInfo) error
@@ -15,4 +20,18 @@ type Store interface {
 	// Cluster Defaults
 	GetClusterDefaults() (ClusterDefaults, error)
 	SetClusterDefaults(clusterDefaults ClusterDefaults) error
+	// Audit trail of admin API mutations; entries can only be appended
+	AddAuditEntry(entry AuditEntry) error
+	GetAuditEntries(filter AuditFilter) ([]AuditEntry, error)
+	// WireGuard server state. It holds the server's private key, so changes
+	// are not published to subscribers.
+	GetWireGuardServer() (WireGuardServer, error)
+	SetWireGuardServer(server WireGuardServer) error
+	GetWireGuardPeers() ([]WireGuardPeer, error)
+	SetWireGuardPeer(peer WireGuardPeer) error
+	DeleteWireGuardPeer(name string) error
+	// Change notifications
+	Subscribe(ctx context.Context) <-chan ChangeEvent
+	// Ping reports an error if the storage backend can't be reached
+	Ping() error
 }
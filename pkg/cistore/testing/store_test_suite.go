@@ -1,7 +1,9 @@
 package testing
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"github.com/OpenCHAMI/cloud-init/pkg/cistore"
 	"github.com/stretchr/testify/assert"
@@ -43,6 +45,22 @@ func RunStoreTests(t *testing.T, store cistore.Store, cleanup func()) {
 		}
 		testClusterDefaultsOperations(t, store)
 	})
+
+	t.Run("Audit Log", func(t *testing.T) {
+		testAuditLog(t, store)
+	})
+
+	t.Run("Change Events", func(t *testing.T) {
+		testChangeEvents(t, store)
+	})
+
+	t.Run("WireGuard State", func(t *testing.T) {
+		testWireGuardState(t, store)
+	})
+
+	t.Run("Ping", func(t *testing.T) {
+		assert.NoError(t, store.Ping())
+	})
 }
 
 func testGroupOperations(t *testing.T, store cistore.Store) {
@@ -63,6 +81,7 @@ func testGroupOperations(t *testing.T, store cistore.Store) {
 			Name:     "test.yaml",
 			Encoding: "plain",
 		},
+		PinnedVersion: "latest",
 	}
 
 	// Test AddGroupData
@@ -86,6 +105,7 @@ func testGroupOperations(t *testing.T, store cistore.Store) {
 		assert.Equal(t, testGroup.File.Content, group.File.Content)
 		assert.Equal(t, testGroup.File.Name, group.File.Name)
 		assert.Equal(t, testGroup.File.Encoding, group.File.Encoding)
+		assert.Equal(t, testGroup.PinnedVersion, group.PinnedVersion)
 
 		// Test non-existent group
 		_, err = store.GetGroupData("non-existent")
@@ -142,6 +162,50 @@ func testGroupOperations(t *testing.T, store cistore.Store) {
 		assert.Equal(t, newGroup.File.Encoding, group.File.Encoding)
 	})
 
+	// Test group revisions
+	t.Run("Group Versions", func(t *testing.T) {
+		// The update above kept the original content as the first revision
+		revisions, err := store.GetGroupVersions(testGroup.Name)
+		assert.NoError(t, err)
+		if assert.Len(t, revisions, 1) {
+			assert.Equal(t, 1, revisions[0].Revision)
+			assert.Equal(t, []byte("test content"), revisions[0].File.Content)
+			assert.Contains(t, revisions[0].Data, "key2")
+		}
+
+		group, err := store.GetGroupData(testGroup.Name)
+		assert.NoError(t, err)
+		assert.Contains(t, group.Versions, "1")
+
+		// Another update adds a second revision
+		updated := group
+		updated.File.Content = []byte("third content")
+		err = store.UpdateGroupData(testGroup.Name, updated, false)
+		assert.NoError(t, err)
+
+		revision, err := store.GetGroupVersion(testGroup.Name, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, revision.Revision)
+		assert.Equal(t, []byte("updated content"), revision.File.Content)
+
+		group, err = store.GetGroupData(testGroup.Name)
+		assert.NoError(t, err)
+		assert.Len(t, group.Versions, 2)
+
+		// Groups created by an update start without revisions
+		revisions, err = store.GetGroupVersions("new-group")
+		assert.NoError(t, err)
+		assert.Empty(t, revisions)
+
+		// Test non-existent revision and group
+		_, err = store.GetGroupVersion(testGroup.Name, 3)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "not found")
+		_, err = store.GetGroupVersions("non-existent")
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "not found")
+	})
+
 	// Test RemoveGroupData
 	t.Run("Remove Group", func(t *testing.T) {
 		err := store.RemoveGroupData(testGroup.Name)
@@ -151,6 +215,10 @@ func testGroupOperations(t *testing.T, store cistore.Store) {
 		_, err = store.GetGroupData(testGroup.Name)
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "not found")
+
+		// Revisions are removed along with the group
+		_, err = store.GetGroupVersion(testGroup.Name, 1)
+		assert.Error(t, err)
 	})
 
 	// Test GetGroups
@@ -175,6 +243,20 @@ func testInstanceOperations(t *testing.T, store cistore.Store) {
 		InstanceType:     "test-type",
 		CloudInitBaseURL: "http://test.example.com",
 		PublicKeys:       []string{"ssh-rsa test-key"},
+		Network: &cistore.NetworkConfig{
+			Version: 2,
+			Ethernets: map[string]cistore.NetworkEthernet{
+				"hsn": {
+					Match:     &cistore.NetworkMatch{MACAddress: "00:de:ad:be:ef:01"},
+					Addresses: []string{"172.16.0.1/16"},
+				},
+			},
+		},
+		UserData: &cistore.CloudConfigFile{
+			Content:  []byte("#cloud-config\nusers:\n  - name: debug"),
+			Encoding: "plain",
+		},
+		GroupVersions: map[string]string{"compute": "2"},
 	}
 
 	// Test SetInstanceInfo
@@ -198,6 +280,9 @@ func testInstanceOperations(t *testing.T, store cistore.Store) {
 		assert.Equal(t, testInstance.InstanceType, info.InstanceType)
 		assert.Equal(t, testInstance.CloudInitBaseURL, info.CloudInitBaseURL)
 		assert.Equal(t, testInstance.PublicKeys, info.PublicKeys)
+		assert.Equal(t, testInstance.Network, info.Network)
+		assert.Equal(t, testInstance.UserData, info.UserData)
+		assert.Equal(t, testInstance.GroupVersions, info.GroupVersions)
 
 		// Test non-existent instance
 		info, err = store.GetInstanceInfo("non-existent")
@@ -229,6 +314,10 @@ func testClusterDefaultsOperations(t *testing.T, store cistore.Store) {
 		Region:           "test-region",
 		CloudProvider:    "test-provider",
 		PublicKeys:       []string{"ssh-rsa test-key"},
+		BootSubnet:       "10.20.16.0/20",
+		WGSubnet:         "100.97.0.0/16",
+
+		BootInterfaceDescription: "Boot NIC",
 	}
 
 	// Test SetClusterDefaults
@@ -249,6 +338,9 @@ func testClusterDefaultsOperations(t *testing.T, store cistore.Store) {
 		assert.Equal(t, testDefaults.Region, defaults.Region)
 		assert.Equal(t, testDefaults.CloudProvider, defaults.CloudProvider)
 		assert.Equal(t, testDefaults.PublicKeys, defaults.PublicKeys)
+		assert.Equal(t, testDefaults.BootSubnet, defaults.BootSubnet)
+		assert.Equal(t, testDefaults.WGSubnet, defaults.WGSubnet)
+		assert.Equal(t, testDefaults.BootInterfaceDescription, defaults.BootInterfaceDescription)
 	})
 
 	// Test partial update
@@ -273,3 +365,168 @@ func testClusterDefaultsOperations(t *testing.T, store cistore.Store) {
 		assert.Equal(t, testDefaults.PublicKeys, defaults.PublicKeys)
 	})
 }
+
+func testChangeEvents(t *testing.T, store cistore.Store) {
+	ctx, cancel := context.WithCancel(context.Background())
+	events := store.Subscribe(ctx)
+
+	next := func(t *testing.T) cistore.ChangeEvent {
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for change event")
+			return cistore.ChangeEvent{}
+		}
+	}
+
+	group := cistore.GroupData{Name: "events-group", Description: "original"}
+	assert.NoError(t, store.AddGroupData(group.Name, group))
+	event := next(t)
+	assert.Equal(t, cistore.EntityGroup, event.Entity)
+	assert.Equal(t, group.Name, event.Name)
+	assert.Equal(t, cistore.OperationCreate, event.Operation)
+	assert.Nil(t, event.Old)
+	assert.Equal(t, "original", event.New.(cistore.GroupData).Description)
+
+	updated := group
+	updated.Description = "updated"
+	assert.NoError(t, store.UpdateGroupData(group.Name, updated, false))
+	event = next(t)
+	assert.Equal(t, cistore.OperationUpdate, event.Operation)
+	assert.Equal(t, "original", event.Old.(cistore.GroupData).Description)
+	assert.Equal(t, "updated", event.New.(cistore.GroupData).Description)
+
+	assert.NoError(t, store.RemoveGroupData(group.Name))
+	event = next(t)
+	assert.Equal(t, cistore.OperationDelete, event.Operation)
+	assert.Equal(t, "updated", event.Old.(cistore.GroupData).Description)
+	assert.Nil(t, event.New)
+
+	assert.NoError(t, store.SetInstanceInfo("events-node", cistore.OpenCHAMIInstanceInfo{Hostname: "first"}))
+	event = next(t)
+	assert.Equal(t, cistore.EntityInstance, event.Entity)
+	assert.Equal(t, "events-node", event.Name)
+	assert.Equal(t, cistore.OperationCreate, event.Operation)
+
+	assert.NoError(t, store.SetInstanceInfo("events-node", cistore.OpenCHAMIInstanceInfo{Hostname: "second"}))
+	event = next(t)
+	assert.Equal(t, cistore.OperationUpdate, event.Operation)
+	assert.Equal(t, "first", event.Old.(cistore.OpenCHAMIInstanceInfo).Hostname)
+	assert.Equal(t, "second", event.New.(cistore.OpenCHAMIInstanceInfo).Hostname)
+
+	assert.NoError(t, store.DeleteInstanceInfo("events-node"))
+	event = next(t)
+	assert.Equal(t, cistore.OperationDelete, event.Operation)
+	assert.Equal(t, "second", event.Old.(cistore.OpenCHAMIInstanceInfo).Hostname)
+
+	assert.NoError(t, store.SetClusterDefaults(cistore.ClusterDefaults{Region: "events-region"}))
+	event = next(t)
+	assert.Equal(t, cistore.EntityClusterDefaults, event.Entity)
+	assert.Equal(t, cistore.OperationUpdate, event.Operation)
+	assert.Equal(t, "events-region", event.New.(cistore.ClusterDefaults).Region)
+
+	// The channel is closed once the subscription is cancelled
+	cancel()
+	for range events {
+	}
+}
+
+func testAuditLog(t *testing.T, store cistore.Store) {
+	start := time.Now().UTC().Add(-time.Minute)
+	entries := []cistore.AuditEntry{
+		{Time: start, Subject: "alice", SourceIP: "10.0.0.1", Method: "PUT", Path: "/admin/groups/compute", Status: 201, Entity: cistore.EntityGroup, Name: "compute",
+			Before: map[string]interface{}{"name": "compute"},
+			After:  map[string]interface{}{"name": "compute", "description": "Compute nodes"},
+			Diff:   map[string]cistore.AuditChange{"description": {After: "Compute nodes"}}},
+		{Time: start.Add(time.Second), Subject: "bob", SourceIP: "10.0.0.2", Method: "POST", Path: "/admin/cluster-defaults", Status: 201, Entity: cistore.EntityClusterDefaults},
+		{Time: start.Add(2 * time.Second), Subject: "alice", SourceIP: "10.0.0.1", Method: "DELETE", Path: "/admin/groups/compute", Status: 200, Entity: cistore.EntityGroup, Name: "compute"},
+	}
+	for _, entry := range entries {
+		assert.NoError(t, store.AddAuditEntry(entry))
+	}
+
+	t.Run("All Entries", func(t *testing.T) {
+		all, err := store.GetAuditEntries(cistore.AuditFilter{})
+		assert.NoError(t, err)
+		if assert.Len(t, all, 3) {
+			// Newest first, with increasing IDs assigned by the store
+			assert.Equal(t, "DELETE", all[0].Method)
+			assert.Equal(t, "POST", all[1].Method)
+			assert.Equal(t, "PUT", all[2].Method)
+			assert.Greater(t, all[0].ID, all[1].ID)
+			assert.Greater(t, all[1].ID, all[2].ID)
+			assert.Equal(t, "10.0.0.1", all[2].SourceIP)
+			assert.Equal(t, "Compute nodes", all[2].Diff["description"].After)
+		}
+	})
+
+	t.Run("Filtered Entries", func(t *testing.T) {
+		filtered, err := store.GetAuditEntries(cistore.AuditFilter{Entity: cistore.EntityGroup, Name: "compute"})
+		assert.NoError(t, err)
+		assert.Len(t, filtered, 2)
+
+		filtered, err = store.GetAuditEntries(cistore.AuditFilter{Subject: "bob"})
+		assert.NoError(t, err)
+		if assert.Len(t, filtered, 1) {
+			assert.Equal(t, cistore.EntityClusterDefaults, filtered[0].Entity)
+		}
+
+		filtered, err = store.GetAuditEntries(cistore.AuditFilter{Since: start.Add(time.Second)})
+		assert.NoError(t, err)
+		assert.Len(t, filtered, 2)
+
+		filtered, err = store.GetAuditEntries(cistore.AuditFilter{Subject: "alice", Limit: 1})
+		assert.NoError(t, err)
+		if assert.Len(t, filtered, 1) {
+			assert.Equal(t, "DELETE", filtered[0].Method)
+		}
+	})
+}
+
+func testWireGuardState(t *testing.T, store cistore.Store) {
+	t.Run("Server", func(t *testing.T) {
+		server, err := store.GetWireGuardServer()
+		assert.NoError(t, err)
+		assert.Empty(t, server.PrivateKey)
+
+		want := cistore.WireGuardServer{
+			PrivateKey: "yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=",
+			PublicKey:  "HIgo9xNzJMWLKASShiTqIybxZ0U3wGLiUeJ1PKf8ykw=",
+			ListenPort: 58036,
+		}
+		assert.NoError(t, store.SetWireGuardServer(want))
+		server, err = store.GetWireGuardServer()
+		assert.NoError(t, err)
+		assert.Equal(t, want, server)
+	})
+
+	t.Run("Peers", func(t *testing.T) {
+		peers, err := store.GetWireGuardPeers()
+		assert.NoError(t, err)
+		assert.Empty(t, peers)
+
+		created := time.Date(2024, 7, 19, 12, 0, 0, 0, time.UTC)
+		node2 := cistore.WireGuardPeer{Name: "x3000c0s0b0n2", PublicKey: "9NS6+NR0J38SZ9IlY9hBDLs6aBpNDhxHUHL8OTlNEDU=", IP: "100.97.0.3", Created: created}
+		node1 := cistore.WireGuardPeer{Name: "x3000c0s0b0n1", PublicKey: "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=", IP: "100.97.0.2", Created: created.Add(time.Minute)}
+		assert.NoError(t, store.SetWireGuardPeer(node2))
+		assert.NoError(t, store.SetWireGuardPeer(node1))
+		peers, err = store.GetWireGuardPeers()
+		assert.NoError(t, err)
+		assert.Equal(t, []cistore.WireGuardPeer{node1, node2}, peers)
+
+		// Setting a peer again replaces it
+		node1.PublicKey = "TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0="
+		assert.NoError(t, store.SetWireGuardPeer(node1))
+		peers, err = store.GetWireGuardPeers()
+		assert.NoError(t, err)
+		assert.Equal(t, []cistore.WireGuardPeer{node1, node2}, peers)
+
+		assert.NoError(t, store.DeleteWireGuardPeer(node1.Name))
+		assert.NoError(t, store.DeleteWireGuardPeer("x9999c0s0b0n0"))
+		peers, err = store.GetWireGuardPeers()
+		assert.NoError(t, err)
+		assert.Equal(t, []cistore.WireGuardPeer{node2}, peers)
+		assert.NoError(t, store.DeleteWireGuardPeer(node2.Name))
+	})
+}
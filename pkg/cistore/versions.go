@@ -0,0 +1,53 @@
+package cistore
+
+import (
+	"fmt"
+	"maps"
+	"strconv"
+	"time"
+)
+
+// LatestRevision is the revision pin that follows a group's current
+// cloud-config. An empty pin means the same.
+const LatestRevision = "latest"
+
+// GroupRevision is a previous version of a group's meta-data and cloud-config,
+// recorded by the store each time the group is updated.
+type GroupRevision struct {
+	Revision int                    `json:"revision" yaml:"revision" example:"1" description:"Revision number, starting at 1 for the oldest revision"`
+	Created  time.Time              `json:"created" yaml:"created" description:"Time at which the revision was replaced by an update"`
+	Data     map[string]interface{} `json:"meta-data,omitempty" yaml:"meta-data,omitempty" description:"Group meta-data at this revision"`
+	File     CloudConfigFile        `json:"file,omitempty" yaml:"file,omitempty" description:"Cloud-Init configuration for group at this revision"`
+}
+
+// ArchiveGroupData records the meta-data and cloud-config of previous as
+// revision number rev. It returns the revision along with the Versions index
+// of previous updated to include it, which the updated group should carry.
+func ArchiveGroupData(previous GroupData, rev int) (GroupRevision, map[string]string) {
+	revision := GroupRevision{
+		Revision: rev,
+		Created:  time.Now().UTC(),
+		Data:     maps.Clone(previous.Data),
+		File:     previous.File,
+	}
+	versions := maps.Clone(previous.Versions)
+	if versions == nil {
+		versions = make(map[string]string)
+	}
+	versions[strconv.Itoa(rev)] = revision.Created.Format(time.RFC3339)
+	return revision, versions
+}
+
+// ParseRevisionPin parses a revision pin as used by GroupData.PinnedVersion
+// and OpenCHAMIInstanceInfo.GroupVersions. It returns 0 for a pin that follows
+// the current cloud-config.
+func ParseRevisionPin(pin string) (int, error) {
+	if pin == "" || pin == LatestRevision {
+		return 0, nil
+	}
+	rev, err := strconv.Atoi(pin)
+	if err != nil || rev < 1 {
+		return 0, fmt.Errorf("invalid revision pin %q: must be %q or a revision number", pin, LatestRevision)
+	}
+	return rev, nil
+}
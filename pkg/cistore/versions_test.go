@@ -0,0 +1,19 @@
+package cistore
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRevisionPin(t *testing.T) {
+	for pin, expected := range map[string]int{"": 0, "latest": 0, "1": 1, "12": 12} {
+		rev, err := ParseRevisionPin(pin)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, rev, "pin %q", pin)
+	}
+	for _, pin := range []string{"0", "-1", "v2", "Latest"} {
+		_, err := ParseRevisionPin(pin)
+		assert.Error(t, err, "pin %q", pin)
+	}
+}
@@ -0,0 +1,20 @@
+package cistore
+
+import "time"
+
+// WireGuardServer is the persisted state of the WireGuard server, kept so that
+// tunnels and the server public key cached by nodes survive a restart
+type WireGuardServer struct {
+	PrivateKey string `json:"private-key" yaml:"private-key"`
+	PublicKey  string `json:"public-key,omitempty" yaml:"public-key,omitempty"`
+	ListenPort int    `json:"listen-port" yaml:"listen-port"`
+}
+
+// WireGuardPeer is a peer of the WireGuard server
+type WireGuardPeer struct {
+	Name      string    `json:"name" yaml:"name" example:"10.20.30.1" description:"IP of the node that set up the tunnel"`
+	PublicKey string    `json:"public-key" yaml:"public-key"`
+	IP        string    `json:"ip" yaml:"ip" example:"100.97.0.2" description:"VPN IP of the peer"`
+	NodeID    string    `json:"node-id,omitempty" yaml:"node-id,omitempty" example:"x3000c0b0n1" description:"ID of the peer's node in SMD"`
+	Created   time.Time `json:"created" yaml:"created" description:"Time at which the peer's tunnel was set up"`
+}
@@ -2,44 +2,88 @@ package wgtunnel
 
 import (
 	"errors"
+	"math"
+	"math/big"
 	"net"
+	"net/netip"
 	"sync"
 )
 
-// IPAllocator manages IP address allocation within a network range.
+// IPAllocator manages IP address allocation within an IPv4 or IPv6 network
+// range. Allocation doesn't walk the range, so it stays fast in IPv6 ranges
+// with far more addresses than could ever be allocated.
 type IPAllocator struct {
-	network       *net.IPNet
-	usedIPs       map[string]bool
-	mu            sync.Mutex
-	networkAddr   net.IP
-	broadcastAddr net.IP
+	network netip.Prefix
+	usedIPs map[netip.Addr]bool
+	mu      sync.Mutex
+	// first and last are the lowest and highest allocatable addresses
+	first netip.Addr
+	last  netip.Addr
+	// next is the lowest address that may be free. Every allocatable address
+	// below it is in use.
+	next netip.Addr
 }
 
 // NewIPAllocator initializes a new IPAllocator for a given network.
 func NewIPAllocator(cidr string) (*IPAllocator, error) {
-	_, network, err := net.ParseCIDR(cidr)
+	network, err := netip.ParsePrefix(cidr)
 	if err != nil {
 		return nil, err
 	}
+	network = network.Masked()
 
-	ip := network.IP.To4()
-	if ip == nil {
-		return nil, errors.New("only IPv4 is supported")
+	first, last, err := usableRange(network)
+	if err != nil {
+		return nil, err
+	}
+	return &IPAllocator{
+		network: network,
+		usedIPs: make(map[netip.Addr]bool),
+		first:   first,
+		last:    last,
+		next:    first,
+	}, nil
+}
+
+// usableRange returns the first and last addresses in a masked network that
+// can be assigned to hosts. The network address is never usable, and neither
+// is the broadcast address of an IPv4 network. Point-to-point IPv4 networks
+// (/31 and /32) have no network or broadcast address.
+func usableRange(network netip.Prefix) (netip.Addr, netip.Addr, error) {
+	first := network.Addr()
+	last := lastAddr(network)
+	if first.Is6() || network.Bits() < 31 {
+		first = first.Next()
+	}
+	if first.Is4() && network.Bits() < 31 {
+		last = last.Prev()
 	}
+	if !first.IsValid() || !last.IsValid() || last.Less(first) {
+		return netip.Addr{}, netip.Addr{}, errors.New("no usable IP in the subnet " + network.String())
+	}
+	return first, last, nil
+}
 
-	// Calculate the network and broadcast addresses
-	networkAddr := network.IP.Mask(network.Mask)
-	broadcastAddr := make(net.IP, len(networkAddr))
-	for i := range networkAddr {
-		broadcastAddr[i] = networkAddr[i] | ^network.Mask[i]
+// lastAddr returns the highest address in a masked network
+func lastAddr(network netip.Prefix) netip.Addr {
+	ip := network.Addr().AsSlice()
+	for bit := network.Bits(); bit < len(ip)*8; bit++ {
+		ip[bit/8] |= 0x80 >> (bit % 8)
 	}
+	addr, _ := netip.AddrFromSlice(ip)
+	return addr
+}
 
-	return &IPAllocator{
-		network:       network,
-		networkAddr:   networkAddr,
-		broadcastAddr: broadcastAddr,
-		usedIPs:       make(map[string]bool),
-	}, nil
+// toAddr converts an IP address to the address family of the network, so
+// that IPv4 addresses in IPv6 form match IPv4 networks
+func toAddr(ip net.IP) (netip.Addr, bool) {
+	addr, ok := netip.AddrFromSlice(ip)
+	return addr.Unmap(), ok
+}
+
+// toIPAddr converts an allocated address back to a net.IPAddr
+func toIPAddr(addr netip.Addr) net.IPAddr {
+	return net.IPAddr{IP: net.IP(addr.AsSlice())}
 }
 
 // Reserve reserves a specific IP address.
@@ -47,44 +91,33 @@ func (a *IPAllocator) Reserve(ipAddr net.IPAddr) error {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
-	ip := ipAddr.IP
-	if !a.network.Contains(ip) {
+	ip, ok := toAddr(ipAddr.IP)
+	if !ok || !a.network.Contains(ip) {
 		return errors.New("IP address out of range")
 	}
-	ipStr := ip.String()
-	if a.usedIPs[ipStr] {
+	if a.usedIPs[ip] {
 		return errors.New("IP address already allocated")
 	}
-	a.usedIPs[ipStr] = true
+	a.usedIPs[ip] = true
 	return nil
 }
 
-// NextAvailable returns the next available IP address in the range.
+// NextAvailable returns the lowest available IP address in the range.
 func (a *IPAllocator) NextAvailable() (net.IPAddr, error) {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
-	ip := make(net.IP, len(a.networkAddr))
-	copy(ip, a.networkAddr)
-	for {
-		for i := len(ip) - 1; i >= 0; i-- {
-			ip[i]++
-			if ip[i] != 0 {
-				break
-			}
-		}
-
-		// Check if the incremented IP is still within the subnet range
-		if !a.network.Contains(ip) {
-			return net.IPAddr{}, errors.New("IP range exhausted: no available IP addresses in range " + a.network.String())
-		}
-
-		ipStr := ip.String()
-		if !a.usedIPs[ipStr] {
-			a.usedIPs[ipStr] = true
-			return net.IPAddr{IP: ip}, nil
+	// Only addresses that are in use are skipped, so this loop runs at most
+	// once per allocated address
+	for ip := a.next; ip.IsValid() && !a.last.Less(ip); ip = ip.Next() {
+		if !a.usedIPs[ip] {
+			a.usedIPs[ip] = true
+			a.next = ip.Next()
+			return toIPAddr(ip), nil
 		}
 	}
+	a.next = a.last.Next()
+	return net.IPAddr{}, errors.New("IP range exhausted: no available IP addresses in range " + a.network.String())
 }
 
 // IsAllocated checks if an IP address is currently allocated.
@@ -92,7 +125,8 @@ func (a *IPAllocator) IsAllocated(ipAddr net.IPAddr) bool {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
-	return a.usedIPs[ipAddr.IP.String()]
+	ip, ok := toAddr(ipAddr.IP)
+	return ok && a.usedIPs[ip]
 }
 
 // Release releases an IP address back to the pool.
@@ -100,10 +134,51 @@ func (a *IPAllocator) Release(ipAddr net.IPAddr) error {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
-	ipStr := ipAddr.IP.String()
-	if !a.usedIPs[ipStr] {
+	ip, ok := toAddr(ipAddr.IP)
+	if !ok || !a.usedIPs[ip] {
 		return errors.New("IP address not allocated")
 	}
-	delete(a.usedIPs, ipStr)
+	delete(a.usedIPs, ip)
+	if !ip.Less(a.first) && !a.last.Less(ip) && (!a.next.IsValid() || ip.Less(a.next)) {
+		a.next = ip
+	}
 	return nil
 }
+
+// AllocatorStats describes how much of an allocator's range is in use
+type AllocatorStats struct {
+	Network string `json:"network" yaml:"network" example:"100.97.0.0/16"`
+	// Capacity and Available are capped at the largest uint64, which only
+	// matters for IPv6 ranges larger than /64
+	Capacity  uint64 `json:"capacity" yaml:"capacity" example:"65534" description:"Number of addresses that can be allocated"`
+	Allocated int    `json:"allocated" yaml:"allocated" example:"12" description:"Number of addresses in use, including the server's"`
+	Available uint64 `json:"available" yaml:"available" example:"65522"`
+}
+
+// Stats returns how much of the range is in use
+func (a *IPAllocator) Stats() AllocatorStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// Reserved addresses outside the usable range, such as the network
+	// address, don't take up capacity
+	allocated := 0
+	for ip := range a.usedIPs {
+		if !ip.Less(a.first) && !a.last.Less(ip) {
+			allocated++
+		}
+	}
+	size := new(big.Int).Sub(new(big.Int).SetBytes(a.last.AsSlice()), new(big.Int).SetBytes(a.first.AsSlice()))
+	size.Add(size, big.NewInt(1))
+	capacity, available := uint64(math.MaxUint64), uint64(math.MaxUint64)
+	if size.IsUint64() {
+		capacity = size.Uint64()
+		available = capacity - uint64(allocated)
+	}
+	return AllocatorStats{
+		Network:   a.network.String(),
+		Capacity:  capacity,
+		Allocated: allocated,
+		Available: available,
+	}
+}
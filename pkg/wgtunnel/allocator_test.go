@@ -1,6 +1,7 @@
 package wgtunnel
 
 import (
+	"math"
 	"net"
 	"strconv"
 	"testing"
@@ -89,3 +90,147 @@ func TestRelease(t *testing.T) {
 		t.Fatalf("Expected error when releasing a non-allocated IP")
 	}
 }
+
+func TestNextAvailable_IPv6(t *testing.T) {
+	allocator, err := NewIPAllocator("fd42::/64")
+	if err != nil {
+		t.Fatalf("Failed to create IPAllocator: %v", err)
+	}
+
+	// The subnet-router anycast address is skipped, as are reserved addresses
+	if err := allocator.Reserve(net.IPAddr{IP: net.ParseIP("fd42::2")}); err != nil {
+		t.Fatalf("Failed to reserve IP: %v", err)
+	}
+	for _, expected := range []string{"fd42::1", "fd42::3", "fd42::4"} {
+		ip, err := allocator.NextAvailable()
+		if err != nil {
+			t.Fatalf("Failed to get next available IP: %v", err)
+		}
+		if !ip.IP.Equal(net.ParseIP(expected)) {
+			t.Fatalf("Expected IP %s, got %v", expected, ip)
+		}
+	}
+
+	// Released addresses are allocated again first
+	if err := allocator.Release(net.IPAddr{IP: net.ParseIP("fd42::3")}); err != nil {
+		t.Fatalf("Failed to release IP: %v", err)
+	}
+	ip, err := allocator.NextAvailable()
+	if err != nil {
+		t.Fatalf("Failed to get next available IP: %v", err)
+	}
+	if !ip.IP.Equal(net.ParseIP("fd42::3")) {
+		t.Fatalf("Expected released IP fd42::3, got %v", ip)
+	}
+
+	if err := allocator.Reserve(net.IPAddr{IP: net.ParseIP("10.0.0.1")}); err == nil {
+		t.Fatalf("Expected error when reserving an IPv4 address in an IPv6 range")
+	}
+}
+
+func TestNextAvailable_HugeRange(t *testing.T) {
+	// Allocation must not walk the range, so that it's fast in a /32
+	allocator, err := NewIPAllocator("2001:db8::/32")
+	if err != nil {
+		t.Fatalf("Failed to create IPAllocator: %v", err)
+	}
+	for i := 0; i < 10000; i++ {
+		if _, err := allocator.NextAvailable(); err != nil {
+			t.Fatalf("Failed to get next available IP: %v", err)
+		}
+	}
+	last := net.IPAddr{IP: net.ParseIP("2001:db8:ffff:ffff:ffff:ffff:ffff:ffff")}
+	if err := allocator.Reserve(last); err != nil {
+		t.Fatalf("Failed to reserve the last IP in range: %v", err)
+	}
+}
+
+func TestNextAvailable_Exhausted(t *testing.T) {
+	testCases := []struct {
+		cidr     string
+		expected []string
+	}{
+		{"192.168.1.0/30", []string{"192.168.1.1", "192.168.1.2"}},
+		{"192.168.1.0/31", []string{"192.168.1.0", "192.168.1.1"}},
+		{"fd42::/126", []string{"fd42::1", "fd42::2", "fd42::3"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.cidr, func(t *testing.T) {
+			allocator, err := NewIPAllocator(tc.cidr)
+			if err != nil {
+				t.Fatalf("Failed to create IPAllocator: %v", err)
+			}
+			for _, expected := range tc.expected {
+				ip, err := allocator.NextAvailable()
+				if err != nil {
+					t.Fatalf("Failed to get next available IP: %v", err)
+				}
+				if !ip.IP.Equal(net.ParseIP(expected)) {
+					t.Fatalf("Expected IP %s, got %v", expected, ip)
+				}
+			}
+			if ip, err := allocator.NextAvailable(); err == nil {
+				t.Fatalf("Expected the range to be exhausted, got %v", ip)
+			}
+		})
+	}
+}
+
+func TestStats(t *testing.T) {
+	testCases := []struct {
+		cidr     string
+		capacity uint64
+	}{
+		{"100.97.0.0/16", 65534},
+		{"192.168.1.0/31", 2},
+		{"fd42::/120", 255},
+		{"2001:db8::/32", math.MaxUint64},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.cidr, func(t *testing.T) {
+			allocator, err := NewIPAllocator(tc.cidr)
+			if err != nil {
+				t.Fatalf("Failed to create IPAllocator: %v", err)
+			}
+			for i := 0; i < 2; i++ {
+				if _, err := allocator.NextAvailable(); err != nil {
+					t.Fatalf("Failed to get next available IP: %v", err)
+				}
+			}
+			stats := allocator.Stats()
+			if stats.Network != tc.cidr || stats.Capacity != tc.capacity || stats.Allocated != 2 {
+				t.Errorf("Unexpected stats %+v", stats)
+			}
+			if expected := tc.capacity - 2; tc.capacity != math.MaxUint64 && stats.Available != expected {
+				t.Errorf("Expected %d available, got %d", expected, stats.Available)
+			}
+		})
+	}
+}
+
+func TestGetUsableIP(t *testing.T) {
+	testCases := []struct {
+		cidr     string
+		expected string
+	}{
+		{"100.97.0.1/16", "100.97.0.1"},
+		{"100.97.0.0/16", "100.97.0.1"},
+		{"100.97.255.255/16", "100.97.0.1"},
+		{"fd42::10/64", "fd42::10"},
+		{"fd42::/64", "fd42::1"},
+	}
+	for _, tc := range testCases {
+		ip, network, err := net.ParseCIDR(tc.cidr)
+		if err != nil {
+			t.Fatalf("Failed to parse %s: %v", tc.cidr, err)
+		}
+		network.IP = ip
+		usable, err := GetUsableIP(network)
+		if err != nil {
+			t.Fatalf("Failed to get usable IP in %s: %v", tc.cidr, err)
+		}
+		if !usable.Equal(net.ParseIP(tc.expected)) {
+			t.Errorf("Expected usable IP %s in %s, got %s", tc.expected, tc.cidr, usable)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package wgtunnel
+
+import (
+	"net"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Backend configures WireGuard interfaces. NetlinkBackend configures the
+// kernel's interfaces and FakeBackend keeps them in memory, for tests.
+type Backend interface {
+	// ConfigureInterface creates the interface if it doesn't exist, assigns it
+	// address, sets its private key and listen port, and brings it up. It may
+	// be called again for an interface that is already configured.
+	ConfigureInterface(name string, address net.IPNet, privateKey string, listenPort int) error
+	// DeleteInterface brings the interface down and deletes it
+	DeleteInterface(name string) error
+	// InterfaceUp returns an error if the interface doesn't exist or is down
+	InterfaceUp(name string) error
+	// Device returns the current configuration of the interface
+	Device(name string) (Device, error)
+	// SetPeer adds a peer to the interface, or replaces its allowed IPs if it
+	// exists
+	SetPeer(name, publicKey string, allowedIPs []net.IPNet) error
+	// RemovePeer removes a peer from the interface
+	RemovePeer(name, publicKey string) error
+}
+
+// Device is the configuration of a WireGuard interface
+type Device struct {
+	// PrivateKey is empty if the interface has no key yet
+	PrivateKey string
+	ListenPort int
+	Peers      []DevicePeer
+}
+
+// DevicePeer is a peer of a WireGuard interface
+type DevicePeer struct {
+	PublicKey  string
+	AllowedIPs []net.IPNet
+	// LastHandshake is zero if the peer has never completed a handshake
+	LastHandshake time.Time
+}
+
+// generateKey generates a WireGuard private key
+func generateKey() (string, error) {
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		return "", err
+	}
+	return key.String(), nil
+}
+
+// publicKeyFor returns the public key of a WireGuard private key
+func publicKeyFor(privateKey string) (string, error) {
+	key, err := wgtypes.ParseKey(privateKey)
+	if err != nil {
+		return "", err
+	}
+	return key.PublicKey().String(), nil
+}
+
+// hostPrefix returns the single-address prefix of an IP, as used for the
+// allowed IPs of a peer, e.g. 100.97.0.2/32 or fd42::2/128
+func hostPrefix(ip net.IP) net.IPNet {
+	if v4 := ip.To4(); v4 != nil {
+		return net.IPNet{IP: v4, Mask: net.CIDRMask(32, 32)}
+	}
+	return net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+}
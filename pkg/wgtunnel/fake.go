@@ -0,0 +1,131 @@
+package wgtunnel
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// FakeBackend keeps WireGuard interfaces in memory, so that the tunnel
+// manager can be tested without root or the WireGuard kernel module
+type FakeBackend struct {
+	mu      sync.Mutex
+	devices map[string]*fakeDevice
+}
+
+type fakeDevice struct {
+	up         bool
+	privateKey string
+	listenPort int
+	// peers maps public keys to allowed IPs
+	peers map[string][]net.IPNet
+	// handshakes maps public keys to the latest handshake
+	handshakes map[string]time.Time
+}
+
+// NewFakeBackend creates a fake backend without any interfaces
+func NewFakeBackend() *FakeBackend {
+	return &FakeBackend{devices: make(map[string]*fakeDevice)}
+}
+
+func (b *FakeBackend) ConfigureInterface(name string, address net.IPNet, privateKey string, listenPort int) error {
+	if _, err := publicKeyFor(privateKey); err != nil {
+		return fmt.Errorf("invalid private key: %w", err)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	d, ok := b.devices[name]
+	if !ok {
+		d = &fakeDevice{peers: make(map[string][]net.IPNet), handshakes: make(map[string]time.Time)}
+		b.devices[name] = d
+	}
+	d.privateKey = privateKey
+	d.listenPort = listenPort
+	d.up = true
+	return nil
+}
+
+func (b *FakeBackend) DeleteInterface(name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.devices[name]; !ok {
+		return fmt.Errorf("interface %s not found", name)
+	}
+	delete(b.devices, name)
+	return nil
+}
+
+func (b *FakeBackend) InterfaceUp(name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	d, ok := b.devices[name]
+	if !ok {
+		return fmt.Errorf("interface %s not found", name)
+	}
+	if !d.up {
+		return fmt.Errorf("interface %s is down", name)
+	}
+	return nil
+}
+
+func (b *FakeBackend) Device(name string) (Device, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	d, ok := b.devices[name]
+	if !ok {
+		return Device{}, fmt.Errorf("interface %s not found", name)
+	}
+	device := Device{PrivateKey: d.privateKey, ListenPort: d.listenPort}
+	for publicKey, allowedIPs := range d.peers {
+		device.Peers = append(device.Peers, DevicePeer{
+			PublicKey:     publicKey,
+			AllowedIPs:    append([]net.IPNet(nil), allowedIPs...),
+			LastHandshake: d.handshakes[publicKey],
+		})
+	}
+	return device, nil
+}
+
+func (b *FakeBackend) SetPeer(name, publicKey string, allowedIPs []net.IPNet) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	d, ok := b.devices[name]
+	if !ok {
+		return fmt.Errorf("interface %s not found", name)
+	}
+	d.peers[publicKey] = append([]net.IPNet(nil), allowedIPs...)
+	return nil
+}
+
+func (b *FakeBackend) RemovePeer(name, publicKey string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	d, ok := b.devices[name]
+	if !ok {
+		return fmt.Errorf("interface %s not found", name)
+	}
+	delete(d.peers, publicKey)
+	delete(d.handshakes, publicKey)
+	return nil
+}
+
+// SetInterfaceDown marks an interface as down, as if it had been brought down
+// outside the server
+func (b *FakeBackend) SetInterfaceDown(name string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if d, ok := b.devices[name]; ok {
+		d.up = false
+	}
+}
+
+// SetHandshake records a handshake with a peer of an interface, as if the
+// peer had connected
+func (b *FakeBackend) SetHandshake(name, publicKey string, t time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if d, ok := b.devices[name]; ok {
+		d.handshakes[publicKey] = t
+	}
+}
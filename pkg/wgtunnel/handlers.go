@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/OpenCHAMI/cloud-init/internal/middleware"
 	"github.com/OpenCHAMI/cloud-init/internal/smdclient"
 	"github.com/rs/zerolog/log"
 )
@@ -33,9 +34,11 @@ type WGResponse struct {
 //	@Description	and peer name (IP address).
 //	@Description
 //	@Description	The source IP of the request is read and is used as the peer
-//	@Description	name along with the public key to authenticate unless the
-//	@Description	`X-Forward-For` header is set. In that case, the value of the
-//	@Description	header is used as the peer name. If the peer exists in the
+//	@Description	name along with the public key to authenticate. If the request
+//	@Description	came through a trusted proxy, the client IP forwarded by the
+//	@Description	proxy in the `X-Forwarded-For` or `Forwarded` header is used
+//	@Description	instead. Headers from other hosts are ignored, so that they
+//	@Description	can't claim another node's IP. If the peer exists in the
 //	@Description	internal tunnel manager, the IP presented is the one used.
 //	@Description	Otherwise, the next available IP in range is assigned.
 //	@Accept			json
@@ -44,7 +47,7 @@ type WGResponse struct {
 //	@Failure		400				{object}	nil
 //	@Failure		500				{object}	nil
 //	@Param			pubkey			body		PublicKeyRequest	true	"WireGuard public key of client"
-//	@Param			X-Forwarded-For	header		string				false	"Override source IP"
+//	@Param			X-Forwarded-For	header		string				false	"Source IP forwarded by a trusted proxy"
 //	@Router			/wg-init [post]
 func AddClientHandler(im *InterfaceManager, smdClient smdclient.SMDClientInterface) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -68,13 +71,8 @@ func AddClientHandler(im *InterfaceManager, smdClient smdclient.SMDClientInterfa
 			return
 		}
 
-		// Check for the standard header
-		clientIP := r.Header.Get("X-Forwarded-For")
-		clientIP = strings.TrimSpace(strings.Split(clientIP, ",")[0]) // Use the first IP in the list if multiple exist.
-		// If the standard header is not found, check for the RemoteAddr
-		if clientIP == "" {
-			clientIP = strings.Split(r.RemoteAddr, ":")[0] // Get the client IP from the remote address and strip the port
-		}
+		// Only trusted proxies may forward the client IP
+		clientIP := middleware.ClientIP(r)
 		if clientIP == "" {
 			http.Error(w, "Client IP not found in request headers", http.StatusBadRequest)
 			return
@@ -107,7 +105,7 @@ func AddClientHandler(im *InterfaceManager, smdClient smdclient.SMDClientInterfa
 
 		// Add the client to the WireGuard configuration.
 		log.Info().Msgf("Adding WireGuard peer: PublicKey=%s, ClientVPNIP=%s, ClientIP=%s\n", publicKey, clientVPNIP, clientIP)
-		if err := im.AddPeer(im.GetInterfaceName(), publicKey, clientVPNIP, clientIP); err != nil {
+		if err := im.AddPeer(clientIP, publicKey, clientVPNIP, clientIP, id); err != nil {
 			http.Error(w, "Failed to configure WireGuard tunnel: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -0,0 +1,57 @@
+package wgtunnel
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/OpenCHAMI/cloud-init/internal/middleware"
+	"github.com/OpenCHAMI/cloud-init/internal/smdclient"
+)
+
+func TestAddClientHandler_ClientIP(t *testing.T) {
+	backend := NewFakeBackend()
+	im := newTestInterfaceManager(t, nil, backend)
+	if err := im.StartServer(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	resolver, err := middleware.NewClientIPResolver("10.0.0.5")
+	if err != nil {
+		t.Fatalf("Failed to create client IP resolver: %v", err)
+	}
+	handler := resolver.Middleware(AddClientHandler(im, smdclient.NewFakeSMDClient("test", 10)))
+
+	testCases := []struct {
+		name           string
+		remoteAddr     string
+		expectedPeer   string
+		expectedStatus int
+	}{
+		// An untrusted host can't register a peer for the node it names,
+		// and SMD doesn't know its own IP
+		{"untrusted host", "192.168.1.10:12345", "192.168.1.10", http.StatusInternalServerError},
+		{"trusted proxy", "10.0.0.5:12345", "10.20.30.1", http.StatusCreated},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/wg-init", strings.NewReader(`{"public_key": "9NS6+NR0J38SZ9IlY9hBDLs6aBpNDhxHUHL8OTlNEDU="}`))
+			req.RemoteAddr = tc.remoteAddr
+			req.Header.Set("X-Forwarded-For", "10.20.30.1")
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tc.expectedStatus {
+				t.Errorf("Expected status %d, got %d: %s", tc.expectedStatus, rr.Code, rr.Body.String())
+			}
+			if _, ok := im.GetPeers()[tc.expectedPeer]; !ok {
+				t.Errorf("Expected a peer for %s, got %v", tc.expectedPeer, im.GetPeers())
+			}
+			if rr.Code == http.StatusCreated {
+				if _, ok := peersOf(t, backend, "wg0")["9NS6+NR0J38SZ9IlY9hBDLs6aBpNDhxHUHL8OTlNEDU="]; !ok {
+					t.Errorf("Expected the peer on the interface")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package wgtunnel
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/vishvananda/netlink"
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// NetlinkBackend configures the kernel's WireGuard interfaces through netlink.
+// It needs CAP_NET_ADMIN and the WireGuard kernel module.
+type NetlinkBackend struct {
+	client *wgctrl.Client
+}
+
+// NewNetlinkBackend opens a WireGuard control client. Close it when done.
+func NewNetlinkBackend() (*NetlinkBackend, error) {
+	client, err := wgctrl.New()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open WireGuard control client: %w", err)
+	}
+	return &NetlinkBackend{client: client}, nil
+}
+
+// Close closes the WireGuard control client
+func (b *NetlinkBackend) Close() error {
+	return b.client.Close()
+}
+
+func (b *NetlinkBackend) ConfigureInterface(name string, address net.IPNet, privateKey string, listenPort int) error {
+	link, err := netlink.LinkByName(name)
+	var notFound netlink.LinkNotFoundError
+	if errors.As(err, &notFound) {
+		attrs := netlink.NewLinkAttrs()
+		attrs.Name = name
+		if err := netlink.LinkAdd(&netlink.Wireguard{LinkAttrs: attrs}); err != nil {
+			return fmt.Errorf("failed to create interface %s: %w", name, err)
+		}
+		link, err = netlink.LinkByName(name)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to find interface %s: %w", name, err)
+	}
+
+	// Replacing the address leaves it alone if the interface already has it
+	if err := netlink.AddrReplace(link, &netlink.Addr{IPNet: &address}); err != nil {
+		return fmt.Errorf("failed to assign IP address to interface %s: %w", name, err)
+	}
+
+	key, err := wgtypes.ParseKey(privateKey)
+	if err != nil {
+		return fmt.Errorf("invalid private key: %w", err)
+	}
+	err = b.client.ConfigureDevice(name, wgtypes.Config{PrivateKey: &key, ListenPort: &listenPort})
+	if err != nil {
+		return fmt.Errorf("failed to configure WireGuard interface %s: %w", name, err)
+	}
+
+	if err := netlink.LinkSetUp(link); err != nil {
+		return fmt.Errorf("failed to bring up interface %s: %w", name, err)
+	}
+	return nil
+}
+
+func (b *NetlinkBackend) DeleteInterface(name string) error {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return fmt.Errorf("failed to find interface %s: %w", name, err)
+	}
+	if err := netlink.LinkSetDown(link); err != nil {
+		return fmt.Errorf("failed to bring down interface %s: %w", name, err)
+	}
+	if err := netlink.LinkDel(link); err != nil {
+		return fmt.Errorf("failed to delete interface %s: %w", name, err)
+	}
+	return nil
+}
+
+func (b *NetlinkBackend) InterfaceUp(name string) error {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return fmt.Errorf("failed to find interface %s: %w", name, err)
+	}
+	if link.Attrs().Flags&net.FlagUp == 0 {
+		return fmt.Errorf("interface %s is down", name)
+	}
+	return nil
+}
+
+func (b *NetlinkBackend) Device(name string) (Device, error) {
+	device, err := b.client.Device(name)
+	if err != nil {
+		return Device{}, fmt.Errorf("failed to get WireGuard interface %s: %w", name, err)
+	}
+	d := Device{ListenPort: device.ListenPort}
+	if device.PrivateKey != (wgtypes.Key{}) {
+		d.PrivateKey = device.PrivateKey.String()
+	}
+	for _, peer := range device.Peers {
+		d.Peers = append(d.Peers, DevicePeer{
+			PublicKey:     peer.PublicKey.String(),
+			AllowedIPs:    peer.AllowedIPs,
+			LastHandshake: peer.LastHandshakeTime,
+		})
+	}
+	return d, nil
+}
+
+func (b *NetlinkBackend) SetPeer(name, publicKey string, allowedIPs []net.IPNet) error {
+	key, err := wgtypes.ParseKey(publicKey)
+	if err != nil {
+		return fmt.Errorf("invalid public key: %w", err)
+	}
+	return b.client.ConfigureDevice(name, wgtypes.Config{
+		Peers: []wgtypes.PeerConfig{{PublicKey: key, ReplaceAllowedIPs: true, AllowedIPs: allowedIPs}},
+	})
+}
+
+func (b *NetlinkBackend) RemovePeer(name, publicKey string) error {
+	key, err := wgtypes.ParseKey(publicKey)
+	if err != nil {
+		return fmt.Errorf("invalid public key: %w", err)
+	}
+	return b.client.ConfigureDevice(name, wgtypes.Config{
+		Peers: []wgtypes.PeerConfig{{PublicKey: key, Remove: true}},
+	})
+}
@@ -0,0 +1,117 @@
+package wgtunnel
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/OpenCHAMI/cloud-init/internal/metrics"
+	"github.com/OpenCHAMI/cloud-init/internal/smdclient"
+	"github.com/rs/zerolog/log"
+)
+
+// reapInterval is how often the reaper looks for expired and idle peers
+const reapInterval = time.Minute
+
+// RunReaper removes expired and idle peers every minute until ctx is done. A
+// peer expires ttl after its tunnel was set up, and is idle once it hasn't
+// completed a handshake for idleTimeout. Either check is disabled if its
+// duration is 0.
+func (m *InterfaceManager) RunReaper(ctx context.Context, smd smdclient.SMDClientInterface, ttl, idleTimeout time.Duration) {
+	ticker := time.NewTicker(reapInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			m.ReapPeers(smd, now, ttl, idleTimeout)
+		}
+	}
+}
+
+// ReapPeers removes the peers that have expired or are idle at now, releases
+// their IPs, and unassigns the IPs from their nodes in SMD. Peers on the
+// interface that aren't in the peer table, such as those adopted from an
+// interface without persisted state, are removed once they are idle. It
+// returns the names of the removed peers from the table.
+func (m *InterfaceManager) ReapPeers(smd smdclient.SMDClientInterface, now time.Time, ttl, idleTimeout time.Duration) []string {
+	if ttl <= 0 && idleTimeout <= 0 {
+		return nil
+	}
+	device, err := m.backend.Device(m.interfaceName)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get WireGuard peers to reap")
+		return nil
+	}
+	handshakes := make(map[string]time.Time, len(device.Peers))
+	for _, peer := range device.Peers {
+		handshakes[peer.PublicKey] = peer.LastHandshake
+	}
+
+	m.peersMutex.Lock()
+	reaped := make(map[string]PeerConfig)
+	known := make(map[string]bool, len(m.peers))
+	for name, peer := range m.peers {
+		known[peer.PublicKey] = true
+		peer.LastHandshake = handshakes[peer.PublicKey]
+		m.peers[name] = peer
+
+		reason := peerExpiry(peer, now, ttl, idleTimeout)
+		if reason == "" {
+			continue
+		}
+		log.Info().Msgf("Reaping WireGuard peer %s with IP %s (%s)", name, peer.IP.String(), reason)
+		if _, err := m.removePeerLocked(name); err != nil {
+			log.Error().Err(err).Msgf("Failed to reap WireGuard peer %s", name)
+			continue
+		}
+		metrics.WireGuardPeersReaped.WithLabelValues(reason).Inc()
+		reaped[name] = peer
+	}
+	if idleTimeout > 0 {
+		for _, peer := range device.Peers {
+			if known[peer.PublicKey] || activeSince(peer.LastHandshake, m.created, now) < idleTimeout {
+				continue
+			}
+			log.Info().Msgf("Reaping idle WireGuard peer %s, which isn't in the peer table", peer.PublicKey)
+			if err := m.backend.RemovePeer(m.interfaceName, peer.PublicKey); err != nil {
+				log.Error().Err(err).Msgf("Failed to remove WireGuard peer %s", peer.PublicKey)
+				continue
+			}
+			for _, prefix := range peer.AllowedIPs {
+				_ = m.ipManager.Release(net.IPAddr{IP: prefix.IP}) // The IP may be outside the network
+			}
+			metrics.WireGuardPeersReaped.WithLabelValues("idle").Inc()
+		}
+	}
+	m.peersMutex.Unlock()
+
+	names := make([]string, 0, len(reaped))
+	for name, peer := range reaped {
+		names = append(names, name)
+		unassignWGIP(smd, name, peer)
+	}
+	return names
+}
+
+// peerExpiry returns why a peer should be reaped at now, "ttl" or "idle", or
+// an empty string if it shouldn't be
+func peerExpiry(peer PeerConfig, now time.Time, ttl, idleTimeout time.Duration) string {
+	if ttl > 0 && now.Sub(peer.CreatedAt) >= ttl {
+		return "ttl"
+	}
+	if idleTimeout > 0 && activeSince(peer.LastHandshake, peer.CreatedAt, now) >= idleTimeout {
+		return "idle"
+	}
+	return ""
+}
+
+// activeSince returns how long it has been since a peer was last active: its
+// latest handshake, or when it was set up if that was later
+func activeSince(lastHandshake, created, now time.Time) time.Duration {
+	if lastHandshake.Before(created) {
+		return now.Sub(created)
+	}
+	return now.Sub(lastHandshake)
+}
@@ -0,0 +1,127 @@
+package wgtunnel
+
+import (
+	"net"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/OpenCHAMI/cloud-init/internal/memstore"
+	"github.com/OpenCHAMI/cloud-init/internal/smdclient"
+)
+
+func TestReapPeers(t *testing.T) {
+	store := memstore.NewMemStore()
+	backend := NewFakeBackend()
+	im := newTestInterfaceManager(t, store, backend)
+	if err := im.StartServer(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	sm := smdclient.NewFakeSMDClient("test", 10)
+
+	// Set up a tunnel for each of three nodes, as /wg-init does
+	keys := map[string]string{
+		"10.20.30.1": "9NS6+NR0J38SZ9IlY9hBDLs6aBpNDhxHUHL8OTlNEDU=",
+		"10.20.30.2": "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=",
+		"10.20.30.3": "TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0=",
+	}
+	ids := make(map[string]string)
+	vpnIPs := make(map[string]string)
+	for name, key := range keys {
+		vpnIPs[name] = im.IpForPeer(name, key)
+		id, err := sm.IDfromIP(name)
+		if err != nil {
+			t.Fatalf("Failed to find node with IP %s: %v", name, err)
+		}
+		ids[name] = id
+		if err := sm.AddWGIP(id, vpnIPs[name]); err != nil {
+			t.Fatalf("Failed to add WireGuard IP: %v", err)
+		}
+		if err := im.AddPeer(name, key, vpnIPs[name], name, id); err != nil {
+			t.Fatalf("Failed to add peer: %v", err)
+		}
+	}
+	// A peer left on the interface by a release that didn't persist peers
+	stray := "gN65BkIKy1eCE9pP1wdc8ROUtkHLF2PfAqYdyYBz6EA="
+	_ = backend.SetPeer("wg0", stray, []net.IPNet{hostPrefix(net.ParseIP("100.97.0.99"))})
+	_ = im.ipManager.Reserve(net.IPAddr{IP: net.ParseIP("100.97.0.99")})
+
+	now := time.Now()
+	backend.SetHandshake("wg0", keys["10.20.30.1"], now.Add(50*time.Minute))
+	backend.SetHandshake("wg0", keys["10.20.30.3"], now.Add(80*time.Minute))
+
+	// Nothing is reaped while every check is disabled
+	if reaped := im.ReapPeers(sm, now.Add(24*time.Hour), 0, 0); len(reaped) != 0 {
+		t.Errorf("Expected no peers to be reaped, got %v", reaped)
+	}
+
+	// 10.20.30.2 never completed a handshake and 10.20.30.1 hasn't for an hour
+	reaped := im.ReapPeers(sm, now.Add(110*time.Minute), 4*time.Hour, time.Hour)
+	sort.Strings(reaped)
+	if len(reaped) != 2 || reaped[0] != "10.20.30.1" || reaped[1] != "10.20.30.2" {
+		t.Fatalf("Expected 10.20.30.1 and 10.20.30.2 to be reaped, got %v", reaped)
+	}
+	for _, name := range reaped {
+		if _, ok := im.GetPeers()[name]; ok {
+			t.Errorf("Expected %s to be removed from the peer table", name)
+		}
+		if _, ok := peersOf(t, backend, "wg0")[keys[name]]; ok {
+			t.Errorf("Expected %s to be removed from the interface", name)
+		}
+		if im.ipManager.IsAllocated(net.IPAddr{IP: net.ParseIP(vpnIPs[name])}) {
+			t.Errorf("Expected the IP of %s to be released", name)
+		}
+		if wgip, _ := sm.WGIPfromID(ids[name]); wgip != "" {
+			t.Errorf("Expected the WireGuard IP of %s to be unassigned, got %s", ids[name], wgip)
+		}
+	}
+	if _, ok := peersOf(t, backend, "wg0")[stray]; ok {
+		t.Errorf("Expected the idle stray peer to be removed")
+	}
+	if im.ipManager.IsAllocated(net.IPAddr{IP: net.ParseIP("100.97.0.99")}) {
+		t.Errorf("Expected the IP of the stray peer to be released")
+	}
+	if stored, _ := store.GetWireGuardPeers(); len(stored) != 1 || stored[0].Name != "10.20.30.3" {
+		t.Errorf("Expected only 10.20.30.3 to remain persisted, got %v", stored)
+	}
+	if peer := im.GetPeers()["10.20.30.3"]; !peer.LastHandshake.Equal(now.Add(80 * time.Minute)) {
+		t.Errorf("Expected the latest handshake to be tracked, got %v", peer.LastHandshake)
+	}
+
+	// 10.20.30.3 is active, but its tunnel has expired
+	reaped = im.ReapPeers(sm, now.Add(4*time.Hour+time.Minute), 4*time.Hour, time.Hour)
+	if len(reaped) != 1 || reaped[0] != "10.20.30.3" {
+		t.Errorf("Expected 10.20.30.3 to be reaped, got %v", reaped)
+	}
+	if len(im.GetPeers()) != 0 {
+		t.Errorf("Expected no peers, got %v", im.GetPeers())
+	}
+}
+
+func TestPeerExpiry(t *testing.T) {
+	created := time.Date(2024, 7, 19, 12, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		name          string
+		lastHandshake time.Time
+		now           time.Time
+		ttl           time.Duration
+		idleTimeout   time.Duration
+		expected      string
+	}{
+		{"new peer", time.Time{}, created.Add(time.Minute), time.Hour, time.Hour, ""},
+		{"no handshake since creation", time.Time{}, created.Add(time.Hour), 0, time.Hour, "idle"},
+		{"recent handshake", created.Add(30 * time.Minute), created.Add(time.Hour), 0, time.Hour, ""},
+		{"old handshake", created.Add(30 * time.Minute), created.Add(2 * time.Hour), 0, time.Hour, "idle"},
+		{"handshake from before the tunnel was set up", created.Add(-time.Hour), created.Add(30 * time.Minute), 0, time.Hour, ""},
+		{"expired", created.Add(2 * time.Hour), created.Add(2 * time.Hour), 2 * time.Hour, time.Hour, "ttl"},
+		{"checks disabled", time.Time{}, created.Add(24 * time.Hour), 0, 0, ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			peer := PeerConfig{CreatedAt: created, LastHandshake: tc.lastHandshake}
+			if reason := peerExpiry(peer, tc.now, tc.ttl, tc.idleTimeout); reason != tc.expected {
+				t.Errorf("Expected %q, got %q", tc.expected, reason)
+			}
+		})
+	}
+}
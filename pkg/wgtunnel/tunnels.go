@@ -4,17 +4,26 @@ import (
 	"errors"
 	"fmt"
 	"net"
-	"os"
-	"os/exec"
-	"strings"
+	"net/netip"
 	"sync"
+	"time"
 
+	"github.com/OpenCHAMI/cloud-init/internal/smdclient"
+	"github.com/OpenCHAMI/cloud-init/pkg/cistore"
 	"github.com/rs/zerolog/log"
 )
 
 type PeerConfig struct {
 	PublicKey string     `json:"public_key" yaml:"public_key"`
 	IP        net.IPAddr `json:"ip" yaml:"ip"`
+	// NodeID is the ID of the peer's node in SMD. It is empty for peers
+	// persisted before node IDs were kept.
+	NodeID string `json:"node_id,omitempty" yaml:"node_id,omitempty"`
+	// CreatedAt is when the peer's tunnel was set up
+	CreatedAt time.Time `json:"created_at" yaml:"created_at"`
+	// LastHandshake is the latest handshake seen by the reaper, and is zero
+	// if there hasn't been one
+	LastHandshake time.Time `json:"last_handshake" yaml:"last_handshake"`
 }
 
 type ServerConfig struct {
@@ -29,6 +38,23 @@ type Store interface {
 	GetServerConfig() (ServerConfig, error)
 }
 
+// StateStore persists the WireGuard server's keypair, listen port and peers,
+// so that tunnels survive a restart. cistore.Store implements it.
+type StateStore interface {
+	GetWireGuardServer() (cistore.WireGuardServer, error)
+	SetWireGuardServer(server cistore.WireGuardServer) error
+	GetWireGuardPeers() ([]cistore.WireGuardPeer, error)
+	SetWireGuardPeer(peer cistore.WireGuardPeer) error
+	DeleteWireGuardPeer(name string) error
+}
+
+// defaultListenPort is the port the server listens on unless another one was
+// persisted
+const defaultListenPort = 58036
+
+// ErrPeerNotFound is returned when removing a peer that isn't in the peer table
+var ErrPeerNotFound = errors.New("WireGuard peer not found")
+
 type InterfaceManager struct {
 	listenPort    int
 	interfaceName string
@@ -39,6 +65,18 @@ type InterfaceManager struct {
 	ipManager     *IPAllocator
 	privateKey    string
 	publicKey     string
+	// backend configures the WireGuard interface
+	backend Backend
+	// state persists the server and its peers. It may be nil, in which case
+	// nothing survives a restart.
+	state StateStore
+	// adoptPeers is set when there was no persisted state, e.g. when
+	// upgrading from a release that kept none. Peers found on the interface
+	// are then kept instead of being removed as stale.
+	adoptPeers bool
+	// created is when the manager was created, which the reaper uses as the
+	// latest activity of peers it knows nothing about
+	created time.Time
 }
 
 func (m *InterfaceManager) GetServerConfig() (ServerConfig, error) {
@@ -53,75 +91,142 @@ func (m *InterfaceManager) GetInterfaceName() string {
 	return m.interfaceName
 }
 
-func NewInterfaceManager(name string, localIp net.IP, network *net.IPNet) *InterfaceManager {
+// NewInterfaceManager creates a manager for the WireGuard interface with the
+// given name, serving peers in network and configured through backend. The
+// server keypair, listen port and peers are restored from state. If none were
+// persisted, those of an existing interface are adopted, so that nodes keep
+// their tunnels across an upgrade, and otherwise a new keypair is generated.
+func NewInterfaceManager(name string, localIp net.IP, network *net.IPNet, state StateStore, backend Backend) (*InterfaceManager, error) {
 	var err error
 	im := InterfaceManager{
 		interfaceName: name,
 		peers:         make(map[string]PeerConfig),
 		peersMutex:    sync.RWMutex{},
 		network:       *network,
+		backend:       backend,
+		state:         state,
+		created:       time.Now(),
 
-		listenPort: 58036,
+		listenPort: defaultListenPort,
 	}
 	im.ipManager, err = NewIPAllocator(network.String())
 	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to create IP allocator")
-	}
-	im.privateKey, err = generateKey()
-	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to generate private key")
+		return nil, fmt.Errorf("failed to create IP allocator: %w", err)
 	}
 	wgIp, err := GetUsableIP(network)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to get usable IP")
+		return nil, fmt.Errorf("failed to get usable IP: %w", err)
 	}
 	im.ipAddress = net.IPAddr{IP: wgIp, Zone: ""}
 	err = im.ipManager.Reserve(im.ipAddress)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to reserve IP address")
+		return nil, fmt.Errorf("failed to reserve IP address: %w", err)
 	}
-	return &im
-}
 
-// GetUsableIP checks if the given IP in a net.IPNet is usable. If not, it returns the first usable IP in the subnet.
-func GetUsableIP(network *net.IPNet) (net.IP, error) {
-	// Ensure the IP is IPv4 (for simplicity, extend this for IPv6 if needed)
-	ip := network.IP.To4()
-	if ip == nil {
-		return nil, errors.New("only IPv4 is supported")
+	var server cistore.WireGuardServer
+	if state != nil {
+		server, err = state.GetWireGuardServer()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load WireGuard server state: %w", err)
+		}
 	}
+	stored := server
+	if server.PrivateKey == "" {
+		if existing, err := backend.Device(name); err == nil && existing.PrivateKey != "" {
+			log.Info().Msgf("Adopting the keypair and peers of the existing %s interface", name)
+			server.PrivateKey = existing.PrivateKey
+			server.ListenPort = existing.ListenPort
+		} else {
+			server.PrivateKey, err = generateKey()
+			if err != nil {
+				return nil, fmt.Errorf("failed to generate private key: %w", err)
+			}
+		}
+		im.adoptPeers = true
+	}
+	if server.ListenPort == 0 {
+		server.ListenPort = defaultListenPort
+	}
+	server.PublicKey, err = publicKeyFor(server.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid WireGuard private key: %w", err)
+	}
+	if state != nil && server != stored {
+		if err := state.SetWireGuardServer(server); err != nil {
+			return nil, fmt.Errorf("failed to save WireGuard server state: %w", err)
+		}
+	}
+	im.privateKey = server.PrivateKey
+	im.publicKey = server.PublicKey
+	im.listenPort = server.ListenPort
 
-	// Calculate network and broadcast addresses
-	networkAddr := network.IP.Mask(network.Mask)
-	broadcastAddr := make(net.IP, len(networkAddr))
-	for i := range networkAddr {
-		broadcastAddr[i] = networkAddr[i] | ^network.Mask[i]
+	if state != nil {
+		if err := im.restorePeers(); err != nil {
+			return nil, err
+		}
 	}
+	return &im, nil
+}
 
-	// Check if the given IP is usable
-	if !ip.Equal(networkAddr) && !ip.Equal(broadcastAddr) {
-		return ip, nil
+// restorePeers loads the persisted peers and reserves their IPs. Peers whose
+// IP is no longer usable, e.g. because the network changed, are forgotten;
+// their nodes get a new IP the next time they set up a tunnel.
+func (m *InterfaceManager) restorePeers() error {
+	peers, err := m.state.GetWireGuardPeers()
+	if err != nil {
+		return fmt.Errorf("failed to load WireGuard peers: %w", err)
 	}
+	for _, peer := range peers {
+		ip := net.IPAddr{IP: net.ParseIP(peer.IP)}
+		if err := m.ipManager.Reserve(ip); err != nil {
+			log.Warn().Err(err).Msgf("Forgetting WireGuard peer %s with IP %s", peer.Name, peer.IP)
+			if err := m.state.DeleteWireGuardPeer(peer.Name); err != nil {
+				return fmt.Errorf("failed to delete WireGuard peer %s: %w", peer.Name, err)
+			}
+			continue
+		}
+		created := peer.Created
+		if created.IsZero() {
+			// Peers persisted before creation times were kept
+			created = m.created
+		}
+		m.peers[peer.Name] = PeerConfig{PublicKey: peer.PublicKey, IP: ip, NodeID: peer.NodeID, CreatedAt: created}
+	}
+	log.Info().Msgf("Restored %d WireGuard peers", len(m.peers))
+	return nil
+}
 
-	// Return the first usable IP (network address + 1)
-	firstUsableIP := make(net.IP, len(networkAddr))
-	copy(firstUsableIP, networkAddr)
-	firstUsableIP[3]++
+// GetUsableIP checks if the given IP in a net.IPNet is usable. If not, it returns the first usable IP in the subnet.
+// Both IPv4 and IPv6 networks are supported.
+func GetUsableIP(network *net.IPNet) (net.IP, error) {
+	ip, ok := toAddr(network.IP)
+	if !ok {
+		return nil, errors.New("invalid IP address " + network.IP.String())
+	}
+	ones, bits := network.Mask.Size()
+	if bits != ip.BitLen() {
+		return nil, errors.New("IP address and netmask are from different address families")
+	}
+	first, last, err := usableRange(netip.PrefixFrom(ip, ones).Masked())
+	if err != nil {
+		return nil, err
+	}
 
-	// Validate it falls within the subnet
-	if !network.Contains(firstUsableIP) {
-		return nil, errors.New("no usable IP in the subnet")
+	// Check if the given IP is usable
+	if !ip.Less(first) && !last.Less(ip) {
+		return net.IP(ip.AsSlice()), nil
 	}
 
-	return firstUsableIP, nil
+	// Return the first usable IP
+	return net.IP(first.AsSlice()), nil
 }
 
 // IpForPeer allocates an IP address for a given peer based on its name and public key.
 // If the peer already exists, it returns the existing IP address.
 // Otherwise, it allocates a new IP address for the peer and stores the peer configuration.
 func (m *InterfaceManager) IpForPeer(peerName string, publicKey string) string {
-	m.peersMutex.RLock()
-	defer m.peersMutex.RUnlock()
+	m.peersMutex.Lock()
+	defer m.peersMutex.Unlock()
 	log.Debug().Msgf("Allocating IP for peer: PeerName=%s, PublicKey=%s\n", peerName, publicKey)
 	if _, ok := m.peers[peerName]; !ok {
 		// Peer not found.  Store the peer and return the IP.
@@ -134,98 +239,178 @@ func (m *InterfaceManager) IpForPeer(peerName string, publicKey string) string {
 		m.peers[peerName] = PeerConfig{
 			IP:        ip,
 			PublicKey: publicKey,
+			CreatedAt: time.Now(),
 		}
 	} else { // Peer found.  Return the existing IP.
 		log.Debug().Msgf("Peer already exists: PeerName=%s, PublicKey=%s\n", peerName, publicKey)
-		m.peers[peerName] = PeerConfig{
-			IP:        m.peers[peerName].IP,
-			PublicKey: publicKey,
-		}
+		peer := m.peers[peerName]
+		peer.PublicKey = publicKey
+		m.peers[peerName] = peer
 	}
 	log.Debug().Msgf("Allocated IP for peer: PeerName=%s, PublicKey=%s, IP=%s\n", peerName, publicKey, m.peers[peerName].IP.IP.String())
 	return m.peers[peerName].IP.IP.String()
 }
 
-func (m *InterfaceManager) RemovePeer(peerName string) error {
+// RemovePeer tears down the tunnel of a peer: it removes the peer from the
+// interface, the peer table and the store, releases its IP, and unassigns the
+// IP from its node in SMD. It returns ErrPeerNotFound if there is no peer with
+// the given name.
+func (m *InterfaceManager) RemovePeer(smd smdclient.SMDClientInterface, peerName string) error {
 	m.peersMutex.Lock()
-	defer m.peersMutex.Unlock()
-	if err := exec.Command("wg", "set", m.interfaceName, "peer", m.peers[peerName].PublicKey, "remove").Run(); err != nil {
-		log.Error().Err(err).Msgf("Failed to remove peer (%s)", peerName)
+	peer, err := m.removePeerLocked(peerName)
+	m.peersMutex.Unlock()
+	if err != nil {
 		return err
 	}
-	delete(m.peers, peerName)
+	unassignWGIP(smd, peerName, peer)
 	return nil
 }
 
-func (m *InterfaceManager) GetPeers() map[string]PeerConfig {
-	m.peersMutex.RLock()
-	defer m.peersMutex.RUnlock()
-	return m.peers
+// RemoveNodePeer tears down the tunnel of the node with the given ID the same
+// way as RemovePeer, and returns the name of its peer. The peer is found by
+// its node ID, or for peers persisted without one, by the node's WireGuard IP
+// in SMD, so it doesn't matter which of the node's addresses set it up. It
+// returns ErrPeerNotFound if the node has no peer.
+func (m *InterfaceManager) RemoveNodePeer(smd smdclient.SMDClientInterface, id string) (string, error) {
+	if id == "" {
+		return "", fmt.Errorf("%w: no node ID", ErrPeerNotFound)
+	}
+	wgip, _ := smd.WGIPfromID(id) // The node may not have a WireGuard IP
+	m.peersMutex.Lock()
+	peerName, ok := m.peerForNodeLocked(id, net.ParseIP(wgip))
+	if !ok {
+		m.peersMutex.Unlock()
+		return "", fmt.Errorf("%w: node %q", ErrPeerNotFound, id)
+	}
+	peer, err := m.removePeerLocked(peerName)
+	m.peersMutex.Unlock()
+	if err != nil {
+		return peerName, err
+	}
+	peer.NodeID = id
+	unassignWGIP(smd, peerName, peer)
+	return peerName, nil
 }
 
-func (m *InterfaceManager) PublicKey() (string, error) {
-	return m.publicKey, nil
+// peerForNodeLocked returns the name of the peer of the node with the given
+// ID, or of the peer without a node ID whose IP is wgip. The caller must hold
+// peersMutex.
+func (m *InterfaceManager) peerForNodeLocked(id string, wgip net.IP) (string, bool) {
+	for name, peer := range m.peers {
+		if peer.NodeID == id {
+			return name, true
+		}
+	}
+	if wgip == nil {
+		return "", false
+	}
+	for name, peer := range m.peers {
+		if peer.NodeID == "" && peer.IP.IP.Equal(wgip) {
+			return name, true
+		}
+	}
+	return "", false
 }
 
-func (m *InterfaceManager) StartServer() error {
-	// Step 1: Create the WireGuard interface
-	createInterfaceCommand := exec.Command("ip", "link", "add", "dev", m.interfaceName, "type", "wireguard")
-	if out, err := createInterfaceCommand.CombinedOutput(); err != nil {
-		if !strings.Contains(err.Error(), "File exists") { // Skip if interface already exists
-			log.Warn().Str("output", string(out)).Msgf("Failed to assign IP address to interface: %v", err)
+// removePeerLocked removes a peer from the interface, the peer table and the
+// store, and releases its IP. The caller must hold peersMutex.
+func (m *InterfaceManager) removePeerLocked(peerName string) (PeerConfig, error) {
+	peer, ok := m.peers[peerName]
+	if !ok {
+		return PeerConfig{}, fmt.Errorf("%w: %q", ErrPeerNotFound, peerName)
+	}
+	if err := m.backend.RemovePeer(m.interfaceName, peer.PublicKey); err != nil {
+		return PeerConfig{}, fmt.Errorf("failed to remove WireGuard peer %s: %w", peerName, err)
+	}
+	delete(m.peers, peerName)
+	if m.state != nil {
+		if err := m.state.DeleteWireGuardPeer(peerName); err != nil {
+			log.Error().Err(err).Msgf("Failed to delete WireGuard peer %s", peerName)
 		}
 	}
+	_ = m.ipManager.Release(peer.IP) // The IP may already have been released
+	return peer, nil
+}
 
-	// Step 2: Assign IP address to the WireGuard interface
-	wgIp := m.ipAddress.IP.String()
-	ones, _ := m.network.Mask.Size() // we don't care about the number of bits in the mask
-	wgCidr := fmt.Sprintf("%s/%d", wgIp, ones)
+// unassignWGIP unassigns the IP of a removed peer from its node in SMD. Peers
+// persisted without the ID of their node are named after its IP, which SMD
+// maps to its ID.
+func unassignWGIP(smd smdclient.SMDClientInterface, peerName string, peer PeerConfig) {
+	id := peer.NodeID
+	if id == "" {
+		var err error
+		if id, err = smd.IDfromIP(peerName); err != nil {
+			log.Warn().Err(err).Msgf("Not unassigning WireGuard IP %s of peer %s, whose node is unknown", peer.IP.String(), peerName)
+			return
+		}
+	}
+	if err := smd.RemoveWGIP(id, peer.IP.IP.String()); err != nil {
+		log.Error().Err(err).Msgf("Failed to unassign WireGuard IP %s from %s", peer.IP.String(), id)
+	}
+}
 
-	if out, err := exec.Command("ip", "address", "add", "dev", m.interfaceName, wgCidr).CombinedOutput(); err != nil {
-		log.Error().Str("output", string(out)).Msgf("Failed to assign IP address to interface: %v", err)
-		return fmt.Errorf("failed to assign IP address to interface: %v", err)
+// GetPeers returns a copy of the peer table
+func (m *InterfaceManager) GetPeers() map[string]PeerConfig {
+	m.peersMutex.RLock()
+	defer m.peersMutex.RUnlock()
+	peers := make(map[string]PeerConfig, len(m.peers))
+	for name, peer := range m.peers {
+		peers[name] = peer
 	}
+	return peers
+}
+
+// GetPeer returns the peer with the given name, and whether there is one
+func (m *InterfaceManager) GetPeer(peerName string) (PeerConfig, bool) {
+	m.peersMutex.RLock()
+	defer m.peersMutex.RUnlock()
+	peer, ok := m.peers[peerName]
+	return peer, ok
+}
 
-	// Step 3: Set the private key and listen port
-	// Write the private key to a temporary file
-	tmpfile, err := os.CreateTemp("", "wg-privatekey")
+// RefreshHandshakes updates the latest handshake of every peer from the
+// interface, which is otherwise only done by the reaper
+func (m *InterfaceManager) RefreshHandshakes() error {
+	device, err := m.backend.Device(m.interfaceName)
 	if err != nil {
-		return fmt.Errorf("failed to create temporary file for private key: %v", err)
+		return fmt.Errorf("failed to get WireGuard peers: %w", err)
 	}
-	defer func() {
-		_ = os.Remove(tmpfile.Name()) // ignoring error on Remove as there's nothing we can do about it anyway
-	}()
-
-	if _, err := tmpfile.WriteString(m.privateKey); err != nil {
-		return fmt.Errorf("failed to write private key to temporary file: %v", err)
+	handshakes := make(map[string]time.Time, len(device.Peers))
+	for _, peer := range device.Peers {
+		handshakes[peer.PublicKey] = peer.LastHandshake
 	}
-	if err := tmpfile.Close(); err != nil {
-		return fmt.Errorf("failed to close temporary file: %v", err)
+	m.peersMutex.Lock()
+	defer m.peersMutex.Unlock()
+	for name, peer := range m.peers {
+		peer.LastHandshake = handshakes[peer.PublicKey]
+		m.peers[name] = peer
 	}
+	return nil
+}
 
-	// Use the temporary file in the wg set command
-	cmd := exec.Command("wg", "set", m.interfaceName, "listen-port", fmt.Sprintf("%d", m.listenPort), "private-key", tmpfile.Name())
-	if out, err := cmd.CombinedOutput(); err != nil {
-		log.Error().Str("output", string(out)).Msgf("Failed to configure WireGuard: %v", err)
-		return fmt.Errorf("failed to configure WireGuard: %v", err)
-	}
+// AllocatorStats returns how much of the tunnel network is in use
+func (m *InterfaceManager) AllocatorStats() AllocatorStats {
+	return m.ipManager.Stats()
+}
 
-	// Step 4: Bring the interface up
-	if err := exec.Command("ip", "link", "set", "up", "dev", m.interfaceName).Run(); err != nil {
-		return fmt.Errorf("failed to bring up the WireGuard interface: %v", err)
+func (m *InterfaceManager) PublicKey() (string, error) {
+	return m.publicKey, nil
+}
+
+func (m *InterfaceManager) StartServer() error {
+	// Step 1: Create, address, and bring up the WireGuard interface
+	address := net.IPNet{IP: m.ipAddress.IP, Mask: m.network.Mask}
+	if err := m.backend.ConfigureInterface(m.interfaceName, address, m.privateKey, m.listenPort); err != nil {
+		return fmt.Errorf("failed to configure WireGuard: %w", err)
 	}
 
-	// Step 5: Store the public key
-	cmd = exec.Command("wg", "show", m.interfaceName, "public-key")
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("failed to get public key: %v", err)
+	// Step 2: Make the interface's peers match the peer table
+	if err := m.reconcilePeers(); err != nil {
+		return fmt.Errorf("failed to reconcile WireGuard peers: %w", err)
 	}
-	m.publicKey = strings.TrimSpace(string(output))
 
 	log.Info().
 		Str("Interface Name", m.interfaceName).
-		Str("Private Key", m.privateKey).
 		Str("Public Key", m.publicKey).
 		Int("Listen Port", m.listenPort).
 		Str("IP Address", m.ipAddress.String()).
@@ -234,54 +419,100 @@ func (m *InterfaceManager) StartServer() error {
 
 }
 
+// InterfaceUp returns an error if the WireGuard interface doesn't exist or is
+// not up
+func (m *InterfaceManager) InterfaceUp() error {
+	return m.backend.InterfaceUp(m.interfaceName)
+}
+
 func (m *InterfaceManager) StopServer() error {
-	// Step 1: Bring the interface down
-	if err := exec.Command("ip", "link", "set", "down", "dev", m.interfaceName).Run(); err != nil {
-		return fmt.Errorf("failed to bring down the WireGuard interface: %v", err)
-	}
+	return m.backend.DeleteInterface(m.interfaceName)
+}
 
-	// Step 2: Delete the WireGuard interface
-	if err := exec.Command("ip", "link", "delete", "dev", m.interfaceName).Run(); err != nil {
-		return fmt.Errorf("failed to delete the WireGuard interface: %v", err)
+// reconcilePeers adds the peers in the table that are missing from the
+// interface, e.g. after a reboot, and removes those that aren't in the table.
+// Peers are kept instead if they are being adopted from an interface that was
+// set up without persisted state; their IPs are reserved so that they aren't
+// handed out again.
+func (m *InterfaceManager) reconcilePeers() error {
+	device, err := m.backend.Device(m.interfaceName)
+	if err != nil {
+		return err
+	}
+	current := make(map[string][]net.IPNet, len(device.Peers))
+	for _, peer := range device.Peers {
+		current[peer.PublicKey] = peer.AllowedIPs
 	}
+	m.peersMutex.RLock()
+	defer m.peersMutex.RUnlock()
 
+	known := make(map[string]bool, len(m.peers))
+	for name, peer := range m.peers {
+		known[peer.PublicKey] = true
+		allowed, want := current[peer.PublicKey], hostPrefix(peer.IP.IP)
+		if len(allowed) == 1 && allowed[0].String() == want.String() {
+			continue
+		}
+		log.Info().Msgf("Restoring WireGuard peer %s", name)
+		if err := m.addWireGuardPeer(peer.PublicKey, peer.IP.IP.String(), name); err != nil {
+			return err
+		}
+	}
+	for publicKey, allowed := range current {
+		if known[publicKey] {
+			continue
+		}
+		if m.adoptPeers {
+			log.Info().Msgf("Keeping WireGuard peer %s with allowed IPs %v", publicKey, allowed)
+			for _, prefix := range allowed {
+				_ = m.ipManager.Reserve(net.IPAddr{IP: prefix.IP}) // The IP may be outside the network, which is harmless
+			}
+			continue
+		}
+		log.Info().Msgf("Removing stale WireGuard peer %s", publicKey)
+		if err := m.backend.RemovePeer(m.interfaceName, publicKey); err != nil {
+			return fmt.Errorf("failed to remove WireGuard peer: %w", err)
+		}
+	}
 	return nil
 }
 
-func (m *InterfaceManager) AddPeer(peerName, publicKey, vpnIP, clientIP string) error {
-	m.peersMutex.RLock()
-	defer m.peersMutex.RUnlock()
+// AddPeer adds a peer to the interface and records it in the peer table,
+// along with the ID of its node in SMD
+func (m *InterfaceManager) AddPeer(peerName, publicKey, vpnIP, clientIP, nodeID string) error {
+	m.peersMutex.Lock()
+	defer m.peersMutex.Unlock()
 
 	// Add the peer to the WireGuard configuration
-	if err := AddWireGuardPeer(m.interfaceName, publicKey, vpnIP, clientIP); err != nil {
+	if err := m.addWireGuardPeer(publicKey, vpnIP, clientIP); err != nil {
 		return err
 	}
+	created := time.Now()
+	if m.state != nil {
+		err := m.state.SetWireGuardPeer(cistore.WireGuardPeer{Name: peerName, PublicKey: publicKey, IP: vpnIP, NodeID: nodeID, Created: created})
+		if err != nil {
+			return fmt.Errorf("failed to save WireGuard peer: %w", err)
+		}
+	}
 	m.peers[peerName] = PeerConfig{
 		PublicKey: publicKey,
 		IP:        net.IPAddr{IP: net.ParseIP(vpnIP), Zone: ""},
+		NodeID:    nodeID,
+		CreatedAt: created,
 	}
 	return nil
 }
 
-// AddWireGuardPeer adds a peer to the WireGuard configuration.
-func AddWireGuardPeer(interfaceID, publicKey, vpnIP, clientIP string) error {
-	allowedIPs := vpnIP + "/32"
-
-	cmd := exec.Command("wg", "set", interfaceID,
-		"peer", publicKey,
-		"allowed-ips", allowedIPs,
-	)
-	if out, err := cmd.CombinedOutput(); err != nil {
-		log.Error().Str("output", string(out)).Msgf("Failed to add WireGuard peer: %v", err)
-		return fmt.Errorf("failed to add WireGuard peer: %v", err)
+// addWireGuardPeer adds a peer to the WireGuard configuration.
+func (m *InterfaceManager) addWireGuardPeer(publicKey, vpnIP, clientIP string) error {
+	ip := net.ParseIP(vpnIP)
+	if ip == nil {
+		return fmt.Errorf("invalid VPN IP %q", vpnIP)
+	}
+	if err := m.backend.SetPeer(m.interfaceName, publicKey, []net.IPNet{hostPrefix(ip)}); err != nil {
+		log.Error().Err(err).Msg("Failed to add WireGuard peer")
+		return fmt.Errorf("failed to add WireGuard peer: %w", err)
 	}
-
-	// Update routing if necessary.
-	// cmd = exec.Command("ip", "route", "add", clientIP, "via", vpnIP, "dev", interfaceID)
-	// if out, err := cmd.CombinedOutput(); err != nil {
-	// 	log.Error().Str("output", string(out)).Msgf("Failed to update routing: %v", err)
-	// 	return fmt.Errorf("failed to update routing: %v", err)
-	// }
 
 	log.Info().
 		Str("Public Key", publicKey).
@@ -290,13 +521,3 @@ func AddWireGuardPeer(interfaceID, publicKey, vpnIP, clientIP string) error {
 		Msgf("Peer added: PublicKey=%s, VPNIP=%s, ClientIP=%s\n", publicKey, vpnIP, clientIP)
 	return nil
 }
-
-// generateKey generates a WireGuard private or public key.
-func generateKey() (string, error) {
-	cmd := exec.Command("wg", "genkey")
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return "", fmt.Errorf("failed to generate key: %v", err)
-	}
-	return strings.TrimSpace(string(output)), nil
-}
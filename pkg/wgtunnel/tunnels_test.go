@@ -0,0 +1,358 @@
+package wgtunnel
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/OpenCHAMI/cloud-init/internal/memstore"
+	"github.com/OpenCHAMI/cloud-init/internal/smdclient"
+	"github.com/OpenCHAMI/cloud-init/pkg/cistore"
+)
+
+// The example keypair from the WireGuard documentation
+const (
+	testPrivateKey = "yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk="
+	testPublicKey  = "HIgo9xNzJMWLKASShiTqIybxZ0U3wGLiUeJ1PKf8ykw="
+)
+
+func newTestInterfaceManager(t *testing.T, store StateStore, backend Backend) *InterfaceManager {
+	t.Helper()
+	wgIp, wgNet, _ := net.ParseCIDR("100.97.0.1/16")
+	im, err := NewInterfaceManager("wg0", wgIp, wgNet, store, backend)
+	if err != nil {
+		t.Fatalf("Failed to create interface manager: %v", err)
+	}
+	return im
+}
+
+// peersOf returns the allowed IPs of each peer of a fake interface
+func peersOf(t *testing.T, backend Backend, name string) map[string]string {
+	t.Helper()
+	device, err := backend.Device(name)
+	if err != nil {
+		t.Fatalf("Failed to get device: %v", err)
+	}
+	peers := make(map[string]string)
+	for _, peer := range device.Peers {
+		for _, ip := range peer.AllowedIPs {
+			peers[peer.PublicKey] += ip.String()
+		}
+	}
+	return peers
+}
+
+func TestInterfaceUp(t *testing.T) {
+	backend := NewFakeBackend()
+	m := &InterfaceManager{interfaceName: "wg0", backend: backend}
+	if err := m.InterfaceUp(); err == nil {
+		t.Errorf("Expected an error for a missing interface")
+	}
+	_, address, _ := net.ParseCIDR("100.97.0.1/16")
+	if err := backend.ConfigureInterface("wg0", *address, testPrivateKey, defaultListenPort); err != nil {
+		t.Fatalf("Failed to configure interface: %v", err)
+	}
+	if err := m.InterfaceUp(); err != nil {
+		t.Errorf("Expected the interface to be up: %v", err)
+	}
+	backend.SetInterfaceDown("wg0")
+	if err := m.InterfaceUp(); err == nil {
+		t.Errorf("Expected an error for an interface that is down")
+	}
+}
+
+func TestNetlinkBackend_InterfaceUp(t *testing.T) {
+	backend, err := NewNetlinkBackend()
+	if err != nil {
+		t.Skipf("No WireGuard control client: %v", err)
+	}
+	defer func() {
+		_ = backend.Close()
+	}()
+	if err := backend.InterfaceUp("wg-does-not-exist"); err == nil {
+		t.Errorf("Expected an error for a missing interface")
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Fatalf("Failed to list interfaces: %v", err)
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 && iface.Flags&net.FlagUp != 0 {
+			if err := backend.InterfaceUp(iface.Name); err != nil {
+				t.Errorf("Expected %s to be up: %v", iface.Name, err)
+			}
+			return
+		}
+	}
+	t.Skip("No loopback interface is up")
+}
+
+func TestNewInterfaceManager_GeneratesKey(t *testing.T) {
+	store := memstore.NewMemStore()
+	im := newTestInterfaceManager(t, store, NewFakeBackend())
+	if im.privateKey == "" || im.listenPort != defaultListenPort {
+		t.Fatalf("Expected a new key and the default port, got %q, %d", im.privateKey, im.listenPort)
+	}
+	if publicKey, _ := publicKeyFor(im.privateKey); im.publicKey != publicKey {
+		t.Errorf("Expected the public key of %s, got %s", im.privateKey, im.publicKey)
+	}
+
+	// The keypair is reused after a restart
+	restarted := newTestInterfaceManager(t, store, NewFakeBackend())
+	if restarted.privateKey != im.privateKey || restarted.publicKey != im.publicKey {
+		t.Errorf("Expected the persisted keypair, got %s", restarted.publicKey)
+	}
+}
+
+func TestNewInterfaceManager_RestoresState(t *testing.T) {
+	store := memstore.NewMemStore()
+	server := cistore.WireGuardServer{
+		PrivateKey: testPrivateKey,
+		PublicKey:  testPublicKey,
+		ListenPort: 51820,
+	}
+	if err := store.SetWireGuardServer(server); err != nil {
+		t.Fatalf("Failed to set server: %v", err)
+	}
+	peers := []cistore.WireGuardPeer{
+		{Name: "10.20.30.1", PublicKey: "9NS6+NR0J38SZ9IlY9hBDLs6aBpNDhxHUHL8OTlNEDU=", IP: "100.97.0.2"},
+		{Name: "10.20.30.2", PublicKey: "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=", IP: "100.97.0.4"},
+		// Peers with the server's IP or an IP outside the network are forgotten
+		{Name: "10.20.30.3", PublicKey: "TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0=", IP: "100.97.0.1"},
+		{Name: "10.20.30.4", PublicKey: "gN65BkIKy1eCE9pP1wdc8ROUtkHLF2PfAqYdyYBz6EA=", IP: "100.98.0.2"},
+	}
+	for _, peer := range peers {
+		if err := store.SetWireGuardPeer(peer); err != nil {
+			t.Fatalf("Failed to set peer: %v", err)
+		}
+	}
+
+	im := newTestInterfaceManager(t, store, NewFakeBackend())
+	if im.privateKey != server.PrivateKey || im.publicKey != server.PublicKey || im.listenPort != server.ListenPort {
+		t.Errorf("Expected the persisted server state, got %s, %s, %d", im.privateKey, im.publicKey, im.listenPort)
+	}
+	restored := im.GetPeers()
+	if len(restored) != 2 {
+		t.Fatalf("Expected 2 restored peers, got %v", restored)
+	}
+	if peer := restored["10.20.30.2"]; peer.PublicKey != peers[1].PublicKey || peer.IP.IP.String() != "100.97.0.4" {
+		t.Errorf("Unexpected restored peer %v", peer)
+	}
+	remaining, _ := store.GetWireGuardPeers()
+	if len(remaining) != 2 {
+		t.Errorf("Expected the unusable peers to be deleted, got %v", remaining)
+	}
+
+	// Restored peers keep their IPs, and new peers don't get them
+	if ip := im.IpForPeer("10.20.30.1", peers[0].PublicKey); ip != "100.97.0.2" {
+		t.Errorf("Expected the restored IP, got %s", ip)
+	}
+	if ip := im.IpForPeer("10.20.30.5", "bmV3IHBlZXIgcHVibGljIGtleSBmb3IgdGVzdGluZz0="); ip != "100.97.0.3" {
+		t.Errorf("Expected the lowest free IP, got %s", ip)
+	}
+}
+
+func TestStartServer_ReconcilesPeers(t *testing.T) {
+	store := memstore.NewMemStore()
+	_ = store.SetWireGuardServer(cistore.WireGuardServer{PrivateKey: testPrivateKey, PublicKey: testPublicKey, ListenPort: defaultListenPort})
+	_ = store.SetWireGuardPeer(cistore.WireGuardPeer{Name: "10.20.30.1", PublicKey: "9NS6+NR0J38SZ9IlY9hBDLs6aBpNDhxHUHL8OTlNEDU=", IP: "100.97.0.2"})
+	_ = store.SetWireGuardPeer(cistore.WireGuardPeer{Name: "10.20.30.2", PublicKey: "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=", IP: "100.97.0.3"})
+
+	// The interface survived the restart, but is missing a peer and has one
+	// that was removed while the server was down
+	backend := NewFakeBackend()
+	_, address, _ := net.ParseCIDR("100.97.0.1/16")
+	_ = backend.ConfigureInterface("wg0", *address, testPrivateKey, defaultListenPort)
+	_ = backend.SetPeer("wg0", "9NS6+NR0J38SZ9IlY9hBDLs6aBpNDhxHUHL8OTlNEDU=", []net.IPNet{hostPrefix(net.ParseIP("100.97.0.2"))})
+	_ = backend.SetPeer("wg0", "TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0=", []net.IPNet{hostPrefix(net.ParseIP("100.97.0.9"))})
+
+	im := newTestInterfaceManager(t, store, backend)
+	if err := im.StartServer(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	want := map[string]string{
+		"9NS6+NR0J38SZ9IlY9hBDLs6aBpNDhxHUHL8OTlNEDU=": "100.97.0.2/32",
+		"xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=": "100.97.0.3/32",
+	}
+	if got := peersOf(t, backend, "wg0"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected peers %v, got %v", want, got)
+	}
+}
+
+func TestStartServer_AdoptsInterface(t *testing.T) {
+	// An interface set up by a release that didn't persist its state
+	backend := NewFakeBackend()
+	_, address, _ := net.ParseCIDR("100.97.0.1/16")
+	_ = backend.ConfigureInterface("wg0", *address, testPrivateKey, 51820)
+	_ = backend.SetPeer("wg0", "9NS6+NR0J38SZ9IlY9hBDLs6aBpNDhxHUHL8OTlNEDU=", []net.IPNet{hostPrefix(net.ParseIP("100.97.0.2"))})
+
+	store := memstore.NewMemStore()
+	im := newTestInterfaceManager(t, store, backend)
+	if im.publicKey != testPublicKey || im.listenPort != 51820 {
+		t.Errorf("Expected the interface's keypair and port, got %s, %d", im.publicKey, im.listenPort)
+	}
+	server, _ := store.GetWireGuardServer()
+	if server.PrivateKey != testPrivateKey || server.PublicKey != testPublicKey || server.ListenPort != 51820 {
+		t.Errorf("Expected the adopted state to be persisted, got %v", server)
+	}
+
+	if err := im.StartServer(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	if got := peersOf(t, backend, "wg0"); got["9NS6+NR0J38SZ9IlY9hBDLs6aBpNDhxHUHL8OTlNEDU="] != "100.97.0.2/32" {
+		t.Errorf("Expected the existing peer to be kept, got %v", got)
+	}
+	// Its IP isn't handed out again
+	if ip := im.IpForPeer("10.20.30.5", "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg="); ip != "100.97.0.3" {
+		t.Errorf("Expected the next free IP, got %s", ip)
+	}
+}
+
+func TestAddAndRemovePeer(t *testing.T) {
+	store := memstore.NewMemStore()
+	backend := NewFakeBackend()
+	im := newTestInterfaceManager(t, store, backend)
+	if err := im.StartServer(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	sm := smdclient.NewFakeSMDClient("test", 10)
+
+	publicKey := "9NS6+NR0J38SZ9IlY9hBDLs6aBpNDhxHUHL8OTlNEDU="
+	ip := im.IpForPeer("10.20.30.1", publicKey)
+	id, _ := sm.IDfromIP("10.20.30.1")
+	if err := sm.AddWGIP(id, ip); err != nil {
+		t.Fatalf("Failed to add WireGuard IP: %v", err)
+	}
+	if err := im.AddPeer("10.20.30.1", publicKey, ip, "10.20.30.1", id); err != nil {
+		t.Fatalf("Failed to add peer: %v", err)
+	}
+	if got := peersOf(t, backend, "wg0"); got[publicKey] != ip+"/32" {
+		t.Errorf("Expected the peer on the interface, got %v", got)
+	}
+	if stored, _ := store.GetWireGuardPeers(); len(stored) != 1 || stored[0].Name != "10.20.30.1" || stored[0].PublicKey != publicKey || stored[0].IP != ip || stored[0].NodeID != id || !stored[0].Created.Equal(im.GetPeers()["10.20.30.1"].CreatedAt) {
+		t.Errorf("Expected the peer to be persisted, got %v", stored)
+	}
+
+	if err := im.RemovePeer(sm, "10.20.30.1"); err != nil {
+		t.Fatalf("Failed to remove peer: %v", err)
+	}
+	if got := peersOf(t, backend, "wg0"); len(got) != 0 {
+		t.Errorf("Expected no peers on the interface, got %v", got)
+	}
+	if stored, _ := store.GetWireGuardPeers(); len(stored) != 0 {
+		t.Errorf("Expected no persisted peers, got %v", stored)
+	}
+	if im.ipManager.IsAllocated(net.IPAddr{IP: net.ParseIP(ip)}) {
+		t.Errorf("Expected %s to be released", ip)
+	}
+	if wgip, _ := sm.WGIPfromID(id); wgip != "" {
+		t.Errorf("Expected the WireGuard IP of %s to be unassigned, got %s", id, wgip)
+	}
+
+	// The released IP is handed out again when the node reboots
+	if reused := im.IpForPeer("10.20.30.1", publicKey); reused != ip {
+		t.Errorf("Expected the released IP %s, got %s", ip, reused)
+	}
+}
+
+func TestRemovePeer_NodeID(t *testing.T) {
+	store := memstore.NewMemStore()
+	backend := NewFakeBackend()
+	im := newTestInterfaceManager(t, store, backend)
+	if err := im.StartServer(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	sm := smdclient.NewFakeSMDClient("test", 10)
+
+	// The node set up its tunnel from an address SMD doesn't know about
+	publicKey := "9NS6+NR0J38SZ9IlY9hBDLs6aBpNDhxHUHL8OTlNEDU="
+	ip := im.IpForPeer("fd00::1", publicKey)
+	if err := sm.AddWGIP("x3000c0b0n1", ip); err != nil {
+		t.Fatalf("Failed to add WireGuard IP: %v", err)
+	}
+	if err := im.AddPeer("fd00::1", publicKey, ip, "fd00::1", "x3000c0b0n1"); err != nil {
+		t.Fatalf("Failed to add peer: %v", err)
+	}
+
+	// The node ID survives a restart
+	im = newTestInterfaceManager(t, store, backend)
+	if peer, _ := im.GetPeer("fd00::1"); peer.NodeID != "x3000c0b0n1" {
+		t.Fatalf("Expected the node ID to be restored, got %q", peer.NodeID)
+	}
+
+	if err := im.RemovePeer(sm, "fd00::1"); err != nil {
+		t.Fatalf("Failed to remove peer: %v", err)
+	}
+	if wgip, _ := sm.WGIPfromID("x3000c0b0n1"); wgip != "" {
+		t.Errorf("Expected the WireGuard IP of x3000c0b0n1 to be unassigned, got %s", wgip)
+	}
+}
+
+func TestRemoveNodePeer(t *testing.T) {
+	store := memstore.NewMemStore()
+	backend := NewFakeBackend()
+	im := newTestInterfaceManager(t, store, backend)
+	if err := im.StartServer(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	sm := smdclient.NewFakeSMDClient("test", 10)
+
+	keys := []string{"9NS6+NR0J38SZ9IlY9hBDLs6aBpNDhxHUHL8OTlNEDU=", "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg="}
+	ip := im.IpForPeer("fd00::1", keys[0])
+	if err := sm.AddWGIP("x3000c0b0n1", ip); err != nil {
+		t.Fatalf("Failed to add WireGuard IP: %v", err)
+	}
+	if err := im.AddPeer("fd00::1", keys[0], ip, "fd00::1", "x3000c0b0n1"); err != nil {
+		t.Fatalf("Failed to add peer: %v", err)
+	}
+	// A peer persisted before node IDs were kept
+	legacyIP := im.IpForPeer("fd00::2", keys[1])
+	if err := sm.AddWGIP("x3000c0b0n2", legacyIP); err != nil {
+		t.Fatalf("Failed to add WireGuard IP: %v", err)
+	}
+	if err := im.AddPeer("fd00::2", keys[1], legacyIP, "fd00::2", ""); err != nil {
+		t.Fatalf("Failed to add peer: %v", err)
+	}
+
+	if name, err := im.RemoveNodePeer(sm, "x3000c0b0n1"); err != nil || name != "fd00::1" {
+		t.Errorf("Expected fd00::1 to be removed, got %q, %v", name, err)
+	}
+	if name, err := im.RemoveNodePeer(sm, "x3000c0b0n2"); err != nil || name != "fd00::2" {
+		t.Errorf("Expected fd00::2 to be removed by its WireGuard IP, got %q, %v", name, err)
+	}
+	for _, id := range []string{"x3000c0b0n1", "x3000c0b0n2"} {
+		if wgip, _ := sm.WGIPfromID(id); wgip != "" {
+			t.Errorf("Expected the WireGuard IP of %s to be unassigned, got %s", id, wgip)
+		}
+	}
+	if len(im.GetPeers()) != 0 {
+		t.Errorf("Expected no peers, got %v", im.GetPeers())
+	}
+	for _, id := range []string{"x3000c0b0n1", ""} {
+		if _, err := im.RemoveNodePeer(sm, id); !errors.Is(err, ErrPeerNotFound) {
+			t.Errorf("Expected ErrPeerNotFound for %q, got %v", id, err)
+		}
+	}
+}
+
+func TestRemovePeer_NotFound(t *testing.T) {
+	backend := NewFakeBackend()
+	im := newTestInterfaceManager(t, memstore.NewMemStore(), backend)
+	if err := im.StartServer(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	sm := smdclient.NewFakeSMDClient("test", 10)
+
+	// A peer that is on the interface but not in the peer table is left alone
+	_ = backend.SetPeer("wg0", "9NS6+NR0J38SZ9IlY9hBDLs6aBpNDhxHUHL8OTlNEDU=", []net.IPNet{hostPrefix(net.ParseIP("100.97.0.2"))})
+	for _, name := range []string{"", "10.20.30.9"} {
+		if err := im.RemovePeer(sm, name); !errors.Is(err, ErrPeerNotFound) {
+			t.Errorf("Expected ErrPeerNotFound for %q, got %v", name, err)
+		}
+	}
+	if got := peersOf(t, backend, "wg0"); len(got) != 1 {
+		t.Errorf("Expected the interface to be unchanged, got %v", got)
+	}
+}